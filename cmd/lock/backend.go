@@ -0,0 +1,76 @@
+package lock
+
+import (
+	"fmt"
+	"slices"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	t "statectl/internal/utils/types"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// s3OnlyCommands and s3OnlyFlags rely on features of the S3 lock that other backends do not have
+var (
+	s3OnlyCommands = []string{"force-acquire", "queue", "list", "history"}
+	s3OnlyFlags    = []string{"shared", "wait", "queue"}
+)
+
+var (
+	lockBackend   string
+	dynamoDBTable string
+	localLockDir  string
+)
+
+func init() {
+	LockCmd.PersistentFlags().StringVar(&lockBackend, "lock-backend", viper.GetString("LOCK_BACKEND"), "where the locks are stored, one of: s3, dynamodb, local (default \"s3\")")
+	LockCmd.PersistentFlags().StringVar(&dynamoDBTable, "dynamodb-table", viper.GetString("LOCK_DYNAMODB_TABLE"), "DynamoDB table the locks are stored in with --lock-backend dynamodb, with the partition key LockID")
+	LockCmd.PersistentFlags().StringVar(&localLockDir, "lock-dir", viper.GetString("LOCK_LOCAL_DIR"), "directory the locks are stored in with --lock-backend local (default \"<tmp>/statectl/locks\")")
+}
+
+// backendConfig returns the lock backend selected with the flags or the configuration.
+func backendConfig() lock.BackendConfig {
+	c := lock.ConfiguredBackend()
+	c.Backend = lockBackend
+	c.DynamoDBTable = dynamoDBTable
+	c.LocalDir = localLockDir
+	return c
+}
+
+// checkBackend fails if the backend is invalid, or does not support the command.
+func checkBackend(cmd *cobra.Command) error {
+	c := backendConfig()
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.Name() == lock.BackendS3 {
+		return nil
+	}
+
+	if slices.Contains(s3OnlyCommands, cmd.Name()) {
+		return fmt.Errorf("'lock %s' is only supported by the %s lock backend", cmd.Name(), lock.BackendS3)
+	}
+	for _, name := range s3OnlyFlags {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			return fmt.Errorf("--%s is only supported by the %s lock backend", name, lock.BackendS3)
+		}
+	}
+	return nil
+}
+
+// newLocker returns the locker of the selected backend, checked by checkBackend
+// before the command runs. The DynamoDB client is only built for its backend.
+func newLocker(cli t.S3Client) lock.Locker {
+	c := backendConfig()
+	var dynamoDBClient t.DynamoDBClient
+	if c.Name() == lock.BackendDynamoDB {
+		dynamoDBClient = utils.GetDynamoDBClient(c.DynamoDBEndpoint)
+	}
+
+	locker, err := lock.NewLocker(c, cli, dynamoDBClient)
+	if err != nil {
+		log.Fatalf("Invalid lock backend: %v", err)
+	}
+	return locker
+}
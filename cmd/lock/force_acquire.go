@@ -0,0 +1,111 @@
+package lock
+
+import (
+	"context"
+	"os"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
+	"statectl/internal/utils/ci"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	reason string
+	yes    bool
+)
+
+func init() {
+	ForceAcquireCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
+	ForceAcquireCmd.Flags().StringVarP(&key, "key", "k", viper.GetString("LOCK_KEY_PATH"), "S3 key to store the lock file")
+	addNameFlag(ForceAcquireCmd)
+	ForceAcquireCmd.Flags().DurationVar(&ttl, "ttl", viper.GetDuration("LOCK_TTL"), "lease duration of the lock, an expired lock can be taken over by another process (0 means the lock never expires)")
+	ForceAcquireCmd.Flags().StringVar(&reason, "reason", "", "why the lock is forced, stored with the lock (required)")
+	ForceAcquireCmd.Flags().BoolVarP(&yes, "yes", "y", false, "do not prompt for confirmation, required when stdin is not a terminal")
+	ci.AddFlags(ForceAcquireCmd.Flags(), &buildOverride)
+	_ = ForceAcquireCmd.MarkFlagRequired("reason")
+}
+
+var ForceAcquireCmd = &cobra.Command{
+	Use:   "force-acquire",
+	Short: "Take over the S3 lock from its current holder",
+	Long: `Take over the lock on the S3 state file from its current holder.
+This command replaces the existing lock in one step, whoever holds it. The
+previous holder is kept in the new lock as previous_owner, together with the
+reason for forcing the lock, and shown by 'lock status' and 'lock history'.
+Readers holding the lock in shared mode are force-released with the same reason.
+This command should be used with caution as the previous holder may still be
+modifying the state.
+
+Usage:
+  statectl lock force-acquire --reason reason [--yes] [--ttl duration]
+
+Example:
+  # Prompt for confirmation and then take over the lock
+  statectl lock force-acquire --reason "pipeline 1234 was cancelled"
+
+  # Take over the lock without confirmation in CI
+  statectl lock force-acquire --reason "hotfix deployment" --yes`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			log.SetLevel(logrus.DebugLevel)
+		}
+		log.Debug("Running lock force-acquire command")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		bucket, key, err := getBucketAndKey(cmd)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket and key: ", err))
+			os.Exit(1)
+		}
+		log.Debug("S3 bucket/key: ", bucket, key)
+
+		if strings.TrimSpace(reason) == "" {
+			cmd.PrintErrln(config.Red("❌ ", lock.ErrNoReason))
+			os.Exit(1)
+		}
+
+		cli := utils.GetS3Client()
+
+		if !yes {
+			exist, holder, err := lock.CheckStateLock(context.Background(), cli, bucket, key, true)
+			if err != nil {
+				log.Warnf("Failed to read the current lock: %v", err)
+			}
+			if exist {
+				cmd.Println(config.Yellow("WARNING: the lock is held by ", holder.LockID, " (signer: ", holder.Signer, ") since ", holder.TimeStamp, "."))
+			}
+			cmd.Println(config.Yellow("WARNING: You are about to take over the remote lock. The current holder may still be modifying the state."))
+			confirmed, err := confirm(cmd, "Are you sure you want to proceed?")
+			if err != nil {
+				cmd.PrintErrln(config.Red("❌ ", err))
+				os.Exit(1)
+			}
+			if !confirmed {
+				cmd.Println(config.Yellow("Force acquire cancelled."))
+				return
+			}
+		}
+
+		lockInfo := newLockInfo(ownerToken(bucket, key)).WithLease(time.Now(), ttl)
+
+		lockInfo, previous, err := lock.ForceAcquireStateLock(context.Background(), cli, bucket, key, lockInfo, reason)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to force-acquire lock: ", err))
+			os.Exit(1)
+		}
+
+		saveOwnerToken(cmd, bucket, key, lockInfo)
+
+		if previous != nil {
+			cmd.Println(config.Green("Lock taken over from ", previous.LockID, " (signer: ", previous.Signer, ")."))
+			return
+		}
+		cmd.Println(config.Green("The state was not locked, lock acquired successfully."))
+	},
+}
@@ -0,0 +1,162 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
+	t "statectl/internal/utils/types"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	eventsPrefix string
+	since        string
+	until        string
+	signer       string
+	eventTypes   []string
+	limit        int
+)
+
+func init() {
+	HistoryCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
+	HistoryCmd.Flags().StringVarP(&key, "key", "k", viper.GetString("LOCK_KEY_PATH"), "S3 key to store the lock file")
+	addNameFlag(HistoryCmd)
+	HistoryCmd.Flags().StringVar(&eventsPrefix, "prefix", viper.GetString("LOCK_EVENTS_PREFIX"), "S3 prefix the events of every lock are stored under, in a folder per lock key (default \"<key>.events/\")")
+	HistoryCmd.Flags().StringVar(&since, "since", "", "only show events after this time, as a duration ago (e.g. 24h) or an RFC3339 time")
+	HistoryCmd.Flags().StringVar(&until, "until", "", "only show events before this time, as a duration ago (e.g. 1h) or an RFC3339 time")
+	HistoryCmd.Flags().StringVar(&signer, "signer", "", "only show events of locks held by this signer")
+	HistoryCmd.Flags().StringSliceVarP(&eventTypes, "type", "t", nil, "only show events of these types, one of: "+strings.Join(t.EventTypes, ", "))
+	HistoryCmd.Flags().IntVarP(&limit, "limit", "n", 20, "maximum number of recent events to show (0 shows all of them)")
+	HistoryCmd.Flags().StringVarP(&output, "output", "o", "text", "output format, one of: text, json")
+}
+
+var HistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the recent events of the S3 lock",
+	Long: `List the recent events of the lock on the S3 state file.
+Every acquire, release, force-acquire, force-release, takeover and renewal of the lock is
+recorded as an event in the S3 bucket, so it is possible to find out who held
+the lock, for how long, and who force-released it after the lock is gone.
+
+Usage:
+  statectl lock history [--since time] [--until time] [--signer signer] [--type type]
+
+Example:
+  # Show the 20 most recent events
+  statectl lock history
+
+  # Show who force-released the lock in the last week
+  statectl lock history --since 168h --type force-release
+
+  # Print all events of a signer as JSON
+  statectl lock history --signer 1234567890 --limit 0 --output json`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			log.SetLevel(logrus.DebugLevel)
+		}
+		log.Debug("Running lock history command")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if output != "text" && output != "json" {
+			cmd.PrintErrln(config.Red("❌ Invalid output format: ", output, ", must be one of: text, json"))
+			os.Exit(1)
+		}
+
+		bucket, key, err := getBucketAndKey(cmd)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket and key: ", err))
+			os.Exit(1)
+		}
+		log.Debug("S3 bucket/key: ", bucket, key)
+
+		filter, err := newEventFilter(time.Now())
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Invalid filter: ", err))
+			os.Exit(1)
+		}
+
+		eventLog := lock.EventLog{Client: utils.GetS3Client(), Prefix: eventsPrefix}
+
+		events, err := eventLog.ListEvents(context.Background(), bucket, key, filter)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to list lock events: ", err))
+			os.Exit(1)
+		}
+
+		if output == "json" {
+			raw, err := json.MarshalIndent(events, "", "  ")
+			if err != nil {
+				cmd.PrintErrln(config.Red("❌ Failed to encode lock events: ", err))
+				os.Exit(1)
+			}
+			fmt.Println(string(raw))
+			return
+		}
+
+		if len(events) == 0 {
+			cmd.Println(config.Yellow("No lock events found."))
+			return
+		}
+		for _, event := range events {
+			printEvent(cmd, event)
+		}
+	},
+}
+
+func newEventFilter(now time.Time) (lock.EventFilter, error) {
+	filter := lock.EventFilter{Signer: signer, Limit: limit}
+
+	for _, eventType := range eventTypes {
+		if !slices.Contains(t.EventTypes, eventType) {
+			return filter, fmt.Errorf("unknown event type %q, must be one of: %s", eventType, strings.Join(t.EventTypes, ", "))
+		}
+	}
+	filter.Types = eventTypes
+
+	var err error
+	if filter.Since, err = parseEventTime(since, now); err != nil {
+		return filter, fmt.Errorf("--since: %w", err)
+	}
+	if filter.Until, err = parseEventTime(until, now); err != nil {
+		return filter, fmt.Errorf("--until: %w", err)
+	}
+	return filter, nil
+}
+
+// parseEventTime accepts a duration before now or an RFC3339 time.
+func parseEventTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func printEvent(cmd *cobra.Command, event t.LockEvent) {
+	line := fmt.Sprintf("%s  %-13s  %s  signer: %s  by: %s", event.Time, event.Type, event.LockID, event.Signer, event.Actor)
+	if event.HeldFor != "" {
+		line += "  held for: " + event.HeldFor
+	}
+	if event.Reason != "" {
+		line += "  reason: " + event.Reason
+	}
+
+	switch event.Type {
+	case t.EventForceRelease, t.EventTakeover, t.EventForceAcquire:
+		cmd.Println(config.Yellow(line))
+	default:
+		cmd.Println(line)
+	}
+}
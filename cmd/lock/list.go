@@ -0,0 +1,123 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
+	t "statectl/internal/utils/types"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var listPrefix string
+
+func init() {
+	ListCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
+	ListCmd.Flags().StringVar(&listPrefix, "prefix", "", "only list the locks whose key starts with this prefix (default: the prefix of LOCK_KEY_TEMPLATE)")
+	ListCmd.Flags().StringVarP(&output, "output", "o", "text", "output format, one of: text, json")
+}
+
+type listedLock struct {
+	t.NamedLock
+	Age     string `json:"age,omitempty"`
+	Expired bool   `json:"expired"`
+}
+
+var ListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the named locks and their holders",
+	Long: `List the named locks in the S3 bucket together with their holders.
+Named locks are acquired with --name and stored at the key given by the
+LOCK_KEY_TEMPLATE setting (default "` + lock.DefaultKeyTemplate + `"), where {name} is
+replaced by the name of the lock. This command lists every lock matching the
+template, optionally under a narrower prefix.
+
+Usage:
+  statectl lock list [--prefix prefix] [--output text|json]
+
+Example:
+  # List every named lock and who holds it
+  statectl lock list
+
+  # List the locks whose name starts with "prod"
+  statectl lock list --prefix locks/prod
+
+  # Print the locks as JSON for scripts
+  statectl lock list --output json`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			log.SetLevel(logrus.DebugLevel)
+		}
+		log.Debug("Running lock list command")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if output != "text" && output != "json" {
+			cmd.PrintErrln(config.Red("❌ Invalid output format: ", output, ", must be one of: text, json"))
+			os.Exit(1)
+		}
+		if bucket == "" {
+			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket: bucket is required"))
+			os.Exit(1)
+		}
+
+		cli := utils.GetS3Client()
+
+		locks, err := lock.ListLocks(context.Background(), cli, bucket, keyTemplate(), listPrefix)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to list locks: ", err))
+			os.Exit(1)
+		}
+
+		now := time.Now()
+		listed := make([]listedLock, 0, len(locks))
+		for _, named := range locks {
+			entry := listedLock{NamedLock: named, Expired: named.Lock.Expired(now)}
+			if age, ok := named.Lock.Age(now); ok {
+				entry.Age = age.Round(time.Second).String()
+			}
+			listed = append(listed, entry)
+		}
+
+		if output == "json" {
+			raw, err := json.MarshalIndent(listed, "", "  ")
+			if err != nil {
+				cmd.PrintErrln(config.Red("❌ Failed to encode locks: ", err))
+				os.Exit(1)
+			}
+			fmt.Println(string(raw))
+			return
+		}
+		printLocks(cmd, listed)
+	},
+}
+
+func printLocks(cmd *cobra.Command, locks []listedLock) {
+	if len(locks) == 0 {
+		cmd.Println(config.Green("No locks are held."))
+		return
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tKEY\tLOCK ID\tSIGNER\tAGE\tEXPIRES")
+	for _, entry := range locks {
+		expires := entry.Lock.ExpiresAt
+		switch {
+		case expires == "":
+			expires = "never"
+		case entry.Expired:
+			expires += " (expired)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Name, entry.Key, entry.Lock.LockID, entry.Lock.Signer, entry.Age, expires)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Error printing locks: %v", err)
+	}
+}
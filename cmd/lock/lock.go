@@ -6,34 +6,59 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"statectl/internal/aws/lock"
 	"statectl/internal/aws/utils"
 	"statectl/internal/config"
-	"statectl/internal/utils/subproc"
+	"statectl/internal/utils/ci"
+	"statectl/internal/utils/owner"
 	t "statectl/internal/utils/types"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	bucket string
-	key    string
+	bucket       string
+	key          string
+	ttl          time.Duration
+	wait         bool
+	timeout      time.Duration
+	pollInterval time.Duration
+	shared       bool
+	queue        bool
+	// buildOverride holds the build info given with flags, which takes
+	// precedence over the CI provider's variables
+	buildOverride t.BuildInfo
 )
 
 func init() {
 	AcquireCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
 	AcquireCmd.Flags().StringVarP(&key, "key", "k", viper.GetString("LOCK_KEY_PATH"), "S3 key to store the lock file")
+	addNameFlag(AcquireCmd)
+	AcquireCmd.Flags().DurationVar(&ttl, "ttl", viper.GetDuration("LOCK_TTL"), "lease duration of the lock, an expired lock can be taken over by another process (0 means the lock never expires)")
+	AcquireCmd.Flags().BoolVarP(&wait, "wait", "w", false, "wait for the lock to be released instead of failing when it is held")
+	AcquireCmd.Flags().DurationVar(&timeout, "timeout", viper.GetDuration("LOCK_WAIT_TIMEOUT"), "maximum time to wait for the lock with --wait (0 means wait forever)")
+	AcquireCmd.Flags().DurationVar(&pollInterval, "poll-interval", lock.DefaultPollInterval, "initial delay between two attempts with --wait, doubled on every attempt")
+	AcquireCmd.Flags().BoolVar(&queue, "queue", false, "wait in line: the waiters acquire the lock in the order they started to wait (implies --wait)")
+	AcquireCmd.Flags().BoolVar(&shared, "shared", false, "acquire the lock in shared mode, held together with other readers of the state but not with a writer")
+	ci.AddFlags(AcquireCmd.Flags(), &buildOverride)
 
 	ReleaseCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
 	ReleaseCmd.Flags().StringVarP(&key, "key", "k", viper.GetString("LOCK_KEY_PATH"), "S3 key to store the lock file")
+	addNameFlag(ReleaseCmd)
+	ReleaseCmd.Flags().BoolVar(&shared, "shared", false, "release the shared lock acquired with 'lock acquire --shared'")
 
 	ForceReleaseCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
 	ForceReleaseCmd.Flags().StringVarP(&key, "key", "k", viper.GetString("LOCK_KEY_PATH"), "S3 key to store the lock file")
+	addNameFlag(ForceReleaseCmd)
+	ForceReleaseCmd.Flags().StringVar(&reason, "reason", "", "why the lock is force-released, recorded before the lock is removed")
+	ForceReleaseCmd.Flags().BoolVarP(&yes, "yes", "y", false, "do not prompt for confirmation, required when stdin is not a terminal")
+	ForceReleaseCmd.Flags().BoolVar(&shared, "shared", false, "release every shared lock held by readers instead of the exclusive lock")
 }
 
 var AcquireCmd = &cobra.Command{
@@ -43,14 +68,48 @@ var AcquireCmd = &cobra.Command{
 This command attempts to create a lock file in the specified S3 bucket, which
 signals to other users and processes that the state file is currently being
 modified. If the lock is already present, the command will fail and indicate
-that the state file is in use.
+that the state file is in use, unless the lease of the existing lock has
+expired, in which case the lock is taken over.
+
+The commit, branch, pipeline ID, job URL and actor of the build are read from
+the variables of the CI provider (GitHub Actions, GitLab CI, Jenkins, CircleCI,
+Buildkite or Azure Pipelines) and can be overridden with flags.
+
+With --shared, the lock is acquired for reading the state: any number of
+readers can hold it together, while a writer holding the exclusive lock blocks
+them, and the other way round. Release it with 'lock release --shared'.
+
+With --wait, the command keeps retrying with a jittered exponential backoff
+until the lock is released, the timeout is reached or it is interrupted.
+With --queue, the waiters take a ticket and acquire the lock strictly in the
+order they started to wait, so an older pipeline is never starved by newer
+ones. The tickets of waiters that went away expire and are skipped. Use
+'lock queue' to see the waiters.
 
 Usage:
-  statectl lock acquire
+  statectl lock acquire [--name name] [--shared] [--ttl duration] [--wait|--queue [--timeout duration] [--poll-interval duration]]
 
 Example:
   # Acquire a lock on the S3 state file
-  statectl lock acquire`,
+  statectl lock acquire
+
+  # Acquire the lock named prod, stored at "locks/prod.lock" by default
+  statectl lock acquire --name prod
+
+  # Acquire a shared lock to compare against the remote manifest
+  statectl lock acquire --shared --ttl 30m
+
+  # Acquire a lock that other pipelines may take over after 2 hours
+  statectl lock acquire --ttl 2h
+
+  # Wait up to 30 minutes for the lock to be released
+  statectl lock acquire --wait --timeout 30m
+
+  # Wait for the lock in line with the other pipelines
+  statectl lock acquire --queue
+
+  # Acquire a lock outside of a supported CI provider
+  statectl lock acquire --commit "$(git rev-parse HEAD)" --pipeline-id 42`,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
 			log.SetLevel(logrus.DebugLevel)
@@ -58,60 +117,40 @@ Example:
 		log.Debug("Running lock acquire command")
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		extra_comment := ""
-
-		bucket, key, err := utils.GetS3BucketAndKey(cmd)
+		bucket, key, err := getBucketAndKey(cmd)
 		if err != nil {
 			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket and key: ", err))
 			os.Exit(1)
 		}
 		log.Debug("S3 bucket/key: ", bucket, key)
 
-		commit_sha := os.Getenv("CI_COMMIT_SHA")
-		cs_comment := "ok"
-		if commit_sha == "" {
-			commit_sha, err = subproc.FetchLocalSHA()
-			cs_comment = "No CI commit SHA available, using local commit SHA"
-			if err != nil {
-				commit_sha = uuid.New().String()
-				cs_comment = "No commit SHA available, using random UUID"
-			}
-		}
-
-		trigger_iid := os.Getenv("CI_PIPELINE_IID")
-		ti_comment := "ok"
-		if trigger_iid == "" {
-			trigger_iid = uuid.New().String()
-			ti_comment = "No pipeline ID available, using random UUID"
-		}
-
-		if cs_comment != "ok" || ti_comment != "ok" {
-			extra_comment = "WARNING: one or more environment variables were not found. Use timestamp as reference to check the exact commit and pipeline ID."
-		}
-
-		lockInfo := t.LockInfo{
-			LockID:    commit_sha,
-			TimeStamp: time.Now().Format(time.RFC3339),
-			Signer:    trigger_iid,
-			Comments: t.Comments{
-				Commit:  cs_comment,
-				Trigger: ti_comment,
-				Extra:   extra_comment,
-			},
-		}
+		lockInfo := newLockInfo(ownerToken(bucket, key)).WithLease(time.Now(), ttl)
 
 		cli := utils.GetS3Client()
 
-		if err := lock.AcquireStateLock(context.Background(), cli, bucket, key, lockInfo); err != nil {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		lockInfo, err = acquireLock(ctx, cli, bucket, key, lockInfo)
+		if err != nil {
 			if errors.Is(err, lock.ErrLockExists) {
 				cmd.Println(config.Yellow("Lock already acquired, exiting..."))
 				os.Exit(0)
 			}
-			cmd.PrintErrln(config.Red("❌ Failed to acquire lock: ", err))
-			os.Exit(1)
+			exitOnAcquireError(cmd, err)
 		}
 
-		cmd.Println(config.Green("Lock acquired successfully."))
+		saveOwnerToken(cmd, bucket, key, lockInfo)
+
+		acquired := "Lock"
+		if lockInfo.Shared() {
+			acquired = "Shared lock"
+		}
+		if lockInfo.ExpiresAt != "" {
+			cmd.Println(config.Green(acquired, " acquired successfully, lease expires at ", lockInfo.ExpiresAt, "."))
+			return
+		}
+		cmd.Println(config.Green(acquired, " acquired successfully."))
 	},
 }
 
@@ -123,8 +162,12 @@ This command removes the lock file from the S3 bucket, indicating that
 the state file is no longer being modified and is available for other
 users and processes to modify.
 
+Only the owner of the lock can release it. The owner token is saved by
+'lock acquire' in the workspace, or can be passed with the
+STATECTL_LOCK_TOKEN environment variable from another job.
+
 Usage:
-  statectl lock release
+  statectl lock release [--name name] [--shared]
 
 Example:
   # Release the lock on the S3 state file
@@ -136,19 +179,33 @@ Example:
 		log.Debug("Running lock release command")
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		bucket, key, err := utils.GetS3BucketAndKey(cmd)
+		bucket, key, err := getBucketAndKey(cmd)
 		if err != nil {
 			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket and key: ", err))
 			os.Exit(1)
 		}
 		log.Debug("S3 bucket/key: ", bucket, key)
 
+		token, err := owner.Token(bucket, key)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to get the owner token of the lock: ", err))
+			cmd.PrintErrln(config.Yellow("Set ", owner.TokenEnv, " to the token printed by 'lock acquire', or use the force-release command."))
+			os.Exit(1)
+		}
+
 		cli := utils.GetS3Client()
 
-		if err := lock.ReleaseStateLock(context.Background(), cli, bucket, key); err != nil {
+		release := newLocker(cli).Release
+		if shared {
+			release = func(ctx context.Context, bucket, key, token string) error {
+				return lock.ReleaseSharedLock(ctx, cli, bucket, key, token)
+			}
+		}
+		if err := release(context.Background(), bucket, key, token); err != nil {
 			cmd.PrintErrln(config.Red("❌ Failed to release lock: ", err))
 			os.Exit(1)
 		}
+		removeOwnerToken(bucket, key)
 		fmt.Println(config.Green("Lock released successfully."))
 	},
 }
@@ -158,14 +215,23 @@ var ForceReleaseCmd = &cobra.Command{
 	Short: "Force release the S3 lock with confirmation",
 	Long: `Forcefully releases the lock on the S3 state file after user confirmation.
 This command should be used with caution as it can disrupt ongoing operations.
-It synchronizes the local state with the latest state from the S3 bucket.
+Before the lock is removed, a record of the removed lock, who removed it and
+why is written under "<key>.force-releases/" in the S3 bucket. With --shared,
+the shared locks of the readers are removed instead, e.g. when a crashed reader
+without a lease blocks the writers.
+
+When stdin is not a terminal, e.g. in CI, the command fails unless --yes is
+given instead of waiting for a confirmation.
 
 Usage:
-  statectl lock force-release
+  statectl lock force-release [--name name] [--shared] [--reason reason] [--yes]
 
 Example:
   # Prompt for confirmation and then force release the S3 lock
-  statectl lock force-release`,
+  statectl lock force-release --reason "pipeline 1234 is stuck"
+
+  # Force release the S3 lock from a cleanup job
+  statectl lock force-release --reason "nightly cleanup" --yes`,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
 			log.SetLevel(logrus.DebugLevel)
@@ -173,7 +239,7 @@ Example:
 		log.Debug("Preparing to prompt for lock force-release")
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		bucket, key, err := utils.GetS3BucketAndKey(cmd)
+		bucket, key, err := getBucketAndKey(cmd)
 		if err != nil {
 			cmd.PrintErrln(config.Red("Failed to get S3 bucket and key: ", err))
 			os.Exit(1)
@@ -182,7 +248,13 @@ Example:
 
 		cli := utils.GetS3Client()
 
-		if exist, _, err := lock.CheckStateLock(context.Background(), cli, bucket, key, false); err != nil {
+		if shared {
+			forceReleaseShared(cmd, cli, bucket, key)
+			return
+		}
+
+		locker := newLocker(cli)
+		if exist, _, err := locker.Status(context.Background(), bucket, key); err != nil {
 			cmd.PrintErrln(config.Red("❌ Failed to check lock status: ", err))
 			os.Exit(1)
 		} else if !exist {
@@ -190,23 +262,143 @@ Example:
 			os.Exit(1)
 		}
 
-		reader := bufio.NewReader(os.Stdin)
-
-		cmd.Println(config.Yellow("WARNING: You are about to forcefully remove the remote lock file. This may disrupt ongoing operations."))
-		cmd.Println(config.Cyan("Are you sure you want to proceed? (type 'yes' to confirm): "))
-
-		confirmation, _ := reader.ReadString('\n')
-		if strings.TrimSpace(confirmation) != "yes" {
-			cmd.Println(config.Yellow("Force release cancelled."))
-			return
+		if !yes {
+			cmd.Println(config.Yellow("WARNING: You are about to forcefully remove the remote lock file. This may disrupt ongoing operations."))
+			confirmed, err := confirm(cmd, "Are you sure you want to proceed?")
+			if err != nil {
+				cmd.PrintErrln(config.Red("❌ ", err))
+				os.Exit(1)
+			}
+			if !confirmed {
+				cmd.Println(config.Yellow("Force release cancelled."))
+				return
+			}
 		}
 
 		// User confirmed, proceed with force release
-		err = lock.ForceReleaseLock(context.Background(), cli, bucket, key)
+		err = locker.ForceRelease(context.Background(), bucket, key, reason)
 		if err != nil {
 			cmd.PrintErrln(config.Red("Failed to force release lock: ", err))
 			os.Exit(1)
 		}
+		removeOwnerToken(bucket, key)
 		fmt.Println(config.Green("Lock forcefully released successfully."))
 	},
 }
+
+// forceReleaseShared removes every shared lock of the state after confirmation.
+func forceReleaseShared(cmd *cobra.Command, cli t.S3Client, bucket, key string) {
+	readers, err := lock.ListSharedLocks(context.Background(), cli, bucket, key)
+	if err != nil {
+		cmd.PrintErrln(config.Red("❌ Failed to list the shared locks: ", err))
+		os.Exit(1)
+	}
+	if len(readers) == 0 {
+		cmd.PrintErrln(config.Red("❌ No shared lock exists. Nothing to release."))
+		os.Exit(1)
+	}
+
+	if !yes {
+		cmd.Println(config.Yellow("WARNING: You are about to forcefully remove ", len(readers), " shared lock(s). The readers may still be reading the state."))
+		confirmed, err := confirm(cmd, "Are you sure you want to proceed?")
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ ", err))
+			os.Exit(1)
+		}
+		if !confirmed {
+			cmd.Println(config.Yellow("Force release cancelled."))
+			return
+		}
+	}
+
+	released, err := lock.ForceReleaseSharedLocks(context.Background(), cli, bucket, key, reason)
+	if err != nil {
+		cmd.PrintErrln(config.Red("Failed to force release the shared locks after releasing ", released, " of them: ", err))
+		os.Exit(1)
+	}
+	fmt.Println(config.Green(released, " shared lock(s) forcefully released successfully."))
+}
+
+// confirm asks the user to type 'yes' on stdin. It fails when stdin is not a
+// terminal, so non-interactive jobs do not cancel silently.
+func confirm(cmd *cobra.Command, prompt string) (bool, error) {
+	if !isTerminal(os.Stdin) {
+		return false, errors.New("stdin is not a terminal, use --yes to confirm in non-interactive mode")
+	}
+
+	cmd.Println(config.Cyan(prompt, " (type 'yes' to confirm): "))
+	confirmation, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(confirmation) == "yes", nil
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// acquireLock acquires the lock, waiting for it to be released if --wait is set.
+func acquireLock(ctx context.Context, cli t.S3Client, bucket, key string, lockInfo t.LockInfo) (t.LockInfo, error) {
+	if !wait && !queue {
+		if shared {
+			return lock.AcquireSharedLock(ctx, cli, bucket, key, lockInfo)
+		}
+		return newLocker(cli).Acquire(ctx, bucket, key, lockInfo)
+	}
+	return lock.WaitAcquireStateLock(ctx, cli, bucket, key, lockInfo, lock.WaitOptions{
+		Timeout:      timeout,
+		PollInterval: pollInterval,
+		Shared:       shared,
+		Queue:        queue,
+	})
+}
+
+// exitOnAcquireError prints why the lock could not be acquired and exits.
+func exitOnAcquireError(cmd *cobra.Command, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		cmd.PrintErrln(config.Red("❌ Timed out after ", timeout, " waiting for the lock"))
+		os.Exit(1)
+	}
+	if errors.Is(err, context.Canceled) {
+		cmd.PrintErrln(config.Yellow("Interrupted while waiting for the lock."))
+		os.Exit(130)
+	}
+	cmd.PrintErrln(config.Red("❌ Failed to acquire lock: ", err))
+	os.Exit(1)
+}
+
+// ownerToken returns the token to acquire the lock with. A token saved by an earlier
+// acquisition in this workspace is reused, so acquiring twice is not an error.
+func ownerToken(bucket, key string) string {
+	if token, err := owner.Token(bucket, key); err == nil {
+		log.Debug("Reusing the owner token of this workspace")
+		return token
+	}
+	return owner.NewToken()
+}
+
+// saveOwnerToken stores the owner and fencing tokens of an acquired lock for
+// release, renew and manifest push.
+func saveOwnerToken(cmd *cobra.Command, bucket, key string, lockInfo t.LockInfo) {
+	token := lockInfo.OwnerToken
+	path, err := owner.Save(bucket, key, token, lockInfo.FencingToken)
+	if err != nil {
+		cmd.PrintErrln(config.Yellow("WARNING: failed to save the owner token, export ", owner.TokenEnv, "=", token, " to release the lock: ", err))
+		return
+	}
+	cmd.Printf("Owner token saved to %s, export %s=%s to use it in other jobs.\n", path, owner.TokenEnv, token)
+	cmd.Printf("Fencing token of the lock: %d, export %s=%d to push the manifest from other jobs.\n", lockInfo.FencingToken, owner.FencingTokenEnv, lockInfo.FencingToken)
+}
+
+// removeOwnerToken deletes the stored owner token of a lock that is gone.
+func removeOwnerToken(bucket, key string) {
+	if err := owner.Remove(bucket, key); err != nil {
+		log.Warnf("Failed to remove the owner token: %v", err)
+	}
+}
+
+// newLockInfo describes the lock of the running pipeline, using the build info
+// of the CI provider and the flags.
+func newLockInfo(token string) t.LockInfo {
+	return lock.NewLockInfo(token, ci.Resolve(buildOverride))
+}
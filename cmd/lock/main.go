@@ -1,17 +1,32 @@
 package lock
 
 import (
+	"os"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
 	"statectl/internal/logging"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
+// webhook notifies the lock events of the command, if LOCK_WEBHOOK_URL is set
+var webhook *lock.Webhook
+
 func init() {
 	LockCmd.AddCommand(
 		AcquireCmd,
 		ReleaseCmd,
 		ForceReleaseCmd,
+		ForceAcquireCmd,
+		StatusCmd,
+		RenewCmd,
+		RunCmd,
+		HistoryCmd,
+		ListCmd,
+		QueueCmd,
 	)
 
 	var verbose bool
@@ -30,6 +45,29 @@ With these commands, you can acquire and release locks to coordinate safe access
 to the state file among multiple developers or automation tools. Use refresh to
 update your local state from S3, and sync to update the remote state in S3 with
 your local changes after acquiring a lock.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := checkBackend(cmd); err != nil {
+			cmd.PrintErrln(config.Red("❌ ", err))
+			os.Exit(1)
+		}
+
+		// Keep an audit trail of every lock operation of the subcommands
+		if eventLog, ok := backendConfig().EventLog(utils.GetS3Client(), viper.GetString("LOCK_EVENTS_PREFIX")); ok {
+			lock.AddRecorder(eventLog)
+		}
+
+		var err error
+		if webhook, err = lock.ConfiguredWebhook(); err != nil {
+			cmd.PrintErrln(config.Red("❌ Invalid lock webhook: ", err))
+			os.Exit(1)
+		}
+		if webhook != nil {
+			lock.AddRecorder(webhook)
+		}
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		webhook.WaitTimeout(lock.DefaultWebhookWait)
+	},
 	PreRun: func(cmd *cobra.Command, args []string) {
 		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
 			log.SetLevel(logrus.DebugLevel)
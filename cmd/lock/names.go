@@ -0,0 +1,45 @@
+package lock
+
+import (
+	"errors"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// name is the lock name given with --name, mapped to a key through LOCK_KEY_TEMPLATE
+var name string
+
+// addNameFlag adds the --name flag to a command that works on one lock.
+func addNameFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&name, "name", "", "name of the lock, mapped to its S3 key through LOCK_KEY_TEMPLATE (default \""+lock.DefaultKeyTemplate+"\")")
+	cmd.MarkFlagsMutuallyExclusive("name", "key")
+}
+
+// keyTemplate returns the template that maps lock names to keys.
+func keyTemplate() string {
+	if template := viper.GetString("LOCK_KEY_TEMPLATE"); template != "" {
+		return template
+	}
+	return lock.DefaultKeyTemplate
+}
+
+// getBucketAndKey returns the bucket and the key of the lock, resolving the key
+// from the lock name when --name is given.
+func getBucketAndKey(cmd *cobra.Command) (string, string, error) {
+	if name == "" {
+		return utils.GetS3BucketAndKey(cmd)
+	}
+
+	bucket := cmd.Flag("bucket").Value.String()
+	if bucket == "" {
+		return "", "", errors.New("bucket is required")
+	}
+	key, err := lock.KeyFromTemplate(keyTemplate(), name)
+	if err != nil {
+		return "", "", err
+	}
+	return bucket, key, nil
+}
@@ -0,0 +1,111 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
+	t "statectl/internal/utils/types"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	QueueCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
+	QueueCmd.Flags().StringVarP(&key, "key", "k", viper.GetString("LOCK_KEY_PATH"), "S3 key to store the lock file")
+	addNameFlag(QueueCmd)
+	QueueCmd.Flags().StringVarP(&output, "output", "o", "text", "output format, one of: text, json")
+}
+
+var QueueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Show the waiters queued for the S3 lock",
+	Long: `Show the waiters queued for the lock on the S3 state file.
+Waiters started with 'lock acquire --queue' or 'lock run --queue' take a ticket
+under "<key>.queue/" in the S3 bucket and acquire the lock in ticket order. A
+waiter renews its ticket while it waits; the ticket of a waiter that went away
+expires and is skipped by the others.
+
+Usage:
+  statectl lock queue [--output text|json]
+
+Example:
+  # Show who is waiting for the lock, in the order they will get it
+  statectl lock queue
+
+  # Print the queue as JSON for scripts
+  statectl lock queue --output json`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			log.SetLevel(logrus.DebugLevel)
+		}
+		log.Debug("Running lock queue command")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if output != "text" && output != "json" {
+			cmd.PrintErrln(config.Red("❌ Invalid output format: ", output, ", must be one of: text, json"))
+			os.Exit(1)
+		}
+
+		bucket, key, err := getBucketAndKey(cmd)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket and key: ", err))
+			os.Exit(1)
+		}
+		log.Debug("S3 bucket/key: ", bucket, key)
+
+		cli := utils.GetS3Client()
+
+		tickets, err := lock.ListQueue(context.Background(), cli, bucket, key)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to list the lock queue: ", err))
+			os.Exit(1)
+		}
+
+		if output == "json" {
+			raw, err := json.MarshalIndent(tickets, "", "  ")
+			if err != nil {
+				cmd.PrintErrln(config.Red("❌ Failed to encode the lock queue: ", err))
+				os.Exit(1)
+			}
+			fmt.Println(string(raw))
+			return
+		}
+
+		if len(tickets) == 0 {
+			cmd.Println(config.Green("No one is waiting for the lock."))
+			return
+		}
+
+		now := time.Now()
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "POSITION\tTICKET\tLOCK ID\tSIGNER\tMODE\tWAITING")
+		position := 0
+		for _, ticket := range tickets {
+			place := "abandoned"
+			if !ticket.Expired(now) {
+				position++
+				place = fmt.Sprint(position)
+			}
+			mode := ticket.Mode
+			if mode == "" {
+				mode = t.LockModeExclusive
+			}
+			waiting := ""
+			if createdAt, err := time.Parse(time.RFC3339Nano, ticket.CreatedAt); err == nil {
+				waiting = now.Sub(createdAt).Round(time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", place, ticket.Number, ticket.LockID, ticket.Signer, mode, waiting)
+		}
+		if err := w.Flush(); err != nil {
+			log.Errorf("Error printing the lock queue: %v", err)
+		}
+	},
+}
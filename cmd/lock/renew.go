@@ -0,0 +1,88 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
+	"statectl/internal/utils/owner"
+	t "statectl/internal/utils/types"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RenewCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
+	RenewCmd.Flags().StringVarP(&key, "key", "k", viper.GetString("LOCK_KEY_PATH"), "S3 key to store the lock file")
+	addNameFlag(RenewCmd)
+	RenewCmd.Flags().DurationVar(&ttl, "ttl", 0, "new lease duration of the lock (0 keeps the lease duration the lock was acquired with)")
+	RenewCmd.Flags().BoolVar(&shared, "shared", false, "renew the shared lock acquired with 'lock acquire --shared'")
+}
+
+var RenewCmd = &cobra.Command{
+	Use:   "renew",
+	Short: "Renew the lease of the S3 lock held by this process",
+	Long: `Renew the lease of the lock on the S3 state file held by this process.
+This command updates the heartbeat of the lock and extends its expiry, which
+shows other pipelines that the holder is still alive. Run it periodically
+during long operations that were started with 'lock acquire --ttl'.
+
+Usage:
+  statectl lock renew [--shared] [--ttl duration]
+
+Example:
+  # Extend the lease by the duration the lock was acquired with
+  statectl lock renew
+
+  # Extend the lease by 30 minutes from now
+  statectl lock renew --ttl 30m`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			log.SetLevel(logrus.DebugLevel)
+		}
+		log.Debug("Running lock renew command")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		bucket, key, err := getBucketAndKey(cmd)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket and key: ", err))
+			os.Exit(1)
+		}
+		log.Debug("S3 bucket/key: ", bucket, key)
+
+		token, err := owner.Token(bucket, key)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to get the owner token of the lock: ", err))
+			os.Exit(1)
+		}
+
+		cli := utils.GetS3Client()
+
+		renew := newLocker(cli).Renew
+		if shared {
+			renew = func(ctx context.Context, bucket, key, token string, ttl time.Duration) (t.LockInfo, error) {
+				return lock.RenewSharedLock(ctx, cli, bucket, key, token, ttl)
+			}
+		}
+		lockInfo, err := renew(context.Background(), bucket, key, token, ttl)
+		if err != nil {
+			if errors.Is(err, lock.ErrLockLost) {
+				cmd.PrintErrln(config.Red("❌ Lock is no longer held by this process: ", err))
+				os.Exit(1)
+			}
+			cmd.PrintErrln(config.Red("❌ Failed to renew lock: ", err))
+			os.Exit(1)
+		}
+
+		if lockInfo.ExpiresAt == "" {
+			cmd.Println(config.Green("Lock renewed successfully, the lock never expires."))
+			return
+		}
+		cmd.Println(config.Green("Lock renewed successfully, lease expires at ", lockInfo.ExpiresAt, "."))
+	},
+}
@@ -0,0 +1,205 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
+	"statectl/internal/utils/ci"
+	t "statectl/internal/utils/types"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// DefaultRunTTL is the lease of the lock held by `lock run` when no TTL is configured.
+// The lock is renewed while the command runs, so it only expires if statectl dies.
+const DefaultRunTTL = 10 * time.Minute
+
+var heartbeatInterval time.Duration
+
+func init() {
+	RunCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
+	RunCmd.Flags().StringVarP(&key, "key", "k", viper.GetString("LOCK_KEY_PATH"), "S3 key to store the lock file")
+	addNameFlag(RunCmd)
+	RunCmd.Flags().DurationVar(&ttl, "ttl", viper.GetDuration("LOCK_TTL"), "lease duration of the lock, renewed while the command runs (defaults to 10m)")
+	RunCmd.Flags().DurationVar(&heartbeatInterval, "heartbeat-interval", 0, "interval between two renewals of the lock (defaults to a third of the TTL)")
+	RunCmd.Flags().BoolVarP(&wait, "wait", "w", false, "wait for the lock to be released instead of failing when it is held")
+	RunCmd.Flags().DurationVar(&timeout, "timeout", viper.GetDuration("LOCK_WAIT_TIMEOUT"), "maximum time to wait for the lock with --wait (0 means wait forever)")
+	RunCmd.Flags().DurationVar(&pollInterval, "poll-interval", lock.DefaultPollInterval, "initial delay between two attempts with --wait, doubled on every attempt")
+	RunCmd.Flags().BoolVar(&queue, "queue", false, "wait in line: the waiters acquire the lock in the order they started to wait (implies --wait)")
+	ci.AddFlags(RunCmd.Flags(), &buildOverride)
+
+	// Everything after the command name belongs to the wrapped command
+	RunCmd.Flags().SetInterspersed(false)
+}
+
+var RunCmd = &cobra.Command{
+	Use:   "run [flags] -- command [args...]",
+	Short: "Run a command while holding the S3 lock",
+	Long: `Run a command while holding the lock on the S3 state file.
+This command acquires the lock, runs the given command, and always releases
+the lock when the command exits, even if it fails or is interrupted. Signals
+received by statectl are forwarded to the command, and the lease of the lock
+is renewed periodically while it runs. If the lock is lost while the command
+runs, the command is terminated.
+
+The exit code of statectl is the exit code of the command.
+
+Usage:
+  statectl lock run [--ttl duration] [--wait|--queue] -- command [args...]
+
+Example:
+  # Run dbt while holding the lock
+  statectl lock run -- dbt build --target prod
+
+  # Wait up to 30 minutes for the lock before running dbt
+  statectl lock run --wait --timeout 30m -- dbt build --target prod`,
+	Args: cobra.MinimumNArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			log.SetLevel(logrus.DebugLevel)
+		}
+		log.Debug("Running lock run command")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		bucket, key, err := getBucketAndKey(cmd)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket and key: ", err))
+			os.Exit(1)
+		}
+		log.Debug("S3 bucket/key: ", bucket, key)
+
+		if ttl <= 0 {
+			ttl = DefaultRunTTL
+		}
+		if heartbeatInterval <= 0 {
+			heartbeatInterval = ttl / 3
+		}
+
+		lockInfo := newLockInfo(ownerToken(bucket, key)).WithLease(time.Now(), ttl)
+
+		exitCode := runWithLock(cmd, utils.GetS3Client(), bucket, key, lockInfo, args)
+
+		webhook.WaitTimeout(lock.DefaultWebhookWait)
+		os.Exit(exitCode)
+	},
+}
+
+// runWithLock acquires the lock, runs the command while holding it, and releases
+// it when the command exits. It returns the exit code of statectl.
+func runWithLock(cmd *cobra.Command, cli t.S3Client, bucket, key string, lockInfo t.LockInfo, args []string) int {
+	locker := newLocker(cli)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	owned := true
+	lockInfo, err := acquireLock(ctx, cli, bucket, key, lockInfo)
+	if err != nil {
+		if !errors.Is(err, lock.ErrLockExists) {
+			exitOnAcquireError(cmd, err)
+		}
+		// The lock was acquired earlier by this pipeline, leave it to release it
+		cmd.Println(config.Yellow("Lock already acquired, it will not be released after the command."))
+		owned = false
+	} else {
+		saveOwnerToken(cmd, bucket, key, lockInfo)
+	}
+
+	// Forward signals to the command instead of exiting before the lock is released
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+	stop()
+	log.Debugf("Lock acquired, running %v", args)
+
+	child := exec.Command(args[0], args[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	sharesTerminal := configureChild(child)
+
+	if err := child.Start(); err != nil {
+		cmd.PrintErrln(config.Red("❌ Failed to start the command: ", err))
+		if owned {
+			releaseAfterRun(cmd, locker, bucket, key, lockInfo.OwnerToken)
+		}
+		return 1
+	}
+
+	hbCtx, cancelHeartbeat := context.WithCancel(context.Background())
+	lost := lock.Heartbeat(hbCtx, locker, bucket, key, lockInfo.OwnerToken, heartbeatInterval, ttl)
+
+	done := make(chan error, 1)
+	go func() { done <- child.Wait() }()
+
+	lockLost := false
+loop:
+	for {
+		select {
+		case sig := <-sigs:
+			if sharesTerminal && slices.Contains(terminalSignals, sig) {
+				log.Debugf("The command got signal %s from the terminal", sig)
+				continue
+			}
+			log.Debugf("Forwarding signal %s to the command", sig)
+			if err := child.Process.Signal(sig); err != nil {
+				log.Warnf("Failed to forward signal %s to the command: %v", sig, err)
+			}
+		case err, ok := <-lost:
+			lost = nil
+			if !ok {
+				continue
+			}
+			lockLost = true
+			cmd.PrintErrln(config.Red("❌ Lost the lock while the command was running, terminating it: ", err))
+			terminate(child.Process)
+		case <-done:
+			break loop
+		}
+	}
+	cancelHeartbeat()
+
+	exitCode := exitCodeOf(child.ProcessState)
+	log.Debugf("Command exited with code %d", exitCode)
+
+	if owned && !lockLost {
+		if !releaseAfterRun(cmd, locker, bucket, key, lockInfo.OwnerToken) && exitCode == 0 {
+			exitCode = 1
+		}
+	}
+	if lockLost && exitCode == 0 {
+		exitCode = 1
+	}
+	return exitCode
+}
+
+// releaseAfterRun releases the lock held for the command and reports whether it succeeded.
+func releaseAfterRun(cmd *cobra.Command, locker lock.Locker, bucket, key, token string) bool {
+	if err := locker.Release(context.Background(), bucket, key, token); err != nil {
+		cmd.PrintErrln(config.Red("❌ Failed to release lock: ", err))
+		return false
+	}
+	removeOwnerToken(bucket, key)
+	cmd.Println(config.Green("Lock released successfully."))
+	return true
+}
+
+// exitCodeOf returns the exit code of the command, following the shell convention
+// of 128 + signal number for commands killed by a signal.
+func exitCodeOf(state *os.ProcessState) int {
+	if code := state.ExitCode(); code >= 0 {
+		return code
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return 1
+}
@@ -0,0 +1,112 @@
+//go:build linux
+
+package lock
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"statectl/test"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/spf13/cobra"
+)
+
+// openPTY opens a pseudo-terminal, and returns its master and slave ends.
+func openPTY(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal available: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		t.Fatalf("error reading the number of the pseudo-terminal: %v", errno)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Fatalf("error unlocking the pseudo-terminal: %v", errno)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal available: %v", err)
+	}
+	t.Cleanup(func() { slave.Close() })
+	return master, slave
+}
+
+func TestRunWithLockInTerminal(t *testing.T) {
+	// Set up
+	master, slave := openPTY(t)
+
+	// Run the helper below in a session of its own, with the pseudo-terminal as
+	// its controlling terminal, like statectl started from an interactive shell
+	helper := exec.Command(os.Args[0], "-test.run=^TestRunWithLockInTerminalHelper$")
+	helper.Env = append(os.Environ(), "STATECTL_TERMINAL_HELPER=1")
+	helper.Stdin, helper.Stdout, helper.Stderr = slave, slave, slave
+	helper.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := helper.Start(); err != nil {
+		t.Fatalf("error starting the helper: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- helper.Wait() }()
+
+	output := make(chan string, 1)
+	go func() {
+		var out strings.Builder
+		buf := make([]byte, 1024)
+		for !strings.Contains(out.String(), "got hello") {
+			n, err := master.Read(buf)
+			if err != nil {
+				break
+			}
+			out.Write(buf[:n])
+		}
+		output <- out.String()
+	}()
+
+	// Call the function under test
+	if _, err := master.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("error writing to the terminal: %v", err)
+	}
+
+	// Assertions
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the command to read from the terminal and exit, got: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		_ = helper.Process.Kill()
+		t.Fatalf("expected the command to read from the terminal, it hung")
+	}
+	select {
+	case out := <-output:
+		if !strings.Contains(out, "got hello") {
+			t.Errorf("expected the command to read the terminal, got %q", out)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the output of the command on the terminal")
+	}
+}
+
+// TestRunWithLockInTerminalHelper runs a command that reads the terminal with
+// `lock run`, when run by TestRunWithLockInTerminal.
+func TestRunWithLockInTerminalHelper(t *testing.T) {
+	if os.Getenv("STATECTL_TERMINAL_HELPER") != "1" {
+		t.Skip("only run by TestRunWithLockInTerminal")
+	}
+	setUpRun(t)
+	lockInfo, _ := test.CreateLockInfo("run")
+
+	exitCode := runWithLock(&cobra.Command{}, test.NewFakeS3Client(), "test-bucket", "test-key", lockInfo, []string{"sh", "-c", `read line && echo "got $line"`})
+	if exitCode != 0 {
+		t.Fatalf("expected the command to succeed, got exit code %d", exitCode)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package lock
+
+import (
+	"os"
+	"os/exec"
+)
+
+// forwardedSignals are the signals passed on to the command run by `lock run`.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// terminalSignals are sent by the terminal to every process of its foreground group.
+var terminalSignals = []os.Signal{}
+
+// configureChild keeps the default process attributes, there are no process groups to set up.
+func configureChild(child *exec.Cmd) bool {
+	return false
+}
+
+// terminate stops the command, signals other than kill are not supported.
+func terminate(process *os.Process) {
+	_ = process.Kill()
+}
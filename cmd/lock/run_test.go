@@ -0,0 +1,110 @@
+//go:build unix
+
+package lock
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"statectl/internal/aws/lock"
+	"statectl/test"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestExitCodeOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{name: "success", script: "exit 0", want: 0},
+		{name: "failure", script: "exit 3", want: 3},
+		{name: "killed by SIGTERM", script: "kill -TERM $$", want: 128 + int(syscall.SIGTERM)},
+		{name: "killed by SIGKILL", script: "kill -KILL $$", want: 128 + int(syscall.SIGKILL)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Set up
+			child := exec.Command("sh", "-c", tt.script)
+			_ = child.Run()
+
+			// Call the function under test
+			got := exitCodeOf(child.ProcessState)
+
+			// Assertions
+			if got != tt.want {
+				t.Errorf("expected exit code %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+// setUpRun sets the flags of `lock run` for a test, and keeps the owner tokens
+// in a temporary directory.
+func setUpRun(t *testing.T) {
+	t.Helper()
+	viper.Set("LOCK_TOKEN_DIR", t.TempDir())
+	ttl, heartbeatInterval = time.Minute, time.Minute
+	t.Cleanup(func() {
+		viper.Reset()
+		ttl, heartbeatInterval = 0, 0
+	})
+}
+
+func TestRunWithLockReleasesOnFailure(t *testing.T) {
+	// Set up
+	setUpRun(t)
+	fakeS3 := test.NewFakeS3Client()
+	lockInfo, _ := test.CreateLockInfo("run")
+
+	// Call the function under test
+	exitCode := runWithLock(&cobra.Command{}, fakeS3, "test-bucket", "test-key", lockInfo, []string{"sh", "-c", "exit 5"})
+
+	// Assertions
+	if exitCode != 5 {
+		t.Errorf("expected the exit code of the command, got %d", exitCode)
+	}
+	exist, _, err := lock.CheckStateLock(context.Background(), fakeS3, "test-bucket", "test-key", true)
+	if err != nil || exist {
+		t.Errorf("expected the lock to be released after the command failed, got %v (%v)", exist, err)
+	}
+}
+
+func TestRunWithLockForwardsSignals(t *testing.T) {
+	// Set up
+	setUpRun(t)
+	fakeS3 := test.NewFakeS3Client()
+	lockInfo, _ := test.CreateLockInfo("run")
+	started := filepath.Join(t.TempDir(), "started")
+
+	go func() {
+		// Signal statectl once the command is ready to trap the signal
+		for {
+			if _, err := os.Stat(started); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	}()
+
+	// Call the function under test
+	script := `trap 'exit 7' TERM; touch "$1"; while :; do sleep 0.01; done`
+	exitCode := runWithLock(&cobra.Command{}, fakeS3, "test-bucket", "test-key", lockInfo, []string{"sh", "-c", script, "sh", started})
+
+	// Assertions
+	if exitCode != 7 {
+		t.Errorf("expected the command to exit from the forwarded signal, got exit code %d", exitCode)
+	}
+	exist, _, err := lock.CheckStateLock(context.Background(), fakeS3, "test-bucket", "test-key", true)
+	if err != nil || exist {
+		t.Errorf("expected the lock to be released after the signal, got %v (%v)", exist, err)
+	}
+}
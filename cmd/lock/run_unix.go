@@ -0,0 +1,34 @@
+//go:build unix
+
+package lock
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// forwardedSignals are the signals passed on to the command run by `lock run`.
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// terminalSignals are sent by the terminal to every process of its foreground group.
+var terminalSignals = []os.Signal{syscall.SIGINT, syscall.SIGQUIT}
+
+// configureChild starts the command in its own process group, so a Ctrl-C in the
+// terminal reaches it only once, through statectl. When stdin is a terminal, the
+// command stays in the group of statectl instead: in a group of its own, it
+// would be in the background and stopped as soon as it reads from the terminal.
+// It reports whether the command shares the terminal, and gets the terminal
+// signals without statectl.
+func configureChild(child *exec.Cmd) bool {
+	if stdin, ok := child.Stdin.(*os.File); ok && isTerminal(stdin) {
+		return true
+	}
+	child.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return false
+}
+
+// terminate asks the command to stop.
+func terminate(process *os.Process) {
+	_ = process.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,243 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
+	"statectl/internal/utils/owner"
+	t "statectl/internal/utils/types"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Exit codes of the status command, so scripts can tell who holds the lock
+const (
+	ExitUnlocked      = 0
+	ExitLockedByMe    = 2
+	ExitLockedByOther = 3
+	ExitLockedShared  = 4
+)
+
+const (
+	statusUnlocked      = "unlocked"
+	statusLockedByMe    = "locked_by_me"
+	statusLockedByOther = "locked_by_other"
+	statusLockedShared  = "locked_shared"
+)
+
+var output string
+
+func init() {
+	StatusCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket to store the lock file")
+	StatusCmd.Flags().StringVarP(&key, "key", "k", viper.GetString("LOCK_KEY_PATH"), "S3 key to store the lock file")
+	addNameFlag(StatusCmd)
+	StatusCmd.Flags().StringVarP(&output, "output", "o", "text", "output format, one of: text, json")
+}
+
+type lockStatus struct {
+	Status    string       `json:"status"`
+	Bucket    string       `json:"bucket"`
+	Key       string       `json:"key"`
+	Age       string       `json:"age,omitempty"`
+	Remaining string       `json:"remaining,omitempty"`
+	Expired   bool         `json:"expired"`
+	Lock      *t.LockInfo  `json:"lock,omitempty"`
+	Readers   []t.LockInfo `json:"readers,omitempty"`
+}
+
+var StatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the current holder of the S3 lock",
+	Long: `Show the current holder of the lock on the S3 state file.
+This command reads the lock file from the S3 bucket and prints the lock ID,
+signer, timestamp, age, comments and remaining lease of the current holder.
+
+The exit code tells who holds the lock:
+  0  the state is not locked
+  1  the status could not be checked
+  2  the lock is held by this process
+  3  the lock is held by someone else
+  4  the lock is only held in shared mode by readers
+
+Usage:
+  statectl lock status [--name name] [--output text|json]
+
+Example:
+  # Show who currently holds the lock
+  statectl lock status
+
+  # Print the lock holder as JSON for scripts
+  statectl lock status --output json`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			log.SetLevel(logrus.DebugLevel)
+		}
+		log.Debug("Running lock status command")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if output != "text" && output != "json" {
+			cmd.PrintErrln(config.Red("❌ Invalid output format: ", output, ", must be one of: text, json"))
+			os.Exit(1)
+		}
+
+		bucket, key, err := getBucketAndKey(cmd)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket and key: ", err))
+			os.Exit(1)
+		}
+		log.Debug("S3 bucket/key: ", bucket, key)
+
+		status, exitCode, err := checkStatus(context.Background(), utils.GetS3Client(), bucket, key)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ ", err))
+			os.Exit(1)
+		}
+
+		if output == "json" {
+			if err := printStatusJSON(cmd, status); err != nil {
+				cmd.PrintErrln(config.Red("❌ Failed to encode lock status: ", err))
+				os.Exit(1)
+			}
+		} else {
+			printStatus(cmd, status)
+		}
+
+		os.Exit(exitCode)
+	},
+}
+
+// checkStatus reads the lock at bucket/key, and the shared locks of the S3 lock,
+// and returns the status of the lock with the exit code that tells it.
+func checkStatus(ctx context.Context, cli t.S3Client, bucket, key string) (lockStatus, int, error) {
+	exist, lockInfo, err := newLocker(cli).Status(ctx, bucket, key)
+	if err != nil {
+		return lockStatus{}, 1, fmt.Errorf("failed to check lock status: %w", err)
+	}
+
+	status := lockStatus{Status: statusUnlocked, Bucket: bucket, Key: key}
+	exitCode := ExitUnlocked
+	if exist {
+		now := time.Now()
+		status.Status, exitCode = statusLockedByOther, ExitLockedByOther
+		if token, err := owner.Token(bucket, key); err == nil && token == lockInfo.OwnerToken {
+			status.Status, exitCode = statusLockedByMe, ExitLockedByMe
+		}
+		// The owner token would let anyone who can read the status act as the holder
+		holder := lockInfo
+		holder.OwnerToken = ""
+		status.Lock = &holder
+		if age, ok := lockInfo.Age(now); ok {
+			status.Age = age.Round(time.Second).String()
+		}
+		if remaining, ok := lockInfo.Remaining(now); ok {
+			status.Remaining = remaining.Round(time.Second).String()
+		}
+		status.Expired = lockInfo.Expired(now)
+	}
+
+	// Only the S3 lock can be held in shared mode
+	if backendConfig().Name() == lock.BackendS3 {
+		readers, err := lock.ListSharedLocks(ctx, cli, bucket, key)
+		if err != nil {
+			return lockStatus{}, 1, fmt.Errorf("failed to check the shared locks: %w", err)
+		}
+		for _, reader := range readers {
+			if !reader.Expired(time.Now()) {
+				status.Readers = append(status.Readers, reader)
+			}
+		}
+	}
+	if !exist && len(status.Readers) > 0 {
+		status.Status, exitCode = statusLockedShared, ExitLockedShared
+	}
+	return status, exitCode, nil
+}
+
+// printStatusJSON prints the status of the lock as JSON on the standard output, for scripts.
+func printStatusJSON(cmd *cobra.Command, status lockStatus) error {
+	raw, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), string(raw))
+	return nil
+}
+
+func printStatus(cmd *cobra.Command, status lockStatus) {
+	defer printReaders(cmd, status.Readers)
+
+	switch status.Status {
+	case statusUnlocked:
+		cmd.Println(config.Green("The state is not locked."))
+		return
+	case statusLockedShared:
+		cmd.Println(config.Yellow("The state is locked in shared mode by readers."))
+		return
+	case statusLockedByMe:
+		cmd.Println(config.Green("The state is locked by this process."))
+	default:
+		cmd.Println(config.Yellow("The state is locked by someone else."))
+	}
+
+	lockInfo := status.Lock
+	cmd.Printf("  Lock ID:    %s\n", lockInfo.LockID)
+	cmd.Printf("  Signer:     %s\n", lockInfo.Signer)
+	cmd.Printf("  Timestamp:  %s\n", lockInfo.TimeStamp)
+	if lockInfo.FencingToken > 0 {
+		cmd.Printf("  Fencing:    %d\n", lockInfo.FencingToken)
+	}
+	if status.Age != "" {
+		cmd.Printf("  Age:        %s\n", status.Age)
+	}
+
+	switch {
+	case lockInfo.ExpiresAt == "":
+		cmd.Printf("  Expires:    never\n")
+	case status.Expired:
+		cmd.Printf("  Expires:    %s %s\n", lockInfo.ExpiresAt, config.Red("(expired, can be taken over)"))
+	default:
+		cmd.Printf("  Expires:    %s (%s remaining)\n", lockInfo.ExpiresAt, status.Remaining)
+	}
+
+	if build := lockInfo.Build; build != nil {
+		cmd.Printf("  CI:         %s (branch: %s, actor: %s)\n", build.Provider, build.Branch, build.Actor)
+		if build.JobURL != "" {
+			cmd.Printf("  Job:        %s\n", build.JobURL)
+		}
+	}
+
+	if lockInfo.Heartbeat != "" {
+		cmd.Printf("  Heartbeat:  %s\n", lockInfo.Heartbeat)
+	}
+
+	if lockInfo.PreviousOwner != nil {
+		cmd.Printf("  Takeover:   %s from %s (signer: %s)\n", lockInfo.Takeover, lockInfo.PreviousOwner.LockID, lockInfo.PreviousOwner.Signer)
+	}
+	if lockInfo.Reason != "" {
+		cmd.Printf("  Reason:     %s\n", lockInfo.Reason)
+	}
+
+	cmd.Println("  Comments:")
+	cmd.Printf("    Commit:   %s\n", lockInfo.Comments.Commit)
+	cmd.Printf("    Trigger:  %s\n", lockInfo.Comments.Trigger)
+	if lockInfo.Comments.Extra != "" {
+		cmd.Printf("    Extra:    %s\n", lockInfo.Comments.Extra)
+	}
+}
+
+func printReaders(cmd *cobra.Command, readers []t.LockInfo) {
+	if len(readers) == 0 {
+		return
+	}
+	cmd.Printf("  Readers:    %d\n", len(readers))
+	for _, reader := range readers {
+		cmd.Printf("    %s (signer: %s, since %s)\n", reader.LockID, reader.Signer, reader.TimeStamp)
+	}
+}
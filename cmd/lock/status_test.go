@@ -0,0 +1,138 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"statectl/internal/aws/lock"
+	"statectl/internal/utils/owner"
+	"statectl/test"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestCheckStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		lock       func(ctx context.Context, fakeS3 *test.FakeS3Client) error
+		wantStatus string
+		wantExit   int
+	}{
+		{
+			name:       "unlocked",
+			lock:       func(ctx context.Context, fakeS3 *test.FakeS3Client) error { return nil },
+			wantStatus: statusUnlocked,
+			wantExit:   ExitUnlocked,
+		},
+		{
+			name: "locked by me",
+			lock: func(ctx context.Context, fakeS3 *test.FakeS3Client) error {
+				lockInfo, _ := test.CreateLockInfo("mine")
+				if _, err := owner.Save("test-bucket", "test-key", lockInfo.OwnerToken, 1); err != nil {
+					return err
+				}
+				_, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo)
+				return err
+			},
+			wantStatus: statusLockedByMe,
+			wantExit:   ExitLockedByMe,
+		},
+		{
+			name: "locked by other",
+			lock: func(ctx context.Context, fakeS3 *test.FakeS3Client) error {
+				if _, err := owner.Save("test-bucket", "test-key", "mine-token", 1); err != nil {
+					return err
+				}
+				lockInfo, _ := test.CreateLockInfo("other")
+				_, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo)
+				return err
+			},
+			wantStatus: statusLockedByOther,
+			wantExit:   ExitLockedByOther,
+		},
+		{
+			name: "locked shared",
+			lock: func(ctx context.Context, fakeS3 *test.FakeS3Client) error {
+				lockInfo, _ := test.CreateLockInfo("reader")
+				_, err := lock.AcquireSharedLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo)
+				return err
+			},
+			wantStatus: statusLockedShared,
+			wantExit:   ExitLockedShared,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Set up
+			viper.Set("LOCK_TOKEN_DIR", t.TempDir())
+			defer viper.Reset()
+
+			ctx := context.Background()
+			fakeS3 := test.NewFakeS3Client()
+			if err := tt.lock(ctx, fakeS3); err != nil {
+				t.Fatalf("error locking the state: %v", err)
+			}
+
+			// Call the function under test
+			status, exitCode, err := checkStatus(ctx, fakeS3, "test-bucket", "test-key")
+
+			// Assertions
+			if err != nil {
+				t.Fatalf("error checking the lock status: %v", err)
+			}
+			if status.Status != tt.wantStatus || exitCode != tt.wantExit {
+				t.Errorf("expected %s with exit code %d, got %s with exit code %d", tt.wantStatus, tt.wantExit, status.Status, exitCode)
+			}
+		})
+	}
+}
+
+func TestPrintStatusJSON(t *testing.T) {
+	// Set up
+	viper.Set("LOCK_TOKEN_DIR", t.TempDir())
+	defer viper.Reset()
+
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	lockInfo, _ := test.CreateLockInfo("other")
+	if _, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring the lock: %v", err)
+	}
+	status, _, err := checkStatus(ctx, fakeS3, "test-bucket", "test-key")
+	if err != nil {
+		t.Fatalf("error checking the lock status: %v", err)
+	}
+
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+
+	// Call the function under test
+	if err := printStatusJSON(cmd, status); err != nil {
+		t.Fatalf("error printing the lock status: %v", err)
+	}
+
+	// Assertions
+	var printed struct {
+		Status  string         `json:"status"`
+		Bucket  string         `json:"bucket"`
+		Key     string         `json:"key"`
+		Expired bool           `json:"expired"`
+		Lock    map[string]any `json:"lock"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &printed); err != nil {
+		t.Fatalf("expected the status as JSON, got %s (%v)", out.String(), err)
+	}
+	if printed.Status != statusLockedByOther || printed.Bucket != "test-bucket" || printed.Key != "test-key" {
+		t.Errorf("unexpected status %+v", printed)
+	}
+	if printed.Lock["lock_id"] != "other" || printed.Lock["fencing_token"] != float64(1) {
+		t.Errorf("expected the holder of the lock, got %v", printed.Lock)
+	}
+	if _, ok := printed.Lock["owner_token"]; ok || bytes.Contains(out.Bytes(), []byte(lockInfo.OwnerToken)) {
+		t.Errorf("expected the owner token not to be printed, got %s", out.String())
+	}
+}
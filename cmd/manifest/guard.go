@@ -0,0 +1,153 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"statectl/internal/aws/lock"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
+	"statectl/internal/utils/ci"
+	"statectl/internal/utils/owner"
+	t "statectl/internal/utils/types"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// DefaultAutoLockTTL is the lease of the lock acquired with --auto-lock when
+// LOCK_TTL is not set. The lease is renewed while the manifest is pushed.
+const DefaultAutoLockTTL = 10 * time.Minute
+
+// guardPush makes sure the state lock is held before the manifest is pushed.
+// With --auto-lock, the lock is acquired for the push unless this workspace
+// already holds it, and the returned function releases it. With --require-lock,
+// the push fails unless this workspace holds the lock. It returns the fencing
+// token to push with, zero if the push is not fenced.
+func guardPush(ctx context.Context, cmd *cobra.Command, cli t.S3Client, bucket string) (int64, func(), error) {
+	release := func() {}
+
+	if !autoLock && !requireLock {
+		token, err := pushFencingToken(bucket)
+		if err != nil {
+			return 0, release, fmt.Errorf("failed to get the fencing token of the lock: %w", err)
+		}
+		return token, release, nil
+	}
+
+	if lockKey == "" {
+		return 0, release, errors.New("no lock key set, use --lock-key or LOCK_KEY_PATH, or disable the lock check with --require-lock=false")
+	}
+
+	locker, err := newLocker(cli)
+	if err != nil {
+		return 0, release, err
+	}
+
+	held, err := heldLock(ctx, locker, bucket)
+	if err == nil {
+		log.Debugf("Pushing under the lock held by this workspace (fencing token %d)", held.FencingToken)
+		return fencingTokenOf(held), release, nil
+	}
+	if !autoLock {
+		return 0, release, fmt.Errorf("the state lock is not held by this workspace, acquire it with 'statectl lock acquire' or push with --auto-lock: %w", err)
+	}
+
+	return acquireForPush(ctx, cmd, cli, locker, bucket)
+}
+
+// newLocker returns the locker of the configured lock backend. The DynamoDB
+// client is only built for its backend.
+func newLocker(cli t.S3Client) (lock.Locker, error) {
+	c := lock.ConfiguredBackend()
+	var dynamoDBClient t.DynamoDBClient
+	if c.Name() == lock.BackendDynamoDB {
+		dynamoDBClient = utils.GetDynamoDBClient(c.DynamoDBEndpoint)
+	}
+	return lock.NewLocker(c, cli, dynamoDBClient)
+}
+
+// heldLock returns the state lock if this workspace holds it.
+func heldLock(ctx context.Context, locker lock.Locker, bucket string) (t.LockInfo, error) {
+	token, err := owner.Token(bucket, lockKey)
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+	return lock.VerifyLock(ctx, locker, bucket, lockKey, token)
+}
+
+// acquireForPush acquires the state lock for the duration of the push, renewing
+// its lease until the returned function releases it.
+func acquireForPush(ctx context.Context, cmd *cobra.Command, cli t.S3Client, locker lock.Locker, bucket string) (int64, func(), error) {
+	ttl := viper.GetDuration("LOCK_TTL")
+	if ttl <= 0 {
+		ttl = DefaultAutoLockTTL
+	}
+
+	// Keep the lock events of the push in the audit trail and notify them, as the lock commands do
+	if eventLog, ok := lock.ConfiguredBackend().EventLog(cli, viper.GetString("LOCK_EVENTS_PREFIX")); ok {
+		lock.AddRecorder(eventLog)
+	}
+	webhook, err := lock.ConfiguredWebhook()
+	if err != nil {
+		return 0, func() {}, fmt.Errorf("invalid lock webhook: %w", err)
+	}
+	if webhook != nil {
+		lock.AddRecorder(webhook)
+	}
+
+	lockInfo := lock.NewLockInfo(owner.NewToken(), ci.Resolve(buildOverride)).WithLease(time.Now(), ttl)
+	acquired, err := locker.Acquire(ctx, bucket, lockKey, lockInfo)
+	if err != nil {
+		if errors.Is(err, lock.ErrLockHeld) {
+			return 0, func() {}, fmt.Errorf("the state lock is held by someone else, run 'statectl lock status' to see who: %w", err)
+		}
+		return 0, func() {}, fmt.Errorf("failed to acquire the state lock for the push: %w", err)
+	}
+	cmd.Println(config.Green("Lock acquired for the push."))
+
+	hbCtx, stop := context.WithCancel(ctx)
+	go func() {
+		if err := <-lock.Heartbeat(hbCtx, locker, bucket, lockKey, acquired.OwnerToken, ttl/3, ttl); err != nil {
+			log.Warnf("Lost the state lock during the push: %v", err)
+		}
+	}()
+
+	release := func() {
+		stop()
+		defer webhook.WaitTimeout(lock.DefaultWebhookWait)
+		if err := locker.Release(ctx, bucket, lockKey, acquired.OwnerToken); err != nil {
+			cmd.PrintErrln(config.Yellow("WARNING: failed to release the lock acquired for the push: ", err))
+			return
+		}
+		cmd.Println(config.Green("Lock released after the push."))
+	}
+	return fencingTokenOf(acquired), release, nil
+}
+
+// fencingTokenOf returns the fencing token of the lock, unless one was given with --fencing-token.
+func fencingTokenOf(lockInfo t.LockInfo) int64 {
+	if fencingToken > 0 {
+		return fencingToken
+	}
+	return lockInfo.FencingToken
+}
+
+// pushFencingToken returns the fencing token to push with, zero if the lock was
+// not acquired in this workspace.
+func pushFencingToken(bucket string) (int64, error) {
+	if fencingToken > 0 {
+		return fencingToken, nil
+	}
+	if lockKey == "" {
+		return 0, nil
+	}
+
+	token, err := owner.FencingToken(bucket, lockKey)
+	if errors.Is(err, owner.ErrNoToken) {
+		log.Debug("No fencing token found, pushing without fencing")
+		return 0, nil
+	}
+	return token, err
+}
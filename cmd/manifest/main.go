@@ -11,6 +11,7 @@ func init() {
 	ManifestCmd.AddCommand(PushCmd)
 	ManifestCmd.AddCommand(PullCmd)
 	ManifestCmd.AddCommand(ListCmd)
+	ManifestCmd.AddCommand(RollbackCmd)
 }
 
 var log = logging.GetLogger()
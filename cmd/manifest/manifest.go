@@ -2,11 +2,17 @@ package manifest
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 
 	"os"
+	"statectl/internal/aws/lock"
 	"statectl/internal/aws/manifest"
 	"statectl/internal/aws/utils"
 	"statectl/internal/config"
+	"statectl/internal/utils/ci"
+	t "statectl/internal/utils/types"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -19,6 +25,18 @@ var (
 	statePath    string
 	localPath    string
 	singleStore  bool
+	lockKey      string
+	fencingToken int64
+	requireLock  bool
+	autoLock     bool
+	concurrency  int
+	// pullVersion, pullCommit and pullState select the earlier push to pull
+	pullVersion string
+	pullCommit  string
+	pullState   string
+	// buildOverride holds the build info given with flags, which takes
+	// precedence over the CI provider's variables
+	buildOverride t.BuildInfo
 )
 
 func init() {
@@ -26,15 +44,35 @@ func init() {
 	PushCmd.Flags().StringVarP(&manifestPath, "manifest", "m", viper.GetString("MANIFEST_KEY_PATH"), "S3 key point to the bucket key that stores store the manifest")
 	PushCmd.Flags().StringVarP(&statePath, "state", "s", "state.json", "Local path to store the state file which is for tracking the manifest")
 	PushCmd.PersistentFlags().BoolVar(&singleStore, "disable-full-tree", false, "push from the root directory. e.g. manifestPath=artifacts/manifest.json, then push entire artifacts folder")
+	PushCmd.Flags().StringVar(&lockKey, "lock-key", viper.GetString("LOCK_KEY_PATH"), "S3 key of the lock the manifest is pushed under")
+	PushCmd.Flags().Int64Var(&fencingToken, "fencing-token", 0, "fencing token of the lock (defaults to the token saved by 'lock acquire')")
+	PushCmd.Flags().BoolVar(&requireLock, "require-lock", viper.GetBool("MANIFEST_REQUIRE_LOCK"), "refuse to push unless this workspace holds the state lock")
+	PushCmd.Flags().BoolVar(&autoLock, "auto-lock", false, "acquire the state lock for the push and release it afterwards")
+	PushCmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency(), "number of files transferred at once")
+	ci.AddFlags(PushCmd.Flags(), &buildOverride)
 
 	PullCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket point to the bucket name that stores the manifest")
 	PullCmd.Flags().StringVarP(&manifestPath, "manifest", "m", viper.GetString("MANIFEST_KEY_PATH"), "S3 key point to the bucket key that stores store the manifest")
 	PullCmd.Flags().StringVarP(&localPath, "local-path", "l", "", "Local path to store the manifest")
+	PullCmd.Flags().StringVar(&pullVersion, "version", "", "pull the push with this S3 version ID of the manifest, or this snapshot ID")
+	PullCmd.Flags().StringVar(&pullCommit, "commit", "", "pull the latest push of this commit SHA (or a prefix of it)")
+	PullCmd.Flags().StringVar(&pullState, "state", "", "pull the push recorded in this state file")
+	PullCmd.MarkFlagsMutuallyExclusive("version", "commit", "state")
+	PullCmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency(), "number of files transferred at once")
 
 	ListCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket point to the bucket name that stores the manifest")
 	ListCmd.Flags().StringVarP(&manifestPath, "manifest", "m", viper.GetString("MANIFEST_KEY_PATH"), "S3 key point to the bucket key that stores store the manifest")
 }
 
+// defaultConcurrency returns the MANIFEST_CONCURRENCY setting, or
+// manifest.DefaultConcurrency if it is not set.
+func defaultConcurrency() int {
+	if n := viper.GetInt("MANIFEST_CONCURRENCY"); n > 0 {
+		return n
+	}
+	return manifest.DefaultConcurrency
+}
+
 var PushCmd = &cobra.Command{
 	Use:   "push",
 	Short: "Push a manifest to the S3 bucket",
@@ -43,6 +81,34 @@ This command creates a new manifest file in the specified S3 bucket, which
 tracks the state of the database schema. This manifest file is used to
 coordinate safe access to the state file among multiple developers or
 automation tools.
+
+Every push uploads the files to a new snapshot under <root>.snapshots/, keyed
+by the time of the push and the commit SHA, and never overwrites the files of
+an earlier push. Once every file is uploaded, the <root>.current pointer is
+moved to the new snapshot, so readers never see a partially pushed tree.
+
+The state file records the commit, branch, pipeline ID, job URL and actor of
+the build, read from the variables of the CI provider or given with flags.
+
+The push is refused unless this workspace holds the state lock at the key
+from LOCK_KEY_PATH (or --lock-key). Set MANIFEST_REQUIRE_LOCK=false or pass
+--require-lock=false to push without the lock. With --auto-lock, the lock is
+acquired for the push and released afterwards, and the push fails if the lock
+is held by someone else.
+
+The push carries the fencing token of the lock. A push with a token older than
+the last committed one is refused, so a pipeline that lost its lock cannot
+overwrite newer state.
+
+Usage:
+  statectl manifest push [--auto-lock] [--require-lock=false]
+
+Example:
+  # Push the manifest under the lock acquired with 'lock acquire'
+  statectl manifest push
+
+  # Acquire the lock for the push only
+  statectl manifest push --auto-lock
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
@@ -64,24 +130,68 @@ automation tools.
 		}
 		log.Debug("S3 bucket/key: ", bucket, manifestPath)
 
-		log.Debugf("storing single file: %t\n", singleStore)
-		if err := manifest.UploadManifest(context.Background(), cli, bucket, manifestPath, singleStore); err != nil {
-			cmd.PrintErrln(config.Red("❌ Failed to upload the manifest to S3 bucket: ", err))
+		token, release, err := guardPush(context.Background(), cmd, cli, bucket)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Refusing to push the manifest: ", err))
 			os.Exit(1)
 		}
 
-		if statePath := cmd.Flag("state").Value.String(); statePath != "" {
-			log.Debugf("S3 bucket/key: %s/%s. Local evidence path: %s\n", bucket, manifestPath, statePath)
-			if err := manifest.CreateStateJSON(context.Background(), cli, bucket, manifestPath, statePath); err != nil {
-				cmd.PrintErrln(config.Red("❌ Failed to create the state json file: ", err))
-				os.Exit(1)
-			}
+		err = pushManifest(cmd, cli, bucket, manifestPath, token)
+		release()
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ ", err))
+			os.Exit(1)
 		}
 
 		cmd.Println(config.Green("manifest has been successfully uploaded"))
 	},
 }
 
+// commitFencingToken records the fencing token the manifest is about to be
+// changed with in the fence of the lock backend, unless it is zero, and fails if
+// the lock was acquired or committed with a newer token.
+func commitFencingToken(cli t.S3Client, bucket string, token int64) error {
+	if token <= 0 {
+		return nil
+	}
+	locker, err := newLocker(cli)
+	if err != nil {
+		return err
+	}
+	if err := locker.CommitFencingToken(context.Background(), bucket, lockKey, token); err != nil {
+		if errors.Is(err, lock.ErrStaleFencingToken) {
+			return fmt.Errorf("refusing to change the manifest, the lock was acquired by a newer holder: %w", err)
+		}
+		return fmt.Errorf("failed to commit the fencing token: %w", err)
+	}
+	return nil
+}
+
+// pushManifest uploads the manifest with the fencing token and writes the state file.
+func pushManifest(cmd *cobra.Command, cli t.S3Client, bucket, manifestPath string, token int64) error {
+	if err := commitFencingToken(cli, bucket, token); err != nil {
+		return err
+	}
+
+	build := ci.Resolve(buildOverride)
+
+	log.Debugf("storing single file: %t\n", singleStore)
+	snapshot, summary, err := manifest.UploadManifest(context.Background(), cli, bucket, manifestPath, singleStore, token, build, concurrency)
+	if err != nil {
+		return fmt.Errorf("failed to upload the manifest to S3 bucket: %w", err)
+	}
+	cmd.Printf("Pushed %d files to the snapshot %s: %d uploaded, %d skipped (unchanged), %d deleted\n",
+		snapshot.Files, snapshot.ID, summary.Transferred, summary.Skipped, summary.Deleted)
+
+	if statePath := cmd.Flag("state").Value.String(); statePath != "" {
+		log.Debugf("S3 bucket/key: %s/%s. Local evidence path: %s\n", bucket, manifestPath, statePath)
+		if err := manifest.CreateStateJSON(context.Background(), cli, bucket, manifestPath, statePath, build, snapshot); err != nil {
+			return fmt.Errorf("failed to create the state json file: %w", err)
+		}
+	}
+	return nil
+}
+
 var PullCmd = &cobra.Command{
 	Use:   "pull",
 	Short: "Pull the local state file with the S3 bucket",
@@ -90,6 +200,29 @@ This command updates the state file in the specified S3 bucket with the
 contents of the local state file. This command should be used after acquiring
 a lock on the S3 bucket to ensure that the state file is not modified by
 another user or process.
+
+The files are read from the snapshot the <root>.current pointer of the
+manifest points to, or from the manifest key itself if it was pushed before
+snapshots were introduced.
+
+A pull never deletes local files. The files under the manifest root that are
+not in the pulled snapshot may be local work that was not pushed yet, so they
+are kept and counted as stale in the summary.
+
+With --version, --commit or --state, the files are read from the snapshot of
+an earlier push instead, e.g. to compare with the state of an older deploy.
+--version takes a snapshot ID or the S3 version ID recorded in the state file;
+the version ID of a manifest pushed before snapshots restores that one object.
+
+Usage:
+  statectl manifest pull [--version <id> | --commit <sha> | --state <path>]
+
+Example:
+  # Pull the manifest of the deploy recorded in an older state file
+  statectl manifest pull --state prod/state.json -l prod-state
+
+  # Pull the latest push of a commit
+  statectl manifest pull --commit 1a2b3c4d -l prod-state
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
@@ -100,6 +233,12 @@ another user or process.
 	Run: func(cmd *cobra.Command, args []string) {
 		cli := utils.GetS3Client()
 
+		rev, historical, err := pullRevision(cmd)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to read the state file: ", err))
+			os.Exit(1)
+		}
+
 		bucket, key, err := utils.GetS3BucketAndManifest(cmd)
 
 		if err != nil {
@@ -108,15 +247,64 @@ another user or process.
 		}
 		log.Debug("S3 bucket/key: ", bucket, key)
 
-		if err := manifest.DownloadManifest(context.Background(), cli, bucket, key, localPath); err != nil {
+		var snapshot t.Snapshot
+		var summary manifest.TransferSummary
+		if historical {
+			snapshot, summary, err = manifest.DownloadRevision(context.Background(), cli, bucket, key, rev, localPath, concurrency)
+			if err == nil && snapshot.ID == "" {
+				cmd.Printf("Pulled the %s of %s\n", rev, key)
+			}
+		} else {
+			snapshot, summary, err = manifest.DownloadManifest(context.Background(), cli, bucket, key, localPath, concurrency)
+		}
+		if err != nil {
 			cmd.PrintErrln(config.Red("❌ Failed to download the manifest from S3 bucket: ", err))
 			os.Exit(1)
 		}
+		if snapshot.ID != "" {
+			cmd.Printf("Pulled the snapshot %s of commit %s\n", snapshot.ID, snapshot.CommitSHA)
+		}
+		cmd.Printf("%d downloaded, %d skipped (unchanged), %d stale (not in the manifest, kept locally)\n",
+			summary.Transferred, summary.Skipped, summary.Stale)
 
 		cmd.Println(config.Green("manifest has been successfully downloaded"))
 	},
 }
 
+// pullRevision returns the revision selected with the flags of the pull, and
+// whether one was. The bucket and key of a state file are used unless they are
+// given with flags.
+func pullRevision(cmd *cobra.Command) (manifest.Revision, bool, error) {
+	switch {
+	case pullVersion != "":
+		return manifest.Revision{VersionID: pullVersion}, true, nil
+	case pullCommit != "":
+		return manifest.Revision{CommitSHA: pullCommit}, true, nil
+	case pullState == "":
+		return manifest.Revision{}, false, nil
+	}
+
+	raw, err := os.ReadFile(pullState)
+	if err != nil {
+		return manifest.Revision{}, false, err
+	}
+	state := t.State{}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return manifest.Revision{}, false, fmt.Errorf("invalid state file %s: %w", pullState, err)
+	}
+	if state.Snapshot == "" && state.VersionID == "" && state.CommitSHA == "" {
+		return manifest.Revision{}, false, fmt.Errorf("the state file %s records no push", pullState)
+	}
+
+	if state.Bucket != "" && !cmd.Flags().Changed("bucket") {
+		cmd.Flags().Set("bucket", state.Bucket)
+	}
+	if state.Key != "" && !cmd.Flags().Changed("manifest") {
+		cmd.Flags().Set("manifest", state.Key)
+	}
+	return manifest.RevisionOfState(state), true, nil
+}
+
 var ListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List manifest in the S3 bucket",
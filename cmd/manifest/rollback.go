@@ -0,0 +1,169 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"statectl/internal/aws/manifest"
+	"statectl/internal/aws/utils"
+	"statectl/internal/config"
+	"statectl/internal/utils/ci"
+	t "statectl/internal/utils/types"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	rollbackTo string
+	dryRun     bool
+)
+
+func init() {
+	RollbackCmd.Flags().StringVarP(&bucket, "bucket", "b", viper.GetString("BUCKET_NAME"), "S3 bucket that stores the manifest")
+	RollbackCmd.Flags().StringVarP(&manifestPath, "manifest", "m", viper.GetString("MANIFEST_KEY_PATH"), "S3 key point to the bucket key that stores store the manifest")
+	RollbackCmd.Flags().StringVarP(&statePath, "state", "s", "state.json", "Local path to store the state file of the restored manifest")
+	RollbackCmd.Flags().StringVar(&lockKey, "lock-key", viper.GetString("LOCK_KEY_PATH"), "S3 key of the lock the manifest is rolled back under")
+	RollbackCmd.Flags().StringVar(&rollbackTo, "to", "", "push to roll back to: a number of pushes to go back, a snapshot ID, an S3 version ID or a commit SHA")
+	RollbackCmd.Flags().BoolVar(&dryRun, "dry-run", false, "show what the rollback would change without changing anything")
+	RollbackCmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency(), "number of files copied at once")
+	ci.AddFlags(RollbackCmd.Flags(), &buildOverride)
+	RollbackCmd.MarkFlagRequired("to")
+}
+
+var RollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore the manifest of an earlier push",
+	Long: `Restore the manifest tree in the S3 bucket to an earlier push.
+The files of the snapshot of the chosen push are copied on the server side to a
+new snapshot, which becomes the current one, and a new state file is written.
+The snapshots in between are kept, so a rollback can itself be rolled back.
+
+--to takes the number of pushes to go back from the current snapshot, a
+snapshot ID, the S3 version ID recorded in a state file, or a commit SHA (at
+least 7 characters), whose latest push is restored.
+
+The rollback is made under the state lock: the lock held by this workspace, or
+else one acquired for the rollback and released afterwards. With --dry-run, the
+changes are listed and nothing is changed.
+
+Usage:
+  statectl manifest rollback --to <version|commit|n-back> [--dry-run]
+
+Example:
+  # Show what restoring the previous push would change
+  statectl manifest rollback --to 1 --dry-run
+
+  # Restore the latest push of a commit
+  statectl manifest rollback --to 1a2b3c4d
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+			log.SetLevel(logrus.DebugLevel)
+		}
+		log.Debug("Running manifest rollback command")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cli := utils.GetS3Client()
+
+		bucket, manifestPath, err := utils.GetS3BucketAndManifest(cmd)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Failed to get S3 bucket/key: ", err))
+			os.Exit(1)
+		}
+		log.Debug("S3 bucket/key: ", bucket, manifestPath)
+
+		if dryRun {
+			plan, err := manifest.PlanRollback(context.Background(), cli, bucket, manifestPath, rollbackTo)
+			if err != nil {
+				cmd.PrintErrln(config.Red("❌ Failed to plan the rollback: ", err))
+				os.Exit(1)
+			}
+			printRollbackPlan(cmd, plan)
+			cmd.Println(config.Yellow("Dry run, nothing was changed."))
+			return
+		}
+
+		token, release, err := lockForRollback(context.Background(), cmd, cli, bucket)
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ Refusing to roll back the manifest: ", err))
+			os.Exit(1)
+		}
+
+		err = rollbackManifest(cmd, cli, bucket, manifestPath, token)
+		release()
+		if err != nil {
+			cmd.PrintErrln(config.Red("❌ ", err))
+			os.Exit(1)
+		}
+
+		cmd.Println(config.Green("manifest has been successfully rolled back"))
+	},
+}
+
+// lockForRollback returns the fencing token of the state lock held by this
+// workspace, or else acquires the lock for the rollback.
+func lockForRollback(ctx context.Context, cmd *cobra.Command, cli t.S3Client, bucket string) (int64, func(), error) {
+	if lockKey == "" {
+		return 0, func() {}, errors.New("no lock key set, use --lock-key or LOCK_KEY_PATH")
+	}
+
+	locker, err := newLocker(cli)
+	if err != nil {
+		return 0, func() {}, err
+	}
+
+	if held, err := heldLock(ctx, locker, bucket); err == nil {
+		log.Debugf("Rolling back under the lock held by this workspace (fencing token %d)", held.FencingToken)
+		return fencingTokenOf(held), func() {}, nil
+	}
+	return acquireForPush(ctx, cmd, cli, locker, bucket)
+}
+
+// rollbackManifest restores the manifest of the push selected with --to under
+// the fencing token, and writes the state file of the restored manifest.
+func rollbackManifest(cmd *cobra.Command, cli t.S3Client, bucket, manifestPath string, token int64) error {
+	if err := commitFencingToken(cli, bucket, token); err != nil {
+		return err
+	}
+
+	plan, err := manifest.PlanRollback(context.Background(), cli, bucket, manifestPath, rollbackTo)
+	if err != nil {
+		return fmt.Errorf("failed to plan the rollback: %w", err)
+	}
+	printRollbackPlan(cmd, plan)
+
+	build := ci.Resolve(buildOverride)
+	snapshot, err := manifest.Rollback(context.Background(), cli, bucket, plan, token, build, concurrency)
+	if err != nil {
+		return fmt.Errorf("failed to roll back the manifest: %w", err)
+	}
+	cmd.Printf("Restored the snapshot %s to the new snapshot %s\n", plan.Target.ID, snapshot.ID)
+
+	if statePath != "" {
+		if err := manifest.CreateStateJSON(context.Background(), cli, bucket, manifestPath, statePath, build, snapshot); err != nil {
+			return fmt.Errorf("failed to create the state json file: %w", err)
+		}
+	}
+	return nil
+}
+
+// printRollbackPlan logs the files the rollback changes.
+func printRollbackPlan(cmd *cobra.Command, plan manifest.RollbackPlan) {
+	cmd.Printf("Rolling back %s from the snapshot %s (commit %s) to the snapshot %s (commit %s)\n",
+		plan.Root, plan.Current.ID, plan.Current.CommitSHA, plan.Target.ID, plan.Target.CommitSHA)
+
+	for _, change := range plan.Changes {
+		switch change.Change {
+		case manifest.ChangeAdded:
+			cmd.Println(config.Green("  + ", change.Key))
+		case manifest.ChangeRemoved:
+			cmd.Println(config.Red("  - ", change.Key))
+		default:
+			cmd.Println(config.Yellow("  ~ ", change.Key))
+		}
+	}
+	cmd.Printf("%d files changed, %d unchanged\n", len(plan.Changes), plan.Unchanged)
+}
@@ -23,8 +23,8 @@ func init() {
 		completionCmd,
 	)
 
-	lockCmds := []*cobra.Command{lock.AcquireCmd, lock.ReleaseCmd, lock.ForceReleaseCmd}
-	manifestCmds := []*cobra.Command{manifest.PushCmd, manifest.PullCmd, manifest.ListCmd}
+	lockCmds := []*cobra.Command{lock.AcquireCmd, lock.ReleaseCmd, lock.ForceReleaseCmd, lock.ForceAcquireCmd, lock.StatusCmd, lock.RenewCmd, lock.RunCmd, lock.HistoryCmd, lock.ListCmd, lock.QueueCmd}
+	manifestCmds := []*cobra.Command{manifest.PushCmd, manifest.PullCmd, manifest.ListCmd, manifest.RollbackCmd}
 	mngCmds := []*cobra.Command{versionCmd, updateCmd, completionCmd}
 
 	cmdGroup := template.CreatCmdGroup(
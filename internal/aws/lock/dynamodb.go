@@ -0,0 +1,459 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	t "statectl/internal/utils/types"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// Attributes of the lock items in the DynamoDB table. The table has the
+// partition key LockID, like the lock tables of Terraform, so the same table
+// can be shared with it.
+const (
+	dynamoLockIDAttr  = "LockID"
+	dynamoInfoAttr    = "Info"
+	dynamoOwnerAttr   = "Owner"
+	dynamoExpiresAttr = "Expires"
+	dynamoTokenAttr   = "Token"
+	// Attributes of the fence item, see t.Fence
+	dynamoAcquiredAttr  = "Acquired"
+	dynamoCommittedAttr = "Committed"
+)
+
+// DynamoDBLocker keeps the lock of the state at bucket/key in a DynamoDB table,
+// as one item whose LockID is "<bucket>/<key>". The lock is stored as JSON in
+// the Info attribute, and every update is conditional on the Info that was
+// read, the way the S3 lock is conditional on the ETag. Fencing tokens are
+// handed out, and committed, in a fence item next to the lock.
+//
+// The Expires attribute holds the end of the lease as a Unix timestamp, so it
+// can be used as the TTL attribute of the table to clean up expired locks.
+// Shared locks and the lock queue are only supported by the S3 lock.
+type DynamoDBLocker struct {
+	Client t.DynamoDBClient
+	Table  string
+}
+
+// Acquire creates the lock item, taking over an expired lock, as AcquireStateLock does.
+func (d DynamoDBLocker) Acquire(ctx context.Context, bucket, key string, lockInfo t.LockInfo) (t.LockInfo, error) {
+	if lockInfo.OwnerToken == "" {
+		return t.LockInfo{}, ErrNoOwnerToken
+	}
+
+	fencingToken, err := d.nextFencingToken(ctx, bucket, key)
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+	lockInfo.FencingToken = fencingToken
+
+	err = d.putLock(ctx, bucket, key, "", lockInfo)
+	if err == nil {
+		if err := d.recordAcquiredToken(ctx, bucket, key, lockInfo); err != nil {
+			return t.LockInfo{}, err
+		}
+		emit(ctx, t.EventAcquire, bucket, key, lockInfo, "")
+		return lockInfo, nil
+	}
+	if !isConditionFailed(err) {
+		return t.LockInfo{}, err
+	}
+
+	// The lock already exists, find out whether it is ours
+	exist, remote, info, err := d.getLock(ctx, bucket, key)
+	if err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to acquire lock: lock already exists, and it could not be read: %w", err)
+	}
+	if !exist {
+		return t.LockInfo{}, fmt.Errorf("unable to acquire lock: %w: the lock was released while acquiring it, please retry", ErrPreconditionFailed)
+	}
+	if remote.Expired(time.Now()) {
+		log.Warnf("Lock held by %s (signer: %s) expired at %s, taking it over", remote.LockID, remote.Signer, remote.ExpiresAt)
+
+		remote.PreviousOwner = nil
+		lockInfo.PreviousOwner = &remote
+		lockInfo.Takeover = t.TakeoverExpired
+
+		err := d.putLock(ctx, bucket, key, info, lockInfo)
+		if isConditionFailed(err) {
+			return t.LockInfo{}, fmt.Errorf("unable to acquire lock: %w: the expired lock was reclaimed by another process first", ErrPreconditionFailed)
+		}
+		if err != nil {
+			return t.LockInfo{}, err
+		}
+		// The writes of the expired holder are refused from now on
+		if err := d.recordAcquiredToken(ctx, bucket, key, lockInfo); err != nil {
+			return t.LockInfo{}, err
+		}
+
+		emit(ctx, t.EventTakeover, bucket, key, lockInfo, "")
+		return lockInfo, nil
+	}
+	if remote.OwnerToken != lockInfo.OwnerToken {
+		return t.LockInfo{}, fmt.Errorf("unable to acquire lock: %w.\nthis can happen if the lock was created by another process or user.\nplease retry after the lock is released or use the force-release command", ErrLockHeld)
+	}
+	return remote, ErrLockExists
+}
+
+// Release deletes the lock item if it is held with the given owner token.
+func (d DynamoDBLocker) Release(ctx context.Context, bucket, key, token string) error {
+	if token == "" {
+		return fmt.Errorf("unable to release lock: %w", ErrNoOwnerToken)
+	}
+
+	exist, remote, info, err := d.getLock(ctx, bucket, key)
+	if err != nil {
+		return fmt.Errorf("unable to release lock: %v", err)
+	}
+	if !exist {
+		return fmt.Errorf("unable to release lock: lock does not exist")
+	}
+	if remote.OwnerToken != token {
+		return fmt.Errorf("unable to release lock: %w (lock ID: %s, signer: %s). If you are sure you want to release the lock, use the force-release command", ErrNotOwner, remote.LockID, remote.Signer)
+	}
+	if err := checkSchema(remote); err != nil {
+		return fmt.Errorf("unable to release lock: %w", err)
+	}
+
+	if err := d.deleteLock(ctx, bucket, key, info); err != nil {
+		return fmt.Errorf("unable to release lock: %w", err)
+	}
+
+	emit(ctx, t.EventRelease, bucket, key, remote, "")
+	return nil
+}
+
+// ForceRelease deletes the lock item, whoever holds it. Before the lock is
+// deleted, a record of the removed lock, the actor and the reason is written as
+// an item whose LockID starts with DefaultForceReleasePrefix of the one of the
+// lock, as ForceReleaseLock does. The lock is not deleted if the record cannot
+// be written.
+func (d DynamoDBLocker) ForceRelease(ctx context.Context, bucket, key, reason string) error {
+	exist, info, err := d.getRawLock(ctx, bucket, key)
+	if err != nil || !exist {
+		return err
+	}
+
+	// A lock that cannot be decoded is still removed
+	holder, err := t.DecodeLockInfo([]byte(info))
+	if err != nil {
+		log.Warnf("Failed to decode the lock being released: %v", err)
+	}
+
+	if err := d.recordForceRelease(ctx, bucket, key, reason, holder); err != nil {
+		return fmt.Errorf("unable to record the force-release, the lock was not released: %w", err)
+	}
+
+	if err := d.deleteLock(ctx, bucket, key, info); err != nil {
+		return err
+	}
+
+	emit(ctx, t.EventForceRelease, bucket, key, holder, reason)
+	return nil
+}
+
+// Status reads the lock item, and reports whether the state is locked.
+func (d DynamoDBLocker) Status(ctx context.Context, bucket, key string) (bool, t.LockInfo, error) {
+	exist, lockInfo, _, err := d.getLock(ctx, bucket, key)
+	return exist, lockInfo, err
+}
+
+// Renew refreshes the heartbeat of the lock held with the owner token and extends
+// its lease by ttl, as RenewStateLock does.
+func (d DynamoDBLocker) Renew(ctx context.Context, bucket, key, token string, ttl time.Duration) (t.LockInfo, error) {
+	exist, remote, info, err := d.getLock(ctx, bucket, key)
+	if err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w", err)
+	}
+	if !exist {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w: the lock does not exist", ErrLockLost)
+	}
+	if token == "" || remote.OwnerToken != token {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w: the lock is held by %s (signer: %s)", ErrLockLost, remote.LockID, remote.Signer)
+	}
+	if err := checkSchema(remote); err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w", err)
+	}
+
+	if ttl <= 0 {
+		ttl = remote.Lease()
+	}
+	now := time.Now()
+	renewed := remote.WithLease(now, ttl)
+	renewed.Heartbeat = now.UTC().Format(time.RFC3339)
+
+	err = d.putLock(ctx, bucket, key, info, renewed)
+	if isConditionFailed(err) {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w: the lock was modified by another process since it was read", ErrLockLost)
+	}
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+
+	emit(ctx, t.EventRenew, bucket, key, renewed, "")
+	return renewed, nil
+}
+
+// CommitFencingToken records that the state is written with token in the fence
+// item, with an update conditional on the token not being stale, as
+// CommitFencingToken does with the fence object of the S3 lock.
+func (d DynamoDBLocker) CommitFencingToken(ctx context.Context, bucket, key string, token int64) error {
+	_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(d.Table),
+		Key:              dynamoKey(d.fenceID(bucket, key)),
+		UpdateExpression: aws.String("SET #committed = :token"),
+		ConditionExpression: aws.String("(attribute_not_exists(#acquired) OR #acquired <= :token) AND " +
+			"(attribute_not_exists(#committed) OR #committed <= :token)"),
+		ExpressionAttributeNames: map[string]string{"#acquired": dynamoAcquiredAttr, "#committed": dynamoCommittedAttr},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token": &types.AttributeValueMemberN{Value: strconv.FormatInt(token, 10)},
+		},
+	})
+	if !isConditionFailed(err) {
+		return err
+	}
+
+	// Tell which token the committed one is older than
+	fence, err := d.readFence(ctx, bucket, key)
+	if err != nil {
+		return fmt.Errorf("%w: token %d", ErrStaleFencingToken, token)
+	}
+	if err := checkFence(fence, token); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: the fence changed while committing, please retry", ErrPreconditionFailed)
+}
+
+// VerifyFencingToken fails with ErrStaleFencingToken if a write with token would
+// be refused by CommitFencingToken, without recording anything.
+func (d DynamoDBLocker) VerifyFencingToken(ctx context.Context, bucket, key string, token int64) error {
+	fence, err := d.readFence(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	return checkFence(fence, token)
+}
+
+// dynamoLockID returns the partition key of the lock of the state at bucket/key.
+func dynamoLockID(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+// dynamoKey returns the primary key of the item with the given LockID.
+func dynamoKey(lockID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		dynamoLockIDAttr: &types.AttributeValueMemberS{Value: lockID},
+	}
+}
+
+// putLock writes the lock item if its Info is still the given one, or if it does
+// not exist when info is empty.
+func (d DynamoDBLocker) putLock(ctx context.Context, bucket, key, info string, lockInfo t.LockInfo) error {
+	lockInfoRaw, err := json.Marshal(lockInfo)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Raw lock info: %s\n", lockInfoRaw)
+
+	item := dynamoKey(dynamoLockID(bucket, key))
+	item[dynamoInfoAttr] = &types.AttributeValueMemberS{Value: string(lockInfoRaw)}
+	item[dynamoOwnerAttr] = &types.AttributeValueMemberS{Value: lockInfo.OwnerToken}
+	if expiresAt, ok := lockInfo.Expiry(); ok {
+		item[dynamoExpiresAttr] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)}
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(d.Table),
+		Item:      item,
+	}
+	if info != "" {
+		input.ConditionExpression = aws.String("#info = :info")
+		input.ExpressionAttributeNames = map[string]string{"#info": dynamoInfoAttr}
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{":info": &types.AttributeValueMemberS{Value: info}}
+	} else {
+		input.ConditionExpression = aws.String("attribute_not_exists(#id)")
+		input.ExpressionAttributeNames = map[string]string{"#id": dynamoLockIDAttr}
+	}
+
+	_, err = d.Client.PutItem(ctx, input)
+	return err
+}
+
+// getLock reads and decodes the lock item with a strongly consistent read,
+// together with its raw Info to make the next update conditional on.
+func (d DynamoDBLocker) getLock(ctx context.Context, bucket, key string) (bool, t.LockInfo, string, error) {
+	exist, info, err := d.getRawLock(ctx, bucket, key)
+	if err != nil || !exist {
+		return false, t.LockInfo{}, "", err
+	}
+
+	lockInfo, err := t.DecodeLockInfo([]byte(info))
+	if err != nil {
+		return false, lockInfo, "", err
+	}
+	return true, lockInfo, info, nil
+}
+
+// getRawLock reads the Info of the lock item with a strongly consistent read,
+// without decoding it.
+func (d DynamoDBLocker) getRawLock(ctx context.Context, bucket, key string) (bool, string, error) {
+	resp, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.Table),
+		Key:            dynamoKey(dynamoLockID(bucket, key)),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, "", err
+	}
+	if resp.Item == nil {
+		return false, "", nil // Lock does not exist.
+	}
+
+	attr, ok := resp.Item[dynamoInfoAttr].(*types.AttributeValueMemberS)
+	if !ok {
+		return false, "", fmt.Errorf("lock item %s has no %s attribute", dynamoLockID(bucket, key), dynamoInfoAttr)
+	}
+	log.Debugf("Raw lock info: %s\n", attr.Value)
+	return true, attr.Value, nil
+}
+
+// deleteLock removes the lock item only if its Info is still the given one.
+func (d DynamoDBLocker) deleteLock(ctx context.Context, bucket, key, info string) error {
+	_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(d.Table),
+		Key:                       dynamoKey(dynamoLockID(bucket, key)),
+		ConditionExpression:       aws.String("#info = :info"),
+		ExpressionAttributeNames:  map[string]string{"#info": dynamoInfoAttr},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":info": &types.AttributeValueMemberS{Value: info}},
+	})
+	if isConditionFailed(err) {
+		return fmt.Errorf("%w: the lock was modified by another process since it was read", ErrPreconditionFailed)
+	}
+	return err
+}
+
+// recordForceRelease writes a record of the lock that is about to be force-released.
+func (d DynamoDBLocker) recordForceRelease(ctx context.Context, bucket, key, reason string, holder t.LockInfo) error {
+	raw, name, err := newForceReleaseRecord(bucket, key, reason, holder)
+	if err != nil {
+		return err
+	}
+
+	recordID := DefaultForceReleasePrefix(dynamoLockID(bucket, key)) + name
+	log.Debugf("Recording force-release to %s", recordID)
+
+	item := dynamoKey(recordID)
+	item[dynamoInfoAttr] = &types.AttributeValueMemberS{Value: string(raw)}
+	_, err = d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(d.Table),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]string{"#id": dynamoLockIDAttr},
+	})
+	return err
+}
+
+// fenceID returns the LockID of the fence item of the lock, the one of the lock
+// followed by ".fence".
+func (d DynamoDBLocker) fenceID(bucket, key string) string {
+	return DefaultFenceKey(dynamoLockID(bucket, key))
+}
+
+// nextFencingToken increments the fencing token counter of the fence item.
+func (d DynamoDBLocker) nextFencingToken(ctx context.Context, bucket, key string) (int64, error) {
+	resp, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.Table),
+		Key:                       dynamoKey(d.fenceID(bucket, key)),
+		UpdateExpression:          aws.String("ADD #token :one"),
+		ExpressionAttributeNames:  map[string]string{"#token": dynamoTokenAttr},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":one": &types.AttributeValueMemberN{Value: "1"}},
+		ReturnValues:              types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get a fencing token: %w", err)
+	}
+
+	attr, ok := resp.Attributes[dynamoTokenAttr].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, errors.New("unable to get a fencing token: the counter was not returned")
+	}
+	token, err := strconv.ParseInt(attr.Value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get a fencing token: %w", err)
+	}
+	log.Debugf("Fencing token of the lock: %d", token)
+	return token, nil
+}
+
+// recordAcquiredToken records in the fence item that the lock was acquired with
+// its fencing token, which fences off the writes of the holders before it. The
+// lock is removed if the token cannot be recorded.
+func (d DynamoDBLocker) recordAcquiredToken(ctx context.Context, bucket, key string, lockInfo t.LockInfo) error {
+	_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(d.Table),
+		Key:                      dynamoKey(d.fenceID(bucket, key)),
+		UpdateExpression:         aws.String("SET #acquired = :token"),
+		ConditionExpression:      aws.String("attribute_not_exists(#acquired) OR #acquired < :token"),
+		ExpressionAttributeNames: map[string]string{"#acquired": dynamoAcquiredAttr},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token": &types.AttributeValueMemberN{Value: strconv.FormatInt(lockInfo.FencingToken, 10)},
+		},
+	})
+	if err == nil || isConditionFailed(err) {
+		// A failed condition means a newer token is already recorded
+		return nil
+	}
+
+	exist, remote, info, getErr := d.getLock(ctx, bucket, key)
+	if getErr == nil && exist && remote.OwnerToken == lockInfo.OwnerToken {
+		getErr = d.deleteLock(ctx, bucket, key, info)
+	}
+	if getErr != nil {
+		log.Warnf("Failed to remove the lock that could not be acquired: %v", getErr)
+	}
+	return fmt.Errorf("unable to record the fencing token: %w", err)
+}
+
+// readFence reads the fencing tokens of the fence item, with a strongly
+// consistent read. A missing fence is returned empty.
+func (d DynamoDBLocker) readFence(ctx context.Context, bucket, key string) (t.Fence, error) {
+	resp, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.Table),
+		Key:            dynamoKey(d.fenceID(bucket, key)),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return t.Fence{}, fmt.Errorf("unable to read the fence: %w", err)
+	}
+
+	fence := t.Fence{}
+	for name, token := range map[string]*int64{
+		dynamoTokenAttr:     &fence.Token,
+		dynamoAcquiredAttr:  &fence.Acquired,
+		dynamoCommittedAttr: &fence.Committed,
+	} {
+		attr, ok := resp.Item[name].(*types.AttributeValueMemberN)
+		if !ok {
+			continue
+		}
+		if *token, err = strconv.ParseInt(attr.Value, 10, 64); err != nil {
+			return t.Fence{}, fmt.Errorf("unable to read the fence: %w", err)
+		}
+	}
+	return fence, nil
+}
+
+// isConditionFailed reports whether a DynamoDB write was refused because its
+// condition did not hold.
+func isConditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ConditionalCheckFailedException"
+}
@@ -0,0 +1,251 @@
+package lock_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"statectl/internal/aws/lock"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDynamoDBLockerAcquireRelease(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	locker := lock.DynamoDBLocker{Client: test.NewFakeDynamoDBClient(), Table: "locks"}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	other, _ := test.CreateLockInfo("0987654321")
+
+	// Call the functions under test
+	acquired, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo)
+	if err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+	if acquired.FencingToken != 1 {
+		t.Errorf("expected fencing token 1, got %d", acquired.FencingToken)
+	}
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); !errors.Is(err, lock.ErrLockExists) {
+		t.Errorf("expected ErrLockExists, got: %v", err)
+	}
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", other); !errors.Is(err, lock.ErrLockHeld) {
+		t.Errorf("expected ErrLockHeld, got: %v", err)
+	}
+
+	exist, holder, err := locker.Status(ctx, "test-bucket", "test-key")
+	if err != nil || !exist || holder.LockID != lockInfo.LockID {
+		t.Errorf("expected the lock to be held by %s, got %+v (%v)", lockInfo.LockID, holder, err)
+	}
+
+	if err := locker.Release(ctx, "test-bucket", "test-key", other.OwnerToken); !errors.Is(err, lock.ErrNotOwner) {
+		t.Errorf("expected ErrNotOwner, got: %v", err)
+	}
+	if err := locker.Release(ctx, "test-bucket", "test-key", lockInfo.OwnerToken); err != nil {
+		t.Fatalf("error releasing lock: %v", err)
+	}
+
+	// Assertions
+	if exist, _, _ := locker.Status(ctx, "test-bucket", "test-key"); exist {
+		t.Errorf("expected the lock to be released")
+	}
+	// Every attempt takes a fencing token, including the two that failed
+	acquired, err = locker.Acquire(ctx, "test-bucket", "test-key", other)
+	if err != nil || acquired.FencingToken != 4 {
+		t.Errorf("expected the lock to be acquired with fencing token 4, got %d (%v)", acquired.FencingToken, err)
+	}
+}
+
+func TestDynamoDBLockerTakeoverExpired(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	locker := lock.DynamoDBLocker{Client: test.NewFakeDynamoDBClient(), Table: "locks"}
+
+	expired, _ := test.CreateLockInfo("0987654321")
+	expired = expired.WithLease(time.Now().Add(-2*time.Hour), time.Hour)
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", expired); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// Call the function under test
+	acquired, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo)
+
+	// Assertions
+	if err != nil {
+		t.Fatalf("error taking over the expired lock: %v", err)
+	}
+	if acquired.Takeover != st.TakeoverExpired || acquired.PreviousOwner == nil || acquired.PreviousOwner.LockID != expired.LockID {
+		t.Errorf("expected a takeover of %s, got %+v", expired.LockID, acquired)
+	}
+}
+
+func TestDynamoDBLockerRenewForceRelease(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	locker := lock.DynamoDBLocker{Client: test.NewFakeDynamoDBClient(), Table: "locks"}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	lockInfo = lockInfo.WithLease(time.Now(), time.Minute)
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+
+	// Call the functions under test
+	renewed, err := locker.Renew(ctx, "test-bucket", "test-key", lockInfo.OwnerToken, time.Hour)
+	if err != nil {
+		t.Fatalf("error renewing lock: %v", err)
+	}
+	if remaining, _ := renewed.Remaining(time.Now()); remaining < 59*time.Minute {
+		t.Errorf("expected the lease to be extended by an hour, %s remaining", remaining)
+	}
+	if _, err := locker.Renew(ctx, "test-bucket", "test-key", "other-token", 0); !errors.Is(err, lock.ErrLockLost) {
+		t.Errorf("expected ErrLockLost, got: %v", err)
+	}
+
+	if err := locker.ForceRelease(ctx, "test-bucket", "test-key", "stuck pipeline"); err != nil {
+		t.Fatalf("error force-releasing lock: %v", err)
+	}
+
+	// Assertions
+	if exist, _, _ := locker.Status(ctx, "test-bucket", "test-key"); exist {
+		t.Errorf("expected the lock to be force-released")
+	}
+}
+
+func TestDynamoDBLockerForceReleaseRecord(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeDynamoDB := test.NewFakeDynamoDBClient()
+	locker := lock.DynamoDBLocker{Client: fakeDynamoDB, Table: "locks"}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+
+	// Call the function under test
+	if err := locker.ForceRelease(ctx, "test-bucket", "test-key", "stuck pipeline"); err != nil {
+		t.Fatalf("error force-releasing lock: %v", err)
+	}
+
+	// Assertions
+	records := fakeDynamoDB.LockIDs(lock.DefaultForceReleasePrefix("test-bucket/test-key"))
+	if len(records) != 1 {
+		t.Fatalf("expected one force-release record, got %v", records)
+	}
+	item, _ := fakeDynamoDB.Item(records[0])
+	raw, _ := item["Info"].(*types.AttributeValueMemberS)
+	var record st.ForceReleaseRecord
+	if raw == nil || json.Unmarshal([]byte(raw.Value), &record) != nil {
+		t.Fatalf("expected the record in the Info attribute, got %v", item)
+	}
+	if record.Reason != "stuck pipeline" || record.Actor == "" || record.Lock == nil || record.Lock.LockID != lockInfo.LockID || record.Lock.OwnerToken != "" {
+		t.Errorf("expected the reason, the actor and the removed lock without its owner token, got %+v", record)
+	}
+}
+
+func TestDynamoDBLockerForceReleaseRecordFails(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeDynamoDB := test.NewFakeDynamoDBClient()
+	locker := lock.DynamoDBLocker{Client: fakeDynamoDB, Table: "locks"}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+	locker.Client = &failingRecordClient{FakeDynamoDBClient: fakeDynamoDB}
+
+	// Call the function under test
+	err := locker.ForceRelease(ctx, "test-bucket", "test-key", "stuck pipeline")
+
+	// Assertions
+	if err == nil {
+		t.Fatalf("expected the force-release to fail without its record")
+	}
+	if exist, _, _ := locker.Status(ctx, "test-bucket", "test-key"); !exist {
+		t.Errorf("expected the lock to be kept when the record cannot be written")
+	}
+}
+
+func TestDynamoDBLockerAcquireUnreadable(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeDynamoDB := test.NewFakeDynamoDBClient()
+	locker := lock.DynamoDBLocker{Client: fakeDynamoDB, Table: "locks"}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+	locker.Client = &failingGetClient{FakeDynamoDBClient: fakeDynamoDB}
+
+	// Call the function under test
+	other, _ := test.CreateLockInfo("other")
+	_, err := locker.Acquire(ctx, "test-bucket", "test-key", other)
+
+	// Assertions
+	if !errors.Is(err, errAccessDenied) {
+		t.Errorf("expected the error reading the lock, got: %v", err)
+	}
+}
+
+var errAccessDenied = errors.New("access denied")
+
+// failingGetClient fails to read the items.
+type failingGetClient struct {
+	*test.FakeDynamoDBClient
+}
+
+func (f *failingGetClient) GetItem(ctx context.Context, input *dynamodb.GetItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return nil, errAccessDenied
+}
+
+// failingRecordClient fails to write the force-release records.
+type failingRecordClient struct {
+	*test.FakeDynamoDBClient
+}
+
+func (f *failingRecordClient) PutItem(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if id, ok := input.Item["LockID"].(*types.AttributeValueMemberS); ok && strings.Contains(id.Value, ".force-releases/") {
+		return nil, errors.New("access denied")
+	}
+	return f.FakeDynamoDBClient.PutItem(ctx, input, opts...)
+}
+
+func TestDynamoDBLockerForceReleaseCorruptLock(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeDynamoDB := test.NewFakeDynamoDBClient()
+	locker := lock.DynamoDBLocker{Client: fakeDynamoDB, Table: "locks"}
+
+	_, err := fakeDynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		Item: map[string]types.AttributeValue{
+			"LockID": &types.AttributeValueMemberS{Value: "test-bucket/test-key"},
+			"Info":   &types.AttributeValueMemberS{Value: "{not json"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error seeding the corrupt lock: %v", err)
+	}
+
+	// Call the function under test
+	if err := locker.ForceRelease(ctx, "test-bucket", "test-key", "corrupt lock"); err != nil {
+		t.Fatalf("expected the corrupt lock to be force-released, got: %v", err)
+	}
+
+	// Assertions
+	if _, ok := fakeDynamoDB.Item("test-bucket/test-key"); ok {
+		t.Errorf("expected the corrupt lock to be deleted")
+	}
+	if records := fakeDynamoDB.LockIDs(lock.DefaultForceReleasePrefix("test-bucket/test-key")); len(records) != 1 {
+		t.Errorf("expected one force-release record, got %v", records)
+	}
+}
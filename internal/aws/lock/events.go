@@ -0,0 +1,255 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"slices"
+	t "statectl/internal/utils/types"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+// eventTimeFormat sorts lexicographically in chronological order, so the
+// event objects are listed oldest first.
+const eventTimeFormat = "20060102T150405.000000000Z"
+
+// Recorder receives the events of the lock operations, e.g. to keep an audit trail.
+type Recorder interface {
+	Record(ctx context.Context, event t.LockEvent) error
+}
+
+type registeredRecorder struct {
+	id       int
+	recorder Recorder
+}
+
+var (
+	recordersMu    sync.RWMutex
+	recorders      []registeredRecorder
+	nextRecorderID int
+)
+
+// AddRecorder registers a recorder for the events of all lock operations.
+// The returned function unregisters it.
+func AddRecorder(r Recorder) func() {
+	recordersMu.Lock()
+	defer recordersMu.Unlock()
+
+	nextRecorderID++
+	id := nextRecorderID
+	recorders = append(recorders, registeredRecorder{id: id, recorder: r})
+
+	return func() {
+		recordersMu.Lock()
+		defer recordersMu.Unlock()
+		recorders = slices.DeleteFunc(recorders, func(rr registeredRecorder) bool { return rr.id == id })
+	}
+}
+
+// emit sends an event about the lock to every recorder, with the reason given
+// for forcing the lock, if any. Failing to record an event never fails the lock
+// operation itself.
+func emit(ctx context.Context, eventType, bucket, key string, lockInfo t.LockInfo, reason string) {
+	// The owner token is not needed to follow who held the lock
+	lockInfo.OwnerToken = ""
+
+	now := time.Now()
+	event := t.LockEvent{
+		ID:     uuid.New().String(),
+		Type:   eventType,
+		Time:   now.UTC().Format(time.RFC3339Nano),
+		Bucket: bucket,
+		Key:    key,
+		Actor:  Actor(),
+		LockID: lockInfo.LockID,
+		Signer: lockInfo.Signer,
+		Reason: reason,
+		Lock:   &lockInfo,
+	}
+	if eventType == t.EventRelease || eventType == t.EventForceRelease {
+		if age, ok := lockInfo.Age(now); ok {
+			event.HeldFor = age.Round(time.Second).String()
+		}
+	}
+
+	recordersMu.RLock()
+	defer recordersMu.RUnlock()
+	for _, rr := range recorders {
+		if err := rr.recorder.Record(ctx, event); err != nil {
+			log.Warnf("Failed to record %s event of the lock: %v", eventType, err)
+		}
+	}
+}
+
+// Actor identifies who runs statectl in the audit trail.
+func Actor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+	return name
+}
+
+// DefaultEventPrefix returns the prefix the events of the lock at key are stored under.
+func DefaultEventPrefix(key string) string {
+	return key + ".events/"
+}
+
+// EventLog is an append-only log of lock events in an S3 bucket, stored as
+// one object per event under a prefix of each lock.
+type EventLog struct {
+	Client t.S3Client
+	// Prefix the events are stored under, in a folder per lock key, e.g.
+	// "audit/<key>/"; DefaultEventPrefix of the lock key if empty.
+	Prefix string
+}
+
+func (l EventLog) prefix(key string) string {
+	if l.Prefix != "" {
+		return strings.TrimRight(l.Prefix, "/") + "/" + key + "/"
+	}
+	return DefaultEventPrefix(key)
+}
+
+// Record writes the event as a new object, never overwriting an existing one.
+func (l EventLog) Record(ctx context.Context, event t.LockEvent) error {
+	eventTime, err := time.Parse(time.RFC3339Nano, event.Time)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	eventKey := fmt.Sprintf("%s%s_%s_%s.json", l.prefix(event.Key), eventTime.UTC().Format(eventTimeFormat), event.Type, event.ID)
+	log.Debugf("Recording %s event to %s", event.Type, eventKey)
+
+	_, err = l.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(event.Bucket),
+		Key:         aws.String(eventKey),
+		Body:        strings.NewReader(string(raw)),
+		IfNoneMatch: aws.String("*"),
+	})
+	return err
+}
+
+// EventFilter selects the events returned by ListEvents.
+type EventFilter struct {
+	Since  time.Time
+	Until  time.Time
+	Signer string
+	Types  []string
+	// Limit keeps only the most recent events, zero keeps all of them.
+	Limit int
+}
+
+// matchKey selects the event objects by the time and type encoded in their key,
+// so only the matching events have to be read.
+func (f EventFilter) matchKey(prefix, eventKey string) bool {
+	parts := strings.SplitN(strings.TrimPrefix(eventKey, prefix), "_", 3)
+	if len(parts) != 3 {
+		return false
+	}
+
+	eventTime, err := time.Parse(eventTimeFormat, parts[0])
+	if err != nil {
+		return false
+	}
+	if !f.Since.IsZero() && eventTime.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && eventTime.After(f.Until) {
+		return false
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, parts[1]) {
+		return false
+	}
+	return true
+}
+
+// ListEvents returns the events of the lock at key matching the filter, oldest first.
+func (l EventLog) ListEvents(ctx context.Context, bucket, key string, filter EventFilter) ([]t.LockEvent, error) {
+	prefix := l.prefix(key)
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	if !filter.Since.IsZero() {
+		// Skip the events older than the time range
+		input.StartAfter = aws.String(prefix + filter.Since.UTC().Format(eventTimeFormat))
+	}
+
+	eventKeys := []string{}
+	paginator := s3.NewListObjectsV2Paginator(l.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			if eventKey := aws.ToString(object.Key); filter.matchKey(prefix, eventKey) {
+				eventKeys = append(eventKeys, eventKey)
+			}
+		}
+	}
+
+	// Without a signer filter, the most recent keys are the most recent events
+	if filter.Signer == "" && filter.Limit > 0 && len(eventKeys) > filter.Limit {
+		eventKeys = eventKeys[len(eventKeys)-filter.Limit:]
+	}
+
+	events := []t.LockEvent{}
+	for _, eventKey := range eventKeys {
+		event, err := l.readEvent(ctx, bucket, eventKey)
+		if err != nil {
+			log.Warnf("Skipping unreadable lock event %s: %v", eventKey, err)
+			continue
+		}
+		if filter.Signer != "" && event.Signer != filter.Signer {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[len(events)-filter.Limit:]
+	}
+	return events, nil
+}
+
+func (l EventLog) readEvent(ctx context.Context, bucket, eventKey string) (t.LockEvent, error) {
+	resp, err := l.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(eventKey),
+	})
+	if err != nil {
+		return t.LockEvent{}, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return t.LockEvent{}, err
+	}
+
+	event := t.LockEvent{}
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return t.LockEvent{}, err
+	}
+	return event, nil
+}
@@ -0,0 +1,186 @@
+package lock_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"statectl/internal/aws/lock"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/mock"
+)
+
+type recorderFunc func(ctx context.Context, event st.LockEvent) error
+
+func (f recorderFunc) Record(ctx context.Context, event st.LockEvent) error {
+	return f(ctx, event)
+}
+
+func TestAcquireStateLockRecordsEvent(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	events := []st.LockEvent{}
+	defer lock.AddRecorder(recorderFunc(func(ctx context.Context, event st.LockEvent) error {
+		events = append(events, event)
+		return nil
+	}))()
+
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	if _, err := lock.AcquireStateLock(ctx, mockS3, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring state lock: %v", err)
+	}
+
+	// Assertions
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	if events[0].Type != st.EventAcquire || events[0].LockID != lockInfo.LockID || events[0].Key != "test-key" {
+		t.Errorf("unexpected event: %+v", events[0])
+	}
+	if events[0].Lock.OwnerToken != "" {
+		t.Errorf("expected the owner token to be left out of the event")
+	}
+}
+
+func TestEventLogRecord(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	event := st.LockEvent{
+		ID:     "event-id",
+		Type:   st.EventForceRelease,
+		Time:   "2024-05-01T10:00:00Z",
+		Bucket: "test-bucket",
+		Key:    "locks/state.lock",
+	}
+
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.Key) == "locks/state.lock.events/20240501T100000.000000000Z_force-release_event-id.json" &&
+			aws.ToString(input.IfNoneMatch) == "*"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	if err := (lock.EventLog{Client: mockS3}).Record(ctx, event); err != nil {
+		t.Errorf("error recording event: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+}
+
+func TestEventLogRecordWithPrefix(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	eventLog := lock.EventLog{Client: fakeS3, Prefix: "audit"}
+
+	for _, key := range []string{"locks/prod.lock", "locks/staging.lock"} {
+		event := st.LockEvent{ID: key, Type: st.EventAcquire, Time: "2024-05-01T10:00:00Z", Bucket: "test-bucket", Key: key}
+
+		// Call the function under test
+		if err := eventLog.Record(ctx, event); err != nil {
+			t.Fatalf("error recording event: %v", err)
+		}
+	}
+
+	// Assertions
+	for _, key := range []string{"locks/prod.lock", "locks/staging.lock"} {
+		events, err := eventLog.ListEvents(ctx, "test-bucket", key, lock.EventFilter{})
+		if err != nil {
+			t.Fatalf("error listing events: %v", err)
+		}
+		if len(events) != 1 || events[0].Key != key {
+			t.Errorf("expected only the event of %s, got %+v", key, events)
+		}
+	}
+}
+
+func TestEventLogListEvents(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	prefix := "audit/test-key/"
+	stored := map[string]st.LockEvent{
+		prefix + "20240501T100000.000000000Z_acquire_1.json":       {ID: "1", Type: st.EventAcquire, Signer: "alice"},
+		prefix + "20240501T110000.000000000Z_release_2.json":       {ID: "2", Type: st.EventRelease, Signer: "alice"},
+		prefix + "20240502T100000.000000000Z_acquire_3.json":       {ID: "3", Type: st.EventAcquire, Signer: "bob"},
+		prefix + "20240502T120000.000000000Z_force-release_4.json": {ID: "4", Type: st.EventForceRelease, Signer: "bob"},
+	}
+	objects := []types.Object{}
+	for _, eventKey := range []string{
+		prefix + "20240501T100000.000000000Z_acquire_1.json",
+		prefix + "20240501T110000.000000000Z_release_2.json",
+		prefix + "20240502T100000.000000000Z_acquire_3.json",
+		prefix + "20240502T120000.000000000Z_force-release_4.json",
+	} {
+		objects = append(objects, types.Object{Key: aws.String(eventKey)})
+	}
+
+	// Mock ListObjectsV2
+	mockS3.On("ListObjectsV2", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.ListObjectsV2Input) bool {
+		return aws.ToString(input.Prefix) == prefix
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.ListObjectsV2Output{Contents: objects}, nil,
+	)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			raw, _ := json.Marshal(stored[aws.ToString(input.Key)])
+			return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(raw))}
+		}, nil,
+	)
+
+	eventLog := lock.EventLog{Client: mockS3, Prefix: "audit/"}
+
+	tests := []struct {
+		name     string
+		filter   lock.EventFilter
+		expected string
+	}{
+		{"all", lock.EventFilter{}, "1234"},
+		{"limit", lock.EventFilter{Limit: 2}, "34"},
+		{"type", lock.EventFilter{Types: []string{st.EventAcquire}}, "13"},
+		{"signer", lock.EventFilter{Signer: "alice", Limit: 1}, "2"},
+		{"until", lock.EventFilter{Until: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}, "12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := eventLog.ListEvents(ctx, "test-bucket", "test-key", tt.filter)
+			if err != nil {
+				t.Fatalf("error listing events: %v", err)
+			}
+
+			ids := []string{}
+			for _, event := range events {
+				ids = append(ids, event.ID)
+			}
+			if got := strings.Join(ids, ""); got != tt.expected {
+				t.Errorf("expected events %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
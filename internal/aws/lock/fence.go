@@ -0,0 +1,159 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	t "statectl/internal/utils/types"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxFenceAttempts bounds the retries of a fence update that raced with another process.
+const maxFenceAttempts = 10
+
+var ErrStaleFencingToken = errors.New("fencing token is older than the one of the latest holder of the lock")
+
+// DefaultFenceKey returns the key of the fence object of the lock at key, which
+// holds the last fencing token handed out and the last one committed.
+func DefaultFenceKey(key string) string {
+	return key + ".fence"
+}
+
+// NextFencingToken hands out a new fencing token for the lock at key. Tokens
+// increase monotonically over all acquisitions of the lock, but may skip values
+// when an acquisition fails.
+func NextFencingToken(ctx context.Context, cli t.S3Client, bucket, key string) (int64, error) {
+	var token int64
+	err := updateFence(ctx, cli, bucket, DefaultFenceKey(key), func(fence *t.Fence) error {
+		fence.Token++
+		token = fence.Token
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get a fencing token: %w", err)
+	}
+	log.Debugf("Fencing token of the lock: %d", token)
+	return token, nil
+}
+
+// RecordAcquiredToken records that the lock at key was acquired with token. From
+// then on, the writes of the holders with an older token are refused, even before
+// the new holder writes the state. Tokens handed out to acquisitions that failed
+// are never recorded, so they do not fence off the holder.
+func RecordAcquiredToken(ctx context.Context, cli t.S3Client, bucket, key string, token int64) error {
+	err := updateFence(ctx, cli, bucket, DefaultFenceKey(key), func(fence *t.Fence) error {
+		fence.Acquired = max(fence.Acquired, token)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to record the fencing token: %w", err)
+	}
+	return nil
+}
+
+// CommitFencingToken records that the state was written with token. It fails with
+// ErrStaleFencingToken if the lock was acquired since with a newer token, or if a
+// newer holder of the lock already committed a write, in which case the write
+// must not happen.
+func CommitFencingToken(ctx context.Context, cli t.S3Client, bucket, key string, token int64) error {
+	return updateFence(ctx, cli, bucket, DefaultFenceKey(key), func(fence *t.Fence) error {
+		if err := checkFence(*fence, token); err != nil {
+			return err
+		}
+		fence.Committed = token
+		return nil
+	})
+}
+
+// VerifyFencingToken fails with ErrStaleFencingToken if a write with token would
+// be refused by CommitFencingToken, without recording anything.
+func VerifyFencingToken(ctx context.Context, cli t.S3Client, bucket, key string, token int64) error {
+	fence, _, err := readFence(ctx, cli, bucket, DefaultFenceKey(key))
+	if err != nil {
+		return err
+	}
+	return checkFence(fence, token)
+}
+
+// checkFence fails with ErrStaleFencingToken if token is older than the one the
+// lock was last acquired with, or than the last committed one.
+func checkFence(fence t.Fence, token int64) error {
+	if token < fence.Acquired {
+		return fmt.Errorf("%w: token %d, lock acquired with %d", ErrStaleFencingToken, token, fence.Acquired)
+	}
+	if token < fence.Committed {
+		return fmt.Errorf("%w: token %d, last committed %d", ErrStaleFencingToken, token, fence.Committed)
+	}
+	return nil
+}
+
+// updateFence applies update to the fence object at fenceKey with a conditional
+// write, retrying if another process updated it at the same time.
+func updateFence(ctx context.Context, cli t.S3Client, bucket, fenceKey string, update func(fence *t.Fence) error) error {
+	for attempt := 0; attempt < maxFenceAttempts; attempt++ {
+		fence, etag, err := readFence(ctx, cli, bucket, fenceKey)
+		if err != nil {
+			return err
+		}
+		if err := update(&fence); err != nil {
+			return err
+		}
+		fence.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+		raw, err := json.Marshal(fence)
+		if err != nil {
+			return err
+		}
+
+		input := &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(fenceKey),
+			Body:   strings.NewReader(string(raw)),
+		}
+		if etag != "" {
+			input.IfMatch = aws.String(etag)
+		} else {
+			input.IfNoneMatch = aws.String("*")
+		}
+
+		_, err = cli.PutObject(ctx, input)
+		if !isPreconditionFailed(err) {
+			return err
+		}
+		log.Debugf("Fence %s was updated concurrently, retrying", fenceKey)
+	}
+	return fmt.Errorf("%w: the fence kept changing", ErrPreconditionFailed)
+}
+
+// readFence reads the fence object together with its ETag. A missing fence is
+// returned empty, with an empty ETag.
+func readFence(ctx context.Context, cli t.S3Client, bucket, fenceKey string) (t.Fence, string, error) {
+	resp, err := cli.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fenceKey),
+	})
+	if err != nil {
+		if errors.As(err, &KeyNotFound) {
+			return t.Fence{}, "", nil
+		}
+		return t.Fence{}, "", err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return t.Fence{}, "", err
+	}
+
+	fence := t.Fence{}
+	if err := json.Unmarshal(raw, &fence); err != nil {
+		return t.Fence{}, "", fmt.Errorf("failed to decode fence %s: %w", fenceKey, err)
+	}
+	return fence, aws.ToString(resp.ETag), nil
+}
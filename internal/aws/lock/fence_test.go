@@ -0,0 +1,193 @@
+package lock_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"statectl/internal/aws/lock"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockFenceObject(mockS3 *test.MockS3Client, fence st.Fence) {
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		return aws.ToString(input.Key) == lock.DefaultFenceKey("test-key")
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			raw, _ := json.Marshal(fence)
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(bytes.NewReader(raw)),
+				ETag: aws.String(`"fence"`),
+			}
+		}, nil,
+	)
+}
+
+func TestNextFencingToken(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	// NextFencingToken: GetObject -> PutObject If-Match (raced) -> GetObject -> PutObject If-Match
+	mockFenceObject(mockS3, st.Fence{Token: 41, Committed: 40})
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, errPreconditionFailed,
+	).Once()
+	var written st.Fence
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		if aws.ToString(input.IfMatch) != `"fence"` {
+			return false
+		}
+		return json.NewDecoder(input.Body).Decode(&written) == nil
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	).Once()
+
+	// Call the function under test
+	token, err := lock.NextFencingToken(ctx, mockS3, "test-bucket", "test-key")
+	if err != nil {
+		t.Fatalf("error getting a fencing token: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if token != 42 || written.Token != 42 || written.Committed != 40 {
+		t.Errorf("expected fencing token 42 with 40 committed, got %d and fence %+v", token, written)
+	}
+}
+
+func TestNextFencingTokenFirst(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	// NextFencingToken: GetObject (no fence) -> PutObject If-None-Match
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{}, &types.NoSuchKey{},
+	)
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.IfNoneMatch) == "*"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	token, err := lock.NextFencingToken(ctx, mockS3, "test-bucket", "test-key")
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if err != nil || token != 1 {
+		t.Errorf("expected the first fencing token to be 1, got %d (%v)", token, err)
+	}
+}
+
+func TestCommitFencingTokenStale(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	// CommitFencingToken: GetObject, a newer holder already committed
+	mockFenceObject(mockS3, st.Fence{Token: 5, Committed: 5})
+
+	// Call the function under test
+	err := lock.CommitFencingToken(ctx, mockS3, "test-bucket", "test-key", 3)
+
+	// Assertions
+	if !errors.Is(err, lock.ErrStaleFencingToken) {
+		t.Errorf("expected ErrStaleFencingToken, got: %v", err)
+	}
+	mockS3.AssertNotCalled(t, "PutObject")
+}
+
+func TestAcquireStateLockFencingToken(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 6)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// Mock PutObject
+	var written st.LockInfo
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return json.NewDecoder(input.Body).Decode(&written) == nil
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	acquired, err := lock.AcquireStateLock(ctx, mockS3, "test-bucket", "test-key", lockInfo)
+	if err != nil {
+		t.Fatalf("error acquiring state lock: %v", err)
+	}
+
+	// Assertions
+	if acquired.FencingToken != 7 || written.FencingToken != 7 {
+		t.Errorf("expected the lock to get fencing token 7, got %d (written %d)", acquired.FencingToken, written.FencingToken)
+	}
+}
+
+func TestCommitFencingTokenAfterTakeover(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+
+	expiredInfo, _ := test.CreateLockInfo("expired")
+	expired, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", expiredInfo.WithLease(time.Now().Add(-time.Hour), time.Minute))
+	if err != nil {
+		t.Fatalf("error acquiring state lock: %v", err)
+	}
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	holder, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo)
+	if err != nil || holder.Takeover != st.TakeoverExpired {
+		t.Fatalf("expected the expired lock to be taken over, got %+v (%v)", holder, err)
+	}
+
+	// Call the function under test
+	err = lock.CommitFencingToken(ctx, fakeS3, "test-bucket", "test-key", expired.FencingToken)
+
+	// Assertions
+	if !errors.Is(err, lock.ErrStaleFencingToken) {
+		t.Errorf("expected the token of the expired holder to be refused before the new holder writes, got: %v", err)
+	}
+	if err := lock.CommitFencingToken(ctx, fakeS3, "test-bucket", "test-key", holder.FencingToken); err != nil {
+		t.Errorf("expected the token of the new holder to be committed, got: %v", err)
+	}
+}
+
+func TestCommitFencingTokenAfterFailedAcquire(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	holder, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo)
+	if err != nil {
+		t.Fatalf("error acquiring state lock: %v", err)
+	}
+	waiterInfo, _ := test.CreateLockInfo("waiter")
+	if _, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", waiterInfo); !errors.Is(err, lock.ErrLockHeld) {
+		t.Fatalf("expected ErrLockHeld, got: %v", err)
+	}
+
+	// Call the function under test
+	err = lock.CommitFencingToken(ctx, fakeS3, "test-bucket", "test-key", holder.FencingToken)
+
+	// Assertions
+	if err != nil {
+		t.Errorf("expected the holder not to be fenced off by an acquisition that failed, got: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Heartbeat renews the lock held with the owner token every interval until ctx is cancelled.
+//
+// Transient errors are logged and retried on the next tick. If the lock is lost, the
+// ErrLockLost error is sent on the returned channel and the heartbeat stops, so the
+// caller can abort the work it was protecting. The channel is closed when the
+// heartbeat stops.
+func Heartbeat(ctx context.Context, l Locker, bucket, key, token string, interval, ttl time.Duration) <-chan error {
+	lost := make(chan error, 1)
+
+	go func() {
+		defer close(lost)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			lockInfo, err := l.Renew(ctx, bucket, key, token, ttl)
+			switch {
+			case err == nil:
+				log.Debugf("Lock heartbeat sent, lease expires at %s", lockInfo.ExpiresAt)
+			case errors.Is(err, ErrLockLost):
+				lost <- err
+				return
+			case ctx.Err() != nil:
+				return
+			default:
+				log.Warnf("Failed to renew lock, retrying in %s: %v", interval, err)
+			}
+		}
+	}()
+
+	return lost
+}
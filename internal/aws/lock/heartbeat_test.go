@@ -0,0 +1,76 @@
+package lock_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"statectl/internal/aws/lock"
+	"statectl/test"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHeartbeatReportsLockLost(t *testing.T) {
+	// Set up
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mockS3 := new(test.MockS3Client)
+
+	// Heartbeat: GetObject (lock was force-released)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.Anything, mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{}, &types.NoSuchKey{},
+	)
+
+	// Call the function under test
+	lost := lock.Heartbeat(ctx, lock.S3Locker{Client: mockS3}, "test-bucket", "test-key", "1234567890-token", 10*time.Millisecond, time.Minute)
+
+	// Assertions
+	select {
+	case err := <-lost:
+		if !errors.Is(err, lock.ErrLockLost) {
+			t.Errorf("expected ErrLockLost, got: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("expected heartbeat to report the lost lock")
+	}
+}
+
+func TestHeartbeatStopsOnCancel(t *testing.T) {
+	// Set up
+	ctx, cancel := context.WithCancel(context.Background())
+	mockS3 := new(test.MockS3Client)
+	_, lockInfoRaw := test.CreateLockInfo("1234567890")
+
+	// Heartbeat: GetObject -> PutObject If-Match
+	// Mock GetObject
+	mockS3.On("GetObject", mock.Anything, mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(lockInfoRaw))}
+		}, nil,
+	)
+	// Mock PutObject
+	mockS3.On("PutObject", mock.Anything, mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	lost := lock.Heartbeat(ctx, lock.S3Locker{Client: mockS3}, "test-bucket", "test-key", "1234567890-token", 10*time.Millisecond, time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	// Assertions
+	select {
+	case err, ok := <-lost:
+		if ok {
+			t.Errorf("expected heartbeat to stop without error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected heartbeat to stop when its context is cancelled")
+	}
+}
@@ -0,0 +1,57 @@
+package lock
+
+import (
+	"statectl/internal/utils/subproc"
+	t "statectl/internal/utils/types"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NewLockInfo describes the lock of the running pipeline, using the commit SHA
+// and pipeline ID of the build when they are available. The commit SHA is only
+// metadata, the owner token identifies who holds the lock.
+func NewLockInfo(token string, build t.BuildInfo) t.LockInfo {
+	extra_comment := ""
+
+	commit_sha := build.Commit
+	cs_comment := "ok"
+	if commit_sha == "" {
+		var err error
+		commit_sha, err = subproc.FetchLocalSHA()
+		cs_comment = "No CI commit SHA available, using local commit SHA"
+		if err != nil {
+			commit_sha = uuid.New().String()
+			cs_comment = "No commit SHA available, using random UUID"
+		}
+	}
+
+	trigger_iid := build.PipelineID
+	ti_comment := "ok"
+	if trigger_iid == "" {
+		trigger_iid = uuid.New().String()
+		ti_comment = "No pipeline ID available, using random UUID"
+	}
+
+	if cs_comment != "ok" || ti_comment != "ok" {
+		extra_comment = "WARNING: one or more environment variables were not found. Use timestamp as reference to check the exact commit and pipeline ID."
+	}
+
+	lockInfo := t.LockInfo{
+		SchemaVersion: t.LockSchemaVersion,
+		LockID:        commit_sha,
+		TimeStamp:     time.Now().Format(time.RFC3339),
+		Signer:        trigger_iid,
+		OwnerToken:    token,
+		Mode:          t.LockModeExclusive,
+		Comments: t.Comments{
+			Commit:  cs_comment,
+			Trigger: ti_comment,
+			Extra:   extra_comment,
+		},
+	}
+	if build != (t.BuildInfo{}) {
+		lockInfo.Build = &build
+	}
+	return lockInfo
+}
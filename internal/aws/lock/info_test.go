@@ -0,0 +1,106 @@
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"statectl/internal/aws/lock"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"testing"
+	"time"
+)
+
+func TestDecodeLockInfo(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		wantVersion   int
+		wantMode      string
+		wantSupported bool
+	}{
+		{
+			name:          "unversioned lock",
+			raw:           `{"lock_id":"1234567890","timestamp":"2021-01-01T00:00:00Z","signer":"test","comments":{"commit":"ok","trigger":"ok","extra":""}}`,
+			wantVersion:   st.LockSchemaVersion,
+			wantMode:      st.LockModeExclusive,
+			wantSupported: true,
+		},
+		{
+			name:          "current lock",
+			raw:           `{"schema_version":1,"lock_id":"1234567890","mode":"shared"}`,
+			wantVersion:   1,
+			wantMode:      st.LockModeShared,
+			wantSupported: true,
+		},
+		{
+			name:          "newer lock",
+			raw:           `{"schema_version":99,"lock_id":"1234567890","owner_token":"1234567890-token","new_field":{"a":1}}`,
+			wantVersion:   99,
+			wantSupported: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Call the function under test
+			lockInfo, err := st.DecodeLockInfo([]byte(tt.raw))
+
+			// Assertions
+			if err != nil {
+				t.Fatalf("error decoding lock: %v", err)
+			}
+			if lockInfo.LockID != "1234567890" {
+				t.Errorf("expected lock ID 1234567890, got %q", lockInfo.LockID)
+			}
+			if lockInfo.SchemaVersion != tt.wantVersion || lockInfo.Mode != tt.wantMode || lockInfo.Supported() != tt.wantSupported {
+				t.Errorf("expected version %d, mode %q and supported %v, got %d, %q and %v",
+					tt.wantVersion, tt.wantMode, tt.wantSupported, lockInfo.SchemaVersion, lockInfo.Mode, lockInfo.Supported())
+			}
+		})
+	}
+}
+
+func TestDecodeLockInfoUpgradesPreviousOwner(t *testing.T) {
+	// Call the function under test
+	lockInfo, err := st.DecodeLockInfo([]byte(`{"schema_version":1,"lock_id":"1234567890","mode":"exclusive","previous_owner":{"lock_id":"0987654321"}}`))
+
+	// Assertions
+	if err != nil {
+		t.Fatalf("error decoding lock: %v", err)
+	}
+	if lockInfo.PreviousOwner == nil || lockInfo.PreviousOwner.SchemaVersion != st.LockSchemaVersion || lockInfo.PreviousOwner.Mode != st.LockModeExclusive {
+		t.Errorf("expected the previous owner to be upgraded, got %+v", lockInfo.PreviousOwner)
+	}
+}
+
+func TestNewerSchemaLockIsNotChanged(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	lockInfo.SchemaVersion = st.LockSchemaVersion + 1
+	putJSON(t, fakeS3, "test-key", lockInfo)
+
+	locker := lock.LocalLocker{Dir: t.TempDir()}
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring local lock: %v", err)
+	}
+
+	// Call the functions under test
+	releaseErr := lock.ReleaseStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo.OwnerToken)
+	_, renewErr := lock.RenewStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo.OwnerToken, time.Minute)
+	localErr := locker.Release(ctx, "test-bucket", "test-key", lockInfo.OwnerToken)
+
+	// Assertions
+	for _, err := range []error{releaseErr, renewErr, localErr} {
+		if !errors.Is(err, lock.ErrUnsupportedSchema) {
+			t.Errorf("expected ErrUnsupportedSchema, got: %v", err)
+		}
+	}
+	if _, ok := fakeS3.Object("test-key"); !ok {
+		t.Errorf("expected the lock to be kept")
+	}
+	if err := lock.ForceReleaseLock(ctx, fakeS3, "test-bucket", "test-key", "upgrade pending"); err != nil {
+		t.Errorf("expected force-release to remove the lock, got: %v", err)
+	}
+}
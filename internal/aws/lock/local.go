@@ -0,0 +1,355 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	t "statectl/internal/utils/types"
+	"time"
+)
+
+// localLockRetry is the delay between two attempts to take the file lock of a
+// local lock while another process holds it.
+const localLockRetry = 10 * time.Millisecond
+
+// LocalLocker keeps the locks as JSON files under Dir, for pipelines that run on
+// a single machine and for tests. The lock of the state at bucket/key is the file
+// <Dir>/<bucket>/<key>. Every operation holds an exclusive file lock on
+// <file>.flock while it reads and writes the lock, so the processes of the
+// machine see a consistent lock, and fencing tokens are counted and committed in
+// <file>.fence.
+type LocalLocker struct {
+	Dir string
+}
+
+// DefaultLocalDir returns the directory of the local locks when LOCK_LOCAL_DIR is
+// not set, shared by every workspace of the machine.
+func DefaultLocalDir() string {
+	return filepath.Join(os.TempDir(), "statectl", "locks")
+}
+
+// Acquire writes the lock file, taking over an expired lock, as AcquireStateLock does.
+func (l LocalLocker) Acquire(ctx context.Context, bucket, key string, lockInfo t.LockInfo) (t.LockInfo, error) {
+	if lockInfo.OwnerToken == "" {
+		return t.LockInfo{}, ErrNoOwnerToken
+	}
+
+	eventType := t.EventAcquire
+	err := l.withLock(ctx, bucket, key, func(path string) error {
+		exist, remote, err := readLocalLock(path)
+		if err != nil {
+			return err
+		}
+		if exist {
+			if !remote.Expired(time.Now()) {
+				if remote.OwnerToken != lockInfo.OwnerToken {
+					return fmt.Errorf("unable to acquire lock: %w.\nthis can happen if the lock was created by another process or user.\nplease retry after the lock is released or use the force-release command", ErrLockHeld)
+				}
+				lockInfo = remote
+				return ErrLockExists
+			}
+			log.Warnf("Lock held by %s (signer: %s) expired at %s, taking it over", remote.LockID, remote.Signer, remote.ExpiresAt)
+
+			remote.PreviousOwner = nil
+			lockInfo.PreviousOwner = &remote
+			lockInfo.Takeover = t.TakeoverExpired
+			eventType = t.EventTakeover
+		}
+
+		fencingToken, err := nextLocalFencingToken(path + ".fence")
+		if err != nil {
+			return err
+		}
+		lockInfo.FencingToken = fencingToken
+
+		return writeJSONFile(path, lockInfo)
+	})
+	if errors.Is(err, ErrLockExists) {
+		return lockInfo, err
+	}
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+
+	emit(ctx, eventType, bucket, key, lockInfo, "")
+	return lockInfo, nil
+}
+
+// Release deletes the lock file if it is held with the given owner token.
+func (l LocalLocker) Release(ctx context.Context, bucket, key, token string) error {
+	if token == "" {
+		return fmt.Errorf("unable to release lock: %w", ErrNoOwnerToken)
+	}
+
+	var remote t.LockInfo
+	err := l.withLock(ctx, bucket, key, func(path string) error {
+		exist, lockInfo, err := readLocalLock(path)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return errors.New("lock does not exist")
+		}
+		if lockInfo.OwnerToken != token {
+			return fmt.Errorf("%w (lock ID: %s, signer: %s). If you are sure you want to release the lock, use the force-release command", ErrNotOwner, lockInfo.LockID, lockInfo.Signer)
+		}
+		if err := checkSchema(lockInfo); err != nil {
+			return err
+		}
+		remote = lockInfo
+		return os.Remove(path)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to release lock: %w", err)
+	}
+
+	emit(ctx, t.EventRelease, bucket, key, remote, "")
+	return nil
+}
+
+// ForceRelease deletes the lock file, whoever holds it. Before the file is
+// removed, a record of the removed lock, the actor and the reason is written
+// under <file>.force-releases/, as ForceReleaseLock does. The lock is not
+// removed if the record cannot be written.
+func (l LocalLocker) ForceRelease(ctx context.Context, bucket, key, reason string) error {
+	var holder t.LockInfo
+	exist := false
+	err := l.withLock(ctx, bucket, key, func(path string) error {
+		lockInfoRaw, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // Lock does not exist.
+		}
+		if err != nil {
+			return err
+		}
+		exist = true
+
+		// A lock that cannot be decoded is still removed
+		if holder, err = t.DecodeLockInfo(lockInfoRaw); err != nil {
+			log.Warnf("Failed to decode the lock being released: %v", err)
+		}
+		if err := recordLocalForceRelease(path, bucket, key, reason, holder); err != nil {
+			return fmt.Errorf("unable to record the force-release, the lock was not released: %w", err)
+		}
+		return os.Remove(path)
+	})
+	if err != nil || !exist {
+		return err
+	}
+
+	emit(ctx, t.EventForceRelease, bucket, key, holder, reason)
+	return nil
+}
+
+// CommitFencingToken records that the state is written with token in the fence
+// file, as CommitFencingToken does with the fence object of the S3 lock.
+func (l LocalLocker) CommitFencingToken(ctx context.Context, bucket, key string, token int64) error {
+	return l.withLock(ctx, bucket, key, func(path string) error {
+		return updateLocalFence(path+".fence", func(fence *t.Fence) error {
+			if err := checkFence(*fence, token); err != nil {
+				return err
+			}
+			fence.Committed = token
+			return nil
+		})
+	})
+}
+
+// VerifyFencingToken fails with ErrStaleFencingToken if a write with token would
+// be refused by CommitFencingToken, without recording anything.
+func (l LocalLocker) VerifyFencingToken(ctx context.Context, bucket, key string, token int64) error {
+	return l.withLock(ctx, bucket, key, func(path string) error {
+		fence, err := readLocalFence(path + ".fence")
+		if err != nil {
+			return err
+		}
+		return checkFence(fence, token)
+	})
+}
+
+// Status reads the lock file, and reports whether the state is locked.
+func (l LocalLocker) Status(ctx context.Context, bucket, key string) (bool, t.LockInfo, error) {
+	var lockInfo t.LockInfo
+	exist := false
+	err := l.withLock(ctx, bucket, key, func(path string) error {
+		var err error
+		exist, lockInfo, err = readLocalLock(path)
+		return err
+	})
+	return exist, lockInfo, err
+}
+
+// Renew refreshes the heartbeat of the lock held with the owner token and extends
+// its lease by ttl, as RenewStateLock does.
+func (l LocalLocker) Renew(ctx context.Context, bucket, key, token string, ttl time.Duration) (t.LockInfo, error) {
+	var renewed t.LockInfo
+	err := l.withLock(ctx, bucket, key, func(path string) error {
+		exist, remote, err := readLocalLock(path)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return fmt.Errorf("%w: the lock does not exist", ErrLockLost)
+		}
+		if token == "" || remote.OwnerToken != token {
+			return fmt.Errorf("%w: the lock is held by %s (signer: %s)", ErrLockLost, remote.LockID, remote.Signer)
+		}
+		if err := checkSchema(remote); err != nil {
+			return err
+		}
+
+		if ttl <= 0 {
+			ttl = remote.Lease()
+		}
+		now := time.Now()
+		renewed = remote.WithLease(now, ttl)
+		renewed.Heartbeat = now.UTC().Format(time.RFC3339)
+
+		return writeJSONFile(path, renewed)
+	})
+	if err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w", err)
+	}
+
+	emit(ctx, t.EventRenew, bucket, key, renewed, "")
+	return renewed, nil
+}
+
+// path returns the lock file of the state at bucket/key. Keys that would leave
+// the lock directory are refused.
+func (l LocalLocker) path(bucket, key string) (string, error) {
+	rel := filepath.Join(bucket, filepath.FromSlash(key))
+	if bucket == "" || key == "" || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("invalid lock %s/%s for the local lock backend", bucket, key)
+	}
+	return filepath.Join(l.Dir, rel), nil
+}
+
+// withLock runs fn with the path of the lock file, while holding its file lock.
+func (l LocalLocker) withLock(ctx context.Context, bucket, key string, fn func(path string) error) error {
+	path, err := l.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(ctx, path+".flock")
+	if err != nil {
+		return fmt.Errorf("unable to lock %s: %w", path, err)
+	}
+	defer unlock()
+
+	return fn(path)
+}
+
+// recordLocalForceRelease writes a record of the lock file that is about to be
+// force-released.
+func recordLocalForceRelease(path, bucket, key, reason string, holder t.LockInfo) error {
+	raw, name, err := newForceReleaseRecord(bucket, key, reason, holder)
+	if err != nil {
+		return err
+	}
+
+	dir := path + ".force-releases"
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	recordPath := filepath.Join(dir, name)
+	log.Debugf("Recording force-release to %s", recordPath)
+
+	return os.WriteFile(recordPath, raw, 0o644)
+}
+
+// readLocalLock reads and decodes the lock file, if it exists.
+func readLocalLock(path string) (bool, t.LockInfo, error) {
+	lockInfoRaw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, t.LockInfo{}, nil // Lock does not exist.
+	}
+	if err != nil {
+		return false, t.LockInfo{}, err
+	}
+	log.Debugf("Raw lock info: %s\n", lockInfoRaw)
+
+	lockInfo, err := t.DecodeLockInfo(lockInfoRaw)
+	if err != nil {
+		return false, lockInfo, err
+	}
+	return true, lockInfo, nil
+}
+
+// nextLocalFencingToken increments the fencing token counter in the fence file,
+// and records the new token as the one the lock is acquired with. It is only
+// called once nothing keeps the lock from being acquired, under the file lock.
+func nextLocalFencingToken(path string) (int64, error) {
+	var token int64
+	err := updateLocalFence(path, func(fence *t.Fence) error {
+		fence.Token++
+		fence.Acquired = fence.Token
+		token = fence.Token
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get a fencing token: %w", err)
+	}
+	log.Debugf("Fencing token of the lock: %d", token)
+	return token, nil
+}
+
+// updateLocalFence applies update to the fence file, under the file lock.
+func updateLocalFence(path string, update func(fence *t.Fence) error) error {
+	fence, err := readLocalFence(path)
+	if err != nil {
+		return err
+	}
+	if err := update(&fence); err != nil {
+		return err
+	}
+	fence.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	return writeJSONFile(path, fence)
+}
+
+// readLocalFence reads the fence file. A missing fence is returned empty.
+func readLocalFence(path string) (t.Fence, error) {
+	fence := t.Fence{}
+	fenceRaw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fence, nil
+	}
+	if err != nil {
+		return fence, err
+	}
+	if err := json.Unmarshal(fenceRaw, &fence); err != nil {
+		return t.Fence{}, fmt.Errorf("failed to decode fence %s: %w", path, err)
+	}
+	return fence, nil
+}
+
+// writeJSONFile replaces the file with the JSON of v, through a temporary file
+// renamed over it, so readers never see a partial write.
+func writeJSONFile(path string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,48 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// staleLockFile is the age after which the lock file of a process that died
+// while holding it is removed. The file is only held while a lock is read and
+// written.
+const staleLockFile = 30 * time.Second
+
+// lockFile takes the file lock by creating the file exclusively, on systems
+// without flock such as Windows, retrying until ctx is done while another
+// process holds it. The lock is released by the returned function.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockFile {
+			log.Warnf("Removing the stale lock file %s", path)
+			os.Remove(path)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(localLockRetry):
+		}
+	}
+
+	return func() {
+		if err := os.Remove(path); err != nil {
+			log.Warnf("Failed to unlock %s: %v", path, err)
+		}
+	}, nil
+}
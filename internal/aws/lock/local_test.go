@@ -0,0 +1,295 @@
+package lock_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"statectl/internal/aws/lock"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalLockerAcquireRelease(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	dir := t.TempDir()
+	locker := lock.LocalLocker{Dir: dir}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	other, _ := test.CreateLockInfo("0987654321")
+
+	// Call the functions under test
+	acquired, err := locker.Acquire(ctx, "test-bucket", "locks/test.lock", lockInfo)
+	if err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+	if acquired.FencingToken != 1 {
+		t.Errorf("expected fencing token 1, got %d", acquired.FencingToken)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test-bucket", "locks", "test.lock")); err != nil {
+		t.Errorf("expected the lock file to be written: %v", err)
+	}
+	if _, err := locker.Acquire(ctx, "test-bucket", "locks/test.lock", lockInfo); !errors.Is(err, lock.ErrLockExists) {
+		t.Errorf("expected ErrLockExists, got: %v", err)
+	}
+	if _, err := locker.Acquire(ctx, "test-bucket", "locks/test.lock", other); !errors.Is(err, lock.ErrLockHeld) {
+		t.Errorf("expected ErrLockHeld, got: %v", err)
+	}
+
+	exist, holder, err := locker.Status(ctx, "test-bucket", "locks/test.lock")
+	if err != nil || !exist || holder.LockID != lockInfo.LockID {
+		t.Errorf("expected the lock to be held by %s, got %+v (%v)", lockInfo.LockID, holder, err)
+	}
+
+	if err := locker.Release(ctx, "test-bucket", "locks/test.lock", other.OwnerToken); !errors.Is(err, lock.ErrNotOwner) {
+		t.Errorf("expected ErrNotOwner, got: %v", err)
+	}
+	if err := locker.Release(ctx, "test-bucket", "locks/test.lock", lockInfo.OwnerToken); err != nil {
+		t.Fatalf("error releasing lock: %v", err)
+	}
+
+	// Assertions
+	if exist, _, _ := locker.Status(ctx, "test-bucket", "locks/test.lock"); exist {
+		t.Errorf("expected the lock to be released")
+	}
+	acquired, err = locker.Acquire(ctx, "test-bucket", "locks/test.lock", other)
+	if err != nil || acquired.FencingToken != 2 {
+		t.Errorf("expected the lock to be acquired with fencing token 2, got %d (%v)", acquired.FencingToken, err)
+	}
+}
+
+func TestLocalLockerTakeoverExpired(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	locker := lock.LocalLocker{Dir: t.TempDir()}
+
+	expired, _ := test.CreateLockInfo("0987654321")
+	expired = expired.WithLease(time.Now().Add(-2*time.Hour), time.Hour)
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", expired); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// Call the function under test
+	acquired, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo)
+
+	// Assertions
+	if err != nil {
+		t.Fatalf("error taking over the expired lock: %v", err)
+	}
+	if acquired.Takeover != st.TakeoverExpired || acquired.PreviousOwner == nil || acquired.PreviousOwner.LockID != expired.LockID {
+		t.Errorf("expected a takeover of %s, got %+v", expired.LockID, acquired)
+	}
+}
+
+func TestLocalLockerRenewForceRelease(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	locker := lock.LocalLocker{Dir: t.TempDir()}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	lockInfo = lockInfo.WithLease(time.Now(), time.Minute)
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+
+	// Call the functions under test
+	renewed, err := locker.Renew(ctx, "test-bucket", "test-key", lockInfo.OwnerToken, time.Hour)
+	if err != nil {
+		t.Fatalf("error renewing lock: %v", err)
+	}
+	if remaining, _ := renewed.Remaining(time.Now()); remaining < 59*time.Minute {
+		t.Errorf("expected the lease to be extended by an hour, %s remaining", remaining)
+	}
+	if _, err := locker.Renew(ctx, "test-bucket", "test-key", "other-token", 0); !errors.Is(err, lock.ErrLockLost) {
+		t.Errorf("expected ErrLockLost, got: %v", err)
+	}
+
+	if err := locker.ForceRelease(ctx, "test-bucket", "test-key", "stuck pipeline"); err != nil {
+		t.Fatalf("error force-releasing lock: %v", err)
+	}
+
+	// Assertions
+	if exist, _, _ := locker.Status(ctx, "test-bucket", "test-key"); exist {
+		t.Errorf("expected the lock to be force-released")
+	}
+}
+
+func TestLocalLockerForceReleaseRecord(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	dir := t.TempDir()
+	locker := lock.LocalLocker{Dir: dir}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+
+	// Call the function under test
+	if err := locker.ForceRelease(ctx, "test-bucket", "test-key", "stuck pipeline"); err != nil {
+		t.Fatalf("error force-releasing lock: %v", err)
+	}
+
+	// Assertions
+	records, _ := filepath.Glob(filepath.Join(dir, "test-bucket", "test-key.force-releases", "*.json"))
+	if len(records) != 1 {
+		t.Fatalf("expected one force-release record, got %v", records)
+	}
+	raw, err := os.ReadFile(records[0])
+	if err != nil {
+		t.Fatalf("error reading the force-release record: %v", err)
+	}
+	var record st.ForceReleaseRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		t.Fatalf("error decoding the force-release record: %v", err)
+	}
+	if record.Reason != "stuck pipeline" || record.Actor == "" || record.Lock == nil || record.Lock.LockID != lockInfo.LockID || record.Lock.OwnerToken != "" {
+		t.Errorf("expected the reason, the actor and the removed lock without its owner token, got %+v", record)
+	}
+}
+
+func TestLocalLockerForceReleaseRecordFails(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	dir := t.TempDir()
+	locker := lock.LocalLocker{Dir: dir}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+	// A file in place of the directory of the records keeps them from being written
+	if err := os.WriteFile(filepath.Join(dir, "test-bucket", "test-key.force-releases"), nil, 0o644); err != nil {
+		t.Fatalf("error blocking the force-release records: %v", err)
+	}
+
+	// Call the function under test
+	err := locker.ForceRelease(ctx, "test-bucket", "test-key", "stuck pipeline")
+
+	// Assertions
+	if err == nil {
+		t.Fatalf("expected the force-release to fail without its record")
+	}
+	if exist, _, _ := locker.Status(ctx, "test-bucket", "test-key"); !exist {
+		t.Errorf("expected the lock to be kept when the record cannot be written")
+	}
+}
+
+func TestLocalLockerForceReleaseCorruptLock(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	dir := t.TempDir()
+	locker := lock.LocalLocker{Dir: dir}
+	if err := os.MkdirAll(filepath.Join(dir, "test-bucket"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test-bucket", "test-key"), []byte("{not json"), 0o644); err != nil {
+		t.Fatalf("error writing the corrupt lock: %v", err)
+	}
+
+	// Call the function under test
+	if err := locker.ForceRelease(ctx, "test-bucket", "test-key", "corrupt lock"); err != nil {
+		t.Fatalf("expected the corrupt lock to be force-released, got: %v", err)
+	}
+
+	// Assertions
+	if _, err := os.Stat(filepath.Join(dir, "test-bucket", "test-key")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the corrupt lock to be removed, got: %v", err)
+	}
+	records, _ := filepath.Glob(filepath.Join(dir, "test-bucket", "test-key.force-releases", "*.json"))
+	if len(records) != 1 {
+		t.Errorf("expected one force-release record, got %v", records)
+	}
+}
+
+func TestLocalLockerConcurrentAcquire(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	locker := lock.LocalLocker{Dir: t.TempDir()}
+
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+
+	// Call the function under test
+	for i := 0; i < 10; i++ {
+		lockInfo, _ := test.CreateLockInfo(fmt.Sprintf("commit-%d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	// Assertions
+	acquired := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			acquired++
+		case !errors.Is(err, lock.ErrLockHeld):
+			t.Errorf("expected ErrLockHeld, got: %v", err)
+		}
+	}
+	if acquired != 1 {
+		t.Errorf("expected exactly one process to acquire the lock, got %d", acquired)
+	}
+}
+
+func TestLocalLockerRejectsKeysOutsideDir(t *testing.T) {
+	// Set up
+	locker := lock.LocalLocker{Dir: t.TempDir()}
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// Call the function under test
+	_, err := locker.Acquire(context.Background(), "test-bucket", "../../escape.lock", lockInfo)
+
+	// Assertions
+	if err == nil {
+		t.Errorf("expected the key to be refused")
+	}
+}
+
+func TestNewLocker(t *testing.T) {
+	fakeDynamoDB := test.NewFakeDynamoDBClient()
+
+	tests := []struct {
+		name     string
+		config   lock.BackendConfig
+		dynamoDB st.DynamoDBClient
+		want     lock.Locker
+		wantErr  bool
+	}{
+		{name: "default", config: lock.BackendConfig{}, want: lock.S3Locker{}},
+		{name: "local", config: lock.BackendConfig{Backend: "local", LocalDir: "/tmp/locks"}, want: lock.LocalLocker{Dir: "/tmp/locks"}},
+		{name: "dynamodb", config: lock.BackendConfig{Backend: "dynamodb", DynamoDBTable: "locks"}, dynamoDB: fakeDynamoDB, want: lock.DynamoDBLocker{Client: fakeDynamoDB, Table: "locks"}},
+		{name: "dynamodb without table", config: lock.BackendConfig{Backend: "dynamodb"}, dynamoDB: fakeDynamoDB, wantErr: true},
+		{name: "dynamodb without client", config: lock.BackendConfig{Backend: "dynamodb", DynamoDBTable: "locks"}, wantErr: true},
+		{name: "unknown", config: lock.BackendConfig{Backend: "consul"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Call the function under test
+			got, err := lock.NewLocker(tt.config, nil, tt.dynamoDB)
+
+			// Assertions
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %v, got: %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("expected %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	t "statectl/internal/utils/types"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Lock backends, selected with LOCK_BACKEND
+const (
+	BackendS3       = "s3"
+	BackendDynamoDB = "dynamodb"
+	BackendLocal    = "local"
+)
+
+// Backends lists the lock backends that can be selected.
+var Backends = []string{BackendS3, BackendDynamoDB, BackendLocal}
+
+// Locker runs the basic operations on the lock of the state at bucket/key, so
+// the commands do not depend on where the lock is kept.
+type Locker interface {
+	// Acquire takes the lock, or takes over an expired one. It fails with
+	// ErrLockExists if the lock is already held with the same owner token,
+	// and with ErrLockHeld if it is held by someone else.
+	Acquire(ctx context.Context, bucket, key string, lockInfo t.LockInfo) (t.LockInfo, error)
+	// Release removes the lock if it is held with the owner token.
+	Release(ctx context.Context, bucket, key, token string) error
+	// ForceRelease removes the lock whoever holds it.
+	ForceRelease(ctx context.Context, bucket, key, reason string) error
+	// Status reports whether the state is locked, and by whom.
+	Status(ctx context.Context, bucket, key string) (bool, t.LockInfo, error)
+	// Renew extends the lease of the lock held with the owner token by ttl.
+	Renew(ctx context.Context, bucket, key, token string, ttl time.Duration) (t.LockInfo, error)
+	// CommitFencingToken records that the state is written with the fencing
+	// token, in the fence the backend hands out the tokens from. It fails with
+	// ErrStaleFencingToken if the lock was acquired since with a newer token,
+	// or a newer holder already committed a write.
+	CommitFencingToken(ctx context.Context, bucket, key string, token int64) error
+	// VerifyFencingToken fails as CommitFencingToken does, without recording the token.
+	VerifyFencingToken(ctx context.Context, bucket, key string, token int64) error
+}
+
+var (
+	_ Locker = S3Locker{}
+	_ Locker = DynamoDBLocker{}
+	_ Locker = LocalLocker{}
+)
+
+// S3Locker keeps the lock as a file in the S3 bucket, next to the state. It is
+// the only backend that supports shared locks, waiting and the lock queue.
+type S3Locker struct {
+	Client t.S3Client
+}
+
+func (l S3Locker) Acquire(ctx context.Context, bucket, key string, lockInfo t.LockInfo) (t.LockInfo, error) {
+	return AcquireStateLock(ctx, l.Client, bucket, key, lockInfo)
+}
+
+func (l S3Locker) Release(ctx context.Context, bucket, key, token string) error {
+	return ReleaseStateLock(ctx, l.Client, bucket, key, token)
+}
+
+func (l S3Locker) ForceRelease(ctx context.Context, bucket, key, reason string) error {
+	return ForceReleaseLock(ctx, l.Client, bucket, key, reason)
+}
+
+func (l S3Locker) Status(ctx context.Context, bucket, key string) (bool, t.LockInfo, error) {
+	return CheckStateLock(ctx, l.Client, bucket, key, true)
+}
+
+func (l S3Locker) Renew(ctx context.Context, bucket, key, token string, ttl time.Duration) (t.LockInfo, error) {
+	return RenewStateLock(ctx, l.Client, bucket, key, token, ttl)
+}
+
+func (l S3Locker) CommitFencingToken(ctx context.Context, bucket, key string, token int64) error {
+	return CommitFencingToken(ctx, l.Client, bucket, key, token)
+}
+
+func (l S3Locker) VerifyFencingToken(ctx context.Context, bucket, key string, token int64) error {
+	return VerifyFencingToken(ctx, l.Client, bucket, key, token)
+}
+
+// BackendConfig selects the lock backend and where it keeps the locks.
+type BackendConfig struct {
+	Backend          string
+	DynamoDBTable    string
+	DynamoDBEndpoint string
+	LocalDir         string
+}
+
+// ConfiguredBackend reads the lock backend from the LOCK_BACKEND,
+// LOCK_DYNAMODB_TABLE, LOCK_DYNAMODB_ENDPOINT and LOCK_LOCAL_DIR settings.
+func ConfiguredBackend() BackendConfig {
+	return BackendConfig{
+		Backend:          viper.GetString("LOCK_BACKEND"),
+		DynamoDBTable:    viper.GetString("LOCK_DYNAMODB_TABLE"),
+		DynamoDBEndpoint: viper.GetString("LOCK_DYNAMODB_ENDPOINT"),
+		LocalDir:         viper.GetString("LOCK_LOCAL_DIR"),
+	}
+}
+
+// Name returns the selected backend, the S3 one by default.
+func (c BackendConfig) Name() string {
+	if c.Backend == "" {
+		return BackendS3
+	}
+	return c.Backend
+}
+
+// Validate fails if the backend is unknown or misses its settings.
+func (c BackendConfig) Validate() error {
+	switch c.Name() {
+	case BackendS3, BackendLocal:
+		return nil
+	case BackendDynamoDB:
+		if c.DynamoDBTable == "" {
+			return errors.New("no DynamoDB table set, use --dynamodb-table or LOCK_DYNAMODB_TABLE")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown lock backend %q, must be one of: %v", c.Backend, Backends)
+	}
+}
+
+// NewLocker returns the locker of the configured backend. The S3 client is
+// used by the S3 backend, and the DynamoDB client, which may be nil with the
+// other backends, by the DynamoDB one.
+func NewLocker(c BackendConfig, s3Client t.S3Client, dynamoDBClient t.DynamoDBClient) (Locker, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch c.Name() {
+	case BackendDynamoDB:
+		if dynamoDBClient == nil {
+			return nil, errors.New("no DynamoDB client for the dynamodb lock backend")
+		}
+		return DynamoDBLocker{Client: dynamoDBClient, Table: c.DynamoDBTable}, nil
+	case BackendLocal:
+		dir := c.LocalDir
+		if dir == "" {
+			dir = DefaultLocalDir()
+		}
+		return LocalLocker{Dir: dir}, nil
+	default:
+		return S3Locker{Client: s3Client}, nil
+	}
+}
+
+// EventLog returns the log the lock events of the backend are kept in, under
+// prefix, and whether the backend keeps one. Only the S3 backend does, in the
+// bucket next to its locks; the other backends do not need S3 credentials.
+func (c BackendConfig) EventLog(s3Client t.S3Client, prefix string) (EventLog, bool) {
+	if c.Name() != BackendS3 {
+		return EventLog{}, false
+	}
+	return EventLog{Client: s3Client, Prefix: prefix}, true
+}
+
+// VerifyLock checks that the lock is held with the owner token and that its
+// lease has not expired. The current lock is returned if it exists.
+func VerifyLock(ctx context.Context, l Locker, bucket, key, token string) (t.LockInfo, error) {
+	exist, remote, err := l.Status(ctx, bucket, key)
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+	if !exist {
+		return t.LockInfo{}, ErrNotLocked
+	}
+	if remote.OwnerToken == "" || remote.OwnerToken != token {
+		return remote, ErrNotOwner
+	}
+	if remote.Expired(time.Now()) {
+		return remote, fmt.Errorf("%w: the lease expired at %s", ErrLockLost, remote.ExpiresAt)
+	}
+	return remote, nil
+}
@@ -0,0 +1,84 @@
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"statectl/internal/aws/lock"
+	"statectl/test"
+	"testing"
+	"time"
+)
+
+func TestLockerFencingTokens(t *testing.T) {
+	lockers := map[string]func(t *testing.T) lock.Locker{
+		"s3": func(t *testing.T) lock.Locker { return lock.S3Locker{Client: test.NewFakeS3Client()} },
+		"dynamodb": func(t *testing.T) lock.Locker {
+			return lock.DynamoDBLocker{Client: test.NewFakeDynamoDBClient(), Table: "locks"}
+		},
+		"local": func(t *testing.T) lock.Locker { return lock.LocalLocker{Dir: t.TempDir()} },
+	}
+
+	for name, newLocker := range lockers {
+		t.Run(name, func(t *testing.T) {
+			// Set up
+			ctx := context.Background()
+			locker := newLocker(t)
+
+			expiredInfo, _ := test.CreateLockInfo("expired")
+			expired, err := locker.Acquire(ctx, "test-bucket", "test-key", expiredInfo.WithLease(time.Now().Add(-time.Hour), time.Minute))
+			if err != nil {
+				t.Fatalf("error acquiring lock: %v", err)
+			}
+			holderInfo, _ := test.CreateLockInfo("1234567890")
+			holder, err := locker.Acquire(ctx, "test-bucket", "test-key", holderInfo)
+			if err != nil {
+				t.Fatalf("error taking over the expired lock: %v", err)
+			}
+			waiterInfo, _ := test.CreateLockInfo("waiter")
+			if _, err := locker.Acquire(ctx, "test-bucket", "test-key", waiterInfo); !errors.Is(err, lock.ErrLockHeld) {
+				t.Fatalf("expected ErrLockHeld, got: %v", err)
+			}
+
+			// Call the functions under test and assert
+			if err := locker.VerifyFencingToken(ctx, "test-bucket", "test-key", expired.FencingToken); !errors.Is(err, lock.ErrStaleFencingToken) {
+				t.Errorf("expected the token of the expired holder to be stale, got: %v", err)
+			}
+			if err := locker.CommitFencingToken(ctx, "test-bucket", "test-key", expired.FencingToken); !errors.Is(err, lock.ErrStaleFencingToken) {
+				t.Errorf("expected the token of the expired holder to be refused, got: %v", err)
+			}
+			if err := locker.CommitFencingToken(ctx, "test-bucket", "test-key", holder.FencingToken); err != nil {
+				t.Errorf("expected the token of the holder to be committed, got: %v", err)
+			}
+			if err := locker.VerifyFencingToken(ctx, "test-bucket", "test-key", holder.FencingToken); err != nil {
+				t.Errorf("expected the token of the holder to be current, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestBackendConfigEventLog(t *testing.T) {
+	tests := []struct {
+		backend string
+		want    bool
+	}{
+		{backend: "", want: true},
+		{backend: lock.BackendS3, want: true},
+		{backend: lock.BackendDynamoDB, want: false},
+		{backend: lock.BackendLocal, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			// Call the function under test
+			eventLog, ok := lock.BackendConfig{Backend: tt.backend}.EventLog(test.NewFakeS3Client(), "audit")
+
+			// Assertions
+			if ok != tt.want {
+				t.Errorf("expected an event log %t, got %t", tt.want, ok)
+			}
+			if ok && eventLog.Prefix != "audit" {
+				t.Errorf("expected the prefix of the event log, got %q", eventLog.Prefix)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	t "statectl/internal/utils/types"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DefaultKeyTemplate maps lock names to keys when LOCK_KEY_TEMPLATE is not set.
+const DefaultKeyTemplate = "locks/{name}.lock"
+
+// namePlaceholder is replaced by the lock name in a key template.
+const namePlaceholder = "{name}"
+
+var ErrInvalidLockName = errors.New("lock names may only contain letters, digits, '.', '_' and '-'")
+
+var lockNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// KeyFromTemplate returns the key of the named lock, e.g. "locks/prod.lock" for
+// the name "prod" and the template "locks/{name}.lock".
+func KeyFromTemplate(template, name string) (string, error) {
+	if err := validateTemplate(template); err != nil {
+		return "", err
+	}
+	if !lockNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidLockName, name)
+	}
+	return strings.ReplaceAll(template, namePlaceholder, name), nil
+}
+
+// TemplatePrefix returns the part of the key template before the lock name,
+// which every named lock is stored under.
+func TemplatePrefix(template string) (string, error) {
+	if err := validateTemplate(template); err != nil {
+		return "", err
+	}
+	return template[:strings.Index(template, namePlaceholder)], nil
+}
+
+func validateTemplate(template string) error {
+	if strings.Count(template, namePlaceholder) != 1 {
+		return fmt.Errorf("lock key template %q must contain %s exactly once", template, namePlaceholder)
+	}
+	return nil
+}
+
+// ListLocks returns the named locks of the key template stored under prefix,
+// sorted by key. The fences, events, shared locks, queue and force-release
+// records stored next to the locks are skipped, even with a template whose
+// name is not followed by a suffix. An empty prefix lists every lock of the
+// template.
+func ListLocks(ctx context.Context, cli t.S3Client, bucket, template, prefix string) ([]t.NamedLock, error) {
+	if err := validateTemplate(template); err != nil {
+		return nil, err
+	}
+	i := strings.Index(template, namePlaceholder)
+	templatePrefix, templateSuffix := template[:i], template[i+len(namePlaceholder):]
+	if prefix == "" {
+		prefix = templatePrefix
+	}
+
+	locks := []t.NamedLock{}
+	paginator := s3.NewListObjectsV2Paginator(cli, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if !strings.HasPrefix(key, templatePrefix) || !strings.HasSuffix(key, templateSuffix) || isSidecarKey(key) {
+				continue
+			}
+			name := strings.TrimSuffix(strings.TrimPrefix(key, templatePrefix), templateSuffix)
+			if !lockNamePattern.MatchString(name) {
+				continue
+			}
+
+			// The lock may have been released since it was listed
+			exist, lockInfo, _, err := fetchStateLock(ctx, cli, bucket, key)
+			if err != nil {
+				log.Warnf("Skipping unreadable lock %s: %v", key, err)
+				continue
+			}
+			if exist {
+				// The owner token lets anyone release the lock, keep it out of listings
+				lockInfo.OwnerToken = ""
+				locks = append(locks, t.NamedLock{Name: name, Key: key, Lock: lockInfo})
+			}
+		}
+	}
+	return locks, nil
+}
+
+// isSidecarKey reports whether key is one of the objects stored next to a lock:
+// one of its fences, or an object under its events, shared locks, queue or
+// force-release records.
+func isSidecarKey(key string) bool {
+	for _, suffix := range []string{DefaultFenceKey(""), queueFenceKey("")} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	for _, segment := range []string{DefaultEventPrefix(""), DefaultSharedPrefix(""), DefaultQueuePrefix(""), DefaultForceReleasePrefix("")} {
+		if strings.Contains(key, segment) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package lock_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"statectl/internal/aws/lock"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestKeyFromTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		name     string
+		expected string
+		wantErr  bool
+	}{
+		{lock.DefaultKeyTemplate, "prod", "locks/prod.lock", false},
+		{"envs/{name}/terraform.lock", "eu-west-1.prod", "envs/eu-west-1.prod/terraform.lock", false},
+		{lock.DefaultKeyTemplate, "../prod", "", true},
+		{lock.DefaultKeyTemplate, "", "", true},
+		{"locks/state.lock", "prod", "", true},
+		{"locks/{name}/{name}.lock", "prod", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template+"/"+tt.name, func(t *testing.T) {
+			key, err := lock.KeyFromTemplate(tt.template, tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if key != tt.expected {
+				t.Errorf("expected key %q, got %q", tt.expected, key)
+			}
+		})
+	}
+
+	if _, err := lock.KeyFromTemplate(lock.DefaultKeyTemplate, "a/b"); !errors.Is(err, lock.ErrInvalidLockName) {
+		t.Errorf("expected ErrInvalidLockName, got %v", err)
+	}
+}
+
+func TestListLocks(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	stored := map[string]st.LockInfo{
+		"locks/prod.lock":    {LockID: "1", Signer: "alice", OwnerToken: "secret"},
+		"locks/staging.lock": {LockID: "2", Signer: "bob"},
+	}
+	objects := []types.Object{}
+	for _, objectKey := range []string{
+		"locks/prod.lock",
+		"locks/prod.lock.events/20240501T100000.000000000Z_acquire_1.json",
+		"locks/prod.lock.fence",
+		"locks/released.lock",
+		"locks/staging.lock",
+		"locks/team/nested.lock",
+	} {
+		objects = append(objects, types.Object{Key: aws.String(objectKey)})
+	}
+
+	// Mock ListObjectsV2
+	mockS3.On("ListObjectsV2", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.ListObjectsV2Input) bool {
+		return aws.ToString(input.Prefix) == "locks/"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.ListObjectsV2Output{Contents: objects}, nil,
+	)
+	// Mock GetObject, the released lock is gone by the time it is read
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		return aws.ToString(input.Key) == "locks/released.lock"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{}, &types.NoSuchKey{},
+	)
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			raw, _ := json.Marshal(stored[aws.ToString(input.Key)])
+			return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(raw))}
+		}, nil,
+	)
+
+	// Call the function under test
+	locks, err := lock.ListLocks(ctx, mockS3, "test-bucket", lock.DefaultKeyTemplate, "")
+	if err != nil {
+		t.Fatalf("error listing locks: %v", err)
+	}
+
+	// Assertions
+	if len(locks) != 2 {
+		t.Fatalf("expected 2 locks, got %d: %+v", len(locks), locks)
+	}
+	if locks[0].Name != "prod" || locks[0].Key != "locks/prod.lock" || locks[0].Lock.Signer != "alice" {
+		t.Errorf("unexpected lock: %+v", locks[0])
+	}
+	if locks[0].Lock.OwnerToken != "" {
+		t.Errorf("expected the owner token to be left out of the listing")
+	}
+	if locks[1].Name != "staging" || locks[1].Lock.LockID != "2" {
+		t.Errorf("unexpected lock: %+v", locks[1])
+	}
+}
+
+func TestListLocksTemplateWithoutSuffix(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	defer lock.AddRecorder(lock.EventLog{Client: fakeS3})()
+
+	prod, _ := test.CreateLockInfo("prod")
+	if _, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "locks/prod", prod); err != nil {
+		t.Fatalf("error acquiring the lock: %v", err)
+	}
+	reader, _ := test.CreateLockInfo("reader")
+	if _, err := lock.AcquireSharedLock(ctx, fakeS3, "test-bucket", "locks/staging", reader); err != nil {
+		t.Fatalf("error acquiring the shared lock: %v", err)
+	}
+	stuck, _ := test.CreateLockInfo("stuck")
+	if _, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "locks/dev", stuck); err != nil {
+		t.Fatalf("error acquiring the lock: %v", err)
+	}
+	if err := lock.ForceReleaseLock(ctx, fakeS3, "test-bucket", "locks/dev", "stuck pipeline"); err != nil {
+		t.Fatalf("error force-releasing the lock: %v", err)
+	}
+
+	// Call the function under test
+	locks, err := lock.ListLocks(ctx, fakeS3, "test-bucket", "locks/{name}", "")
+
+	// Assertions
+	if err != nil {
+		t.Fatalf("error listing locks: %v", err)
+	}
+	if len(locks) != 1 || locks[0].Name != "prod" || locks[0].Lock.LockID != "prod" {
+		t.Errorf("expected only the prod lock, got %+v", locks)
+	}
+}
@@ -0,0 +1,258 @@
+package lock
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	t "statectl/internal/utils/types"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DefaultTicketTTL is how long a ticket stays in the queue without being renewed
+// by its waiter, after which it is skipped as abandoned.
+const DefaultTicketTTL = 3 * DefaultMaxPollInterval
+
+var ErrTicketLost = errors.New("ticket is no longer in the lock queue")
+
+// DefaultQueuePrefix returns the prefix the tickets of the waiters for the lock
+// at key are stored under, one object per ticket.
+func DefaultQueuePrefix(key string) string {
+	return key + ".queue/"
+}
+
+// queueFenceKey returns the key of the fence object that hands out the ticket numbers.
+func queueFenceKey(key string) string {
+	return key + ".queue.fence"
+}
+
+// ticketKey returns the key of the ticket with the given number. Numbers are
+// zero-padded, so the tickets are listed in the order they are served.
+func ticketKey(key string, number int64) string {
+	return fmt.Sprintf("%s%020d.json", DefaultQueuePrefix(key), number)
+}
+
+// queuedTicket is a ticket together with the key and ETag of its object.
+type queuedTicket struct {
+	key    string
+	etag   string
+	ticket t.Ticket
+}
+
+// ListQueue returns the tickets in the queue of the lock at key in the order
+// they are served, including the abandoned ones. Their owner tokens are left out.
+func ListQueue(ctx context.Context, cli t.S3Client, bucket, key string) ([]t.Ticket, error) {
+	queued, err := listTickets(ctx, cli, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	tickets := make([]t.Ticket, 0, len(queued))
+	for _, q := range queued {
+		q.ticket.OwnerToken = ""
+		tickets = append(tickets, q.ticket)
+	}
+	return tickets, nil
+}
+
+// waitInQueue acquires the lock in the order the waiters joined its queue. The
+// waiter takes a ticket, and only tries to acquire the lock once no live ticket
+// is ahead of it. The ticket is renewed on every attempt, so the tickets of
+// waiters that went away expire and are skipped. The ticket is removed when
+// the wait ends, whether the lock was acquired or not.
+func waitInQueue(ctx context.Context, cli t.S3Client, bucket, key string, lockInfo t.LockInfo, opts WaitOptions) (t.LockInfo, error) {
+	mine, err := enqueue(ctx, cli, bucket, key, lockInfo, opts.TicketTTL)
+	if err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to join the lock queue: %w", err)
+	}
+	log.Infof("Joined the lock queue with ticket %d", mine.ticket.Number)
+	defer func() { leaveQueue(cli, bucket, mine) }()
+
+	ttl := lockInfo.Lease()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if mine, err = renewTicket(ctx, cli, bucket, mine, opts.TicketTTL); err != nil {
+				return t.LockInfo{}, waitError(ctx, err)
+			}
+		}
+
+		ahead, err := ticketsAhead(ctx, cli, bucket, key, mine.ticket.Number)
+		if err != nil {
+			return t.LockInfo{}, waitError(ctx, err)
+		}
+
+		if ahead == 0 {
+			acquired, err := acquireOnce(ctx, cli, bucket, key, lockInfo, ttl, opts.Shared)
+			if err == nil || errors.Is(err, ErrLockExists) {
+				return acquired, err
+			}
+			if !isLockBusy(err) {
+				return t.LockInfo{}, waitError(ctx, err)
+			}
+		}
+
+		delay := Backoff(attempt, opts.PollInterval, opts.MaxPollInterval)
+		if ahead == 0 {
+			logHolder(ctx, cli, bucket, key, delay)
+		} else {
+			log.Infof("%d waiter(s) ahead in the lock queue, retrying in %s", ahead, delay.Round(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			return t.LockInfo{}, fmt.Errorf("unable to acquire lock: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// enqueue takes the next ticket in the queue of the lock at key.
+func enqueue(ctx context.Context, cli t.S3Client, bucket, key string, lockInfo t.LockInfo, ttl time.Duration) (queuedTicket, error) {
+	var number int64
+	err := updateFence(ctx, cli, bucket, queueFenceKey(key), func(fence *t.Fence) error {
+		fence.Token++
+		number = fence.Token
+		return nil
+	})
+	if err != nil {
+		return queuedTicket{}, err
+	}
+
+	now := time.Now().UTC()
+	q := queuedTicket{
+		key: ticketKey(key, number),
+		ticket: t.Ticket{
+			Number:     number,
+			LockID:     lockInfo.LockID,
+			Signer:     lockInfo.Signer,
+			OwnerToken: lockInfo.OwnerToken,
+			Mode:       lockInfo.Mode,
+			CreatedAt:  now.Format(time.RFC3339Nano),
+			ExpiresAt:  now.Add(ttl).Format(time.RFC3339Nano),
+		},
+	}
+	return putTicket(ctx, cli, bucket, q)
+}
+
+// renewTicket extends the lease of the ticket by ttl. ErrTicketLost is returned
+// if the ticket was removed as abandoned in the meantime.
+func renewTicket(ctx context.Context, cli t.S3Client, bucket string, q queuedTicket, ttl time.Duration) (queuedTicket, error) {
+	q.ticket.ExpiresAt = time.Now().UTC().Add(ttl).Format(time.RFC3339Nano)
+
+	renewed, err := putTicket(ctx, cli, bucket, q)
+	if isPreconditionFailed(err) {
+		return q, fmt.Errorf("unable to renew ticket %d: %w: it expired and was skipped by the other waiters, the ticket TTL may be too short", q.ticket.Number, ErrTicketLost)
+	}
+	return renewed, err
+}
+
+// putTicket writes the ticket if it still has its ETag, or if it does not exist
+// yet when it has none, and returns it with its new ETag.
+func putTicket(ctx context.Context, cli t.S3Client, bucket string, q queuedTicket) (queuedTicket, error) {
+	raw, err := json.Marshal(q.ticket)
+	if err != nil {
+		return q, err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(q.key),
+		Body:   strings.NewReader(string(raw)),
+	}
+	if q.etag != "" {
+		input.IfMatch = aws.String(q.etag)
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	resp, err := cli.PutObject(ctx, input)
+	if err != nil {
+		return q, err
+	}
+	q.etag = aws.ToString(resp.ETag)
+	return q, nil
+}
+
+// leaveQueue removes the ticket, even when the wait was cancelled. Failures are
+// only logged, as the ticket expires anyway.
+func leaveQueue(cli t.S3Client, bucket string, q queuedTicket) {
+	if err := deleteStateLock(context.Background(), cli, bucket, q.key, q.etag); err != nil {
+		log.Warnf("Failed to leave the lock queue, ticket %d will expire: %v", q.ticket.Number, err)
+		return
+	}
+	log.Debugf("Left the lock queue with ticket %d", q.ticket.Number)
+}
+
+// ticketsAhead returns the number of live tickets ahead of the given one. The
+// expired tickets are removed on the way, as their waiters are gone.
+func ticketsAhead(ctx context.Context, cli t.S3Client, bucket, key string, number int64) (int, error) {
+	queued, err := listTickets(ctx, cli, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	ahead := 0
+	for _, q := range queued {
+		if q.ticket.Number >= number {
+			break
+		}
+		if !q.ticket.Expired(now) {
+			ahead++
+			continue
+		}
+		log.Infof("Skipping the abandoned ticket %d of %s (signer: %s)", q.ticket.Number, q.ticket.LockID, q.ticket.Signer)
+		if err := deleteStateLock(ctx, cli, bucket, q.key, q.etag); err != nil {
+			log.Debugf("Failed to remove the abandoned ticket %d: %v", q.ticket.Number, err)
+		}
+	}
+	return ahead, nil
+}
+
+// listTickets reads the tickets in the queue of the lock at key, sorted by number.
+func listTickets(ctx context.Context, cli t.S3Client, bucket, key string) ([]queuedTicket, error) {
+	queued := []queuedTicket{}
+	paginator := s3.NewListObjectsV2Paginator(cli, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(DefaultQueuePrefix(key)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			ticketKey := aws.ToString(object.Key)
+			if !strings.HasSuffix(ticketKey, ".json") {
+				continue
+			}
+
+			// The ticket may have been removed since it was listed
+			exist, raw, etag, err := getStateLock(ctx, cli, bucket, ticketKey)
+			if err != nil {
+				return nil, err
+			}
+			if !exist {
+				continue
+			}
+
+			ticket := t.Ticket{}
+			if err := json.Unmarshal(raw, &ticket); err != nil {
+				log.Warnf("Skipping the unreadable ticket %s: %v", ticketKey, err)
+				continue
+			}
+			queued = append(queued, queuedTicket{key: ticketKey, etag: etag, ticket: ticket})
+		}
+	}
+
+	slices.SortFunc(queued, func(a, b queuedTicket) int {
+		return cmp.Compare(a.ticket.Number, b.ticket.Number)
+	})
+	return queued, nil
+}
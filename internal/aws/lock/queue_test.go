@@ -0,0 +1,130 @@
+package lock_test
+
+import (
+	"context"
+	"encoding/json"
+	"statectl/internal/aws/lock"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var queueOptions = lock.WaitOptions{
+	Timeout:         5 * time.Second,
+	PollInterval:    5 * time.Millisecond,
+	MaxPollInterval: 20 * time.Millisecond,
+	Queue:           true,
+}
+
+func TestWaitAcquireStateLockQueueOrder(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+
+	holder, _ := test.CreateLockInfo("holder")
+	if _, err := lock.AcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", holder); err != nil {
+		t.Fatalf("error acquiring state lock: %v", err)
+	}
+
+	var mu sync.Mutex
+	order := []string{}
+	var wg sync.WaitGroup
+	wait := func(name string) {
+		defer wg.Done()
+		lockInfo, _ := test.CreateLockInfo(name)
+		if _, err := lock.WaitAcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo, queueOptions); err != nil {
+			t.Errorf("%s: error waiting for state lock: %v", name, err)
+			return
+		}
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+		if err := lock.ReleaseStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo.OwnerToken); err != nil {
+			t.Errorf("%s: error releasing state lock: %v", name, err)
+		}
+	}
+
+	// Call the function under test, the waiters join the queue one after the other
+	for i, name := range []string{"first", "second", "third"} {
+		wg.Add(1)
+		go wait(name)
+		waitForTickets(t, fakeS3, i+1)
+	}
+	if err := lock.ReleaseStateLock(ctx, fakeS3, "test-bucket", "test-key", holder.OwnerToken); err != nil {
+		t.Fatalf("error releasing state lock: %v", err)
+	}
+	wg.Wait()
+
+	// Assertions
+	if got := strings.Join(order, ","); got != "first,second,third" {
+		t.Errorf("expected the waiters to acquire the lock in order, got %s", got)
+	}
+	if keys := fakeS3.Keys(lock.DefaultQueuePrefix("test-key")); len(keys) != 0 {
+		t.Errorf("expected the queue to be empty, got %v", keys)
+	}
+}
+
+func TestWaitAcquireStateLockQueueAbandoned(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+
+	// A waiter that went away left its ticket behind
+	putJSON(t, fakeS3, "test-key.queue.fence", st.Fence{Token: 1})
+	putJSON(t, fakeS3, lock.DefaultQueuePrefix("test-key")+"00000000000000000001.json", st.Ticket{
+		Number:    1,
+		LockID:    "gone",
+		ExpiresAt: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano),
+	})
+
+	tickets, err := lock.ListQueue(ctx, fakeS3, "test-bucket", "test-key")
+	if err != nil || len(tickets) != 1 || !tickets[0].Expired(time.Now()) {
+		t.Fatalf("expected one abandoned ticket, got %+v (%v)", tickets, err)
+	}
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// Call the function under test
+	acquired, err := lock.WaitAcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo, queueOptions)
+
+	// Assertions
+	if err != nil {
+		t.Fatalf("error waiting for state lock: %v", err)
+	}
+	if acquired.OwnerToken != lockInfo.OwnerToken {
+		t.Errorf("expected the lock to be acquired, got %+v", acquired)
+	}
+	if keys := fakeS3.Keys(lock.DefaultQueuePrefix("test-key")); len(keys) != 0 {
+		t.Errorf("expected the abandoned ticket to be removed, got %v", keys)
+	}
+}
+
+// waitForTickets waits until the queue of test-key holds n tickets.
+func waitForTickets(t *testing.T, fakeS3 *test.FakeS3Client, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fakeS3.Keys(lock.DefaultQueuePrefix("test-key"))) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d tickets", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func putJSON(t *testing.T, fakeS3 *test.FakeS3Client, key string, v any) {
+	t.Helper()
+	raw, _ := json.Marshal(v)
+	if _, err := fakeS3.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(string(raw)),
+	}); err != nil {
+		t.Fatalf("error writing %s: %v", key, err)
+	}
+}
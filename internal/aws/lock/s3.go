@@ -6,50 +6,206 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"statectl/internal/logging"
-	"statectl/internal/utils/subproc"
 	t "statectl/internal/utils/types"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
 )
 
 // Initialize a global S3 client
 var KeyNotFound *types.NoSuchKey
 var log = logging.GetLogger()
 var ErrLockExists = errors.New("lock exists")
+var ErrLockHeld = errors.New("lock already exists and it's not owned by this process")
+var ErrPreconditionFailed = errors.New("lock precondition failed")
+var ErrLockLost = errors.New("lock is no longer owned by this process")
+var ErrNoOwnerToken = errors.New("lock has no owner token")
+var ErrNotOwner = errors.New("lock is owned by another process")
+var ErrNoReason = errors.New("a reason is required to force the lock")
+var ErrNotLocked = errors.New("state is not locked")
+var ErrUnsupportedSchema = errors.New("lock was written by a newer statectl")
 
-// AcquireStateLock attempts to create or update the state lock file in an S3 bucket.
-func AcquireStateLock(ctx context.Context, cli t.S3Client, bucket, key string, lockInfo t.LockInfo) error {
-	exist, _, err := CheckStateLock(ctx, cli, bucket, key, false)
+// AcquireStateLock atomically creates the state lock file in an S3 bucket.
+// The lock is written with `If-None-Match: *`, so only one of several concurrent
+// callers can create it. The owner token of lockInfo identifies this acquisition,
+// ErrLockExists is only returned if the lock is already held with the same token.
+// The lock is not acquired while readers hold it in shared mode, see AcquireSharedLock.
+//
+// Every acquisition gets a new fencing token, the acquired lock is returned with it.
+// With ErrLockExists, the lock that is already held is returned.
+func AcquireStateLock(ctx context.Context, cli t.S3Client, bucket, key string, lockInfo t.LockInfo) (t.LockInfo, error) {
+	if lockInfo.OwnerToken == "" {
+		return t.LockInfo{}, ErrNoOwnerToken
+	}
+
+	fencingToken, err := NextFencingToken(ctx, cli, bucket, key)
 	if err != nil {
-		return err
+		return t.LockInfo{}, err
+	}
+	lockInfo.FencingToken = fencingToken
+
+	err = putStateLock(ctx, cli, bucket, key, "", lockInfo)
+	if err == nil {
+		if err := checkSharedLocks(ctx, cli, bucket, key); err != nil {
+			retractStateLock(ctx, cli, bucket, key, lockInfo.OwnerToken)
+			return t.LockInfo{}, err
+		}
+		if err := RecordAcquiredToken(ctx, cli, bucket, key, fencingToken); err != nil {
+			retractStateLock(ctx, cli, bucket, key, lockInfo.OwnerToken)
+			return t.LockInfo{}, err
+		}
+		emit(ctx, t.EventAcquire, bucket, key, lockInfo, "")
+		return lockInfo, nil
+	}
+	if !isPreconditionFailed(err) {
+		return t.LockInfo{}, err
+	}
+
+	// The lock already exists, find out whether it is ours
+	exist, remote, etag, err := fetchStateLock(ctx, cli, bucket, key)
+	if err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to acquire lock: lock already exists, and it could not be read: %w", err)
+	}
+	if !exist {
+		return t.LockInfo{}, fmt.Errorf("unable to acquire lock: %w: the lock was released while acquiring it, please retry", ErrPreconditionFailed)
+	}
+	if remote.Expired(time.Now()) {
+		return takeoverStateLock(ctx, cli, bucket, key, etag, lockInfo, remote)
 	}
+	if remote.OwnerToken != lockInfo.OwnerToken {
+		return t.LockInfo{}, fmt.Errorf("unable to acquire lock: %w.\nthis can happen if the lock was created by another process or user.\nplease retry after the lock is released or use the force-acquire command", ErrLockHeld)
+	}
+	return remote, ErrLockExists
+}
 
+// takeoverStateLock replaces an expired lock, as long as it is still the one that was read.
+// The previous holder is kept in the new lock so the takeover can be traced later.
+func takeoverStateLock(ctx context.Context, cli t.S3Client, bucket, key, etag string, lockInfo, expired t.LockInfo) (t.LockInfo, error) {
+	log.Warnf("Lock held by %s (signer: %s) expired at %s, taking it over", expired.LockID, expired.Signer, expired.ExpiresAt)
+
+	expired.PreviousOwner = nil
+	lockInfo.PreviousOwner = &expired
+	lockInfo.Takeover = t.TakeoverExpired
+
+	err := putStateLock(ctx, cli, bucket, key, etag, lockInfo)
+	if isPreconditionFailed(err) {
+		return t.LockInfo{}, fmt.Errorf("unable to acquire lock: %w: the expired lock was reclaimed by another process first", ErrPreconditionFailed)
+	}
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+	if err := checkSharedLocks(ctx, cli, bucket, key); err != nil {
+		retractStateLock(ctx, cli, bucket, key, lockInfo.OwnerToken)
+		return t.LockInfo{}, err
+	}
+	// The writes of the expired holder are refused from now on
+	if err := RecordAcquiredToken(ctx, cli, bucket, key, lockInfo.FencingToken); err != nil {
+		retractStateLock(ctx, cli, bucket, key, lockInfo.OwnerToken)
+		return t.LockInfo{}, err
+	}
+
+	emit(ctx, t.EventTakeover, bucket, key, lockInfo, "")
+	return lockInfo, nil
+}
+
+// ForceAcquireStateLock replaces the state lock file in one step, whoever holds it.
+// The replaced holder is kept in the new lock as previous owner, together with the
+// reason for forcing the lock. The readers that hold the lock in shared mode are
+// forced out the same way, with ForceReleaseSharedLocks, so the new holder never
+// coexists with them. It returns the acquired lock with its new fencing token, and
+// the replaced lock, or nil if the state was not locked.
+func ForceAcquireStateLock(ctx context.Context, cli t.S3Client, bucket, key string, lockInfo t.LockInfo, reason string) (t.LockInfo, *t.LockInfo, error) {
+	if lockInfo.OwnerToken == "" {
+		return t.LockInfo{}, nil, ErrNoOwnerToken
+	}
+	if strings.TrimSpace(reason) == "" {
+		return t.LockInfo{}, nil, ErrNoReason
+	}
+	lockInfo.Reason = reason
+
+	exist, lockInfoRaw, etag, err := getStateLock(ctx, cli, bucket, key)
+	if err != nil {
+		return t.LockInfo{}, nil, err
+	}
+
+	var previous *t.LockInfo
 	if exist {
-		same_sha, err := subproc.CompareSHAs(ctx, cli, bucket, key)
+		// A lock that cannot be decoded is still replaced
+		holder, err := t.DecodeLockInfo(lockInfoRaw)
 		if err != nil {
-			return fmt.Errorf("unable to acquire lock: lock already exists")
-		}
-		if !same_sha {
-			return fmt.Errorf("unable to acquire lock: lock already exists and it's not owned by this process.\nthis can happen if the lock was created by another process or user.\nplease retry after the lock is released or use the force-acquire command")
+			log.Warnf("Failed to decode the lock being replaced: %v", err)
 		}
-		return ErrLockExists
+		log.Warnf("Forcing the lock held by %s (signer: %s): %s", holder.LockID, holder.Signer, reason)
+
+		holder.PreviousOwner = nil
+		previous = &holder
+		lockInfo.PreviousOwner = previous
+		lockInfo.Takeover = t.TakeoverForced
+	}
+
+	// The new token fences off the writes of the replaced holder
+	fencingToken, err := NextFencingToken(ctx, cli, bucket, key)
+	if err != nil {
+		return t.LockInfo{}, nil, err
+	}
+	lockInfo.FencingToken = fencingToken
+
+	err = putStateLock(ctx, cli, bucket, key, etag, lockInfo)
+	if isPreconditionFailed(err) {
+		return t.LockInfo{}, nil, fmt.Errorf("unable to force-acquire lock: %w: the lock changed while forcing it, please retry", ErrPreconditionFailed)
+	}
+	if err != nil {
+		return t.LockInfo{}, nil, err
+	}
+
+	// New readers are kept out by the lock that was just written
+	released, err := ForceReleaseSharedLocks(ctx, cli, bucket, key, reason)
+	if err != nil {
+		retractStateLock(ctx, cli, bucket, key, lockInfo.OwnerToken)
+		return t.LockInfo{}, nil, fmt.Errorf("unable to force-acquire lock: failed to force-release the shared locks: %w", err)
+	}
+	if released > 0 {
+		log.Warnf("Forced %d reader(s) out of the lock: %s", released, reason)
 	}
 
+	if err := RecordAcquiredToken(ctx, cli, bucket, key, fencingToken); err != nil {
+		retractStateLock(ctx, cli, bucket, key, lockInfo.OwnerToken)
+		return t.LockInfo{}, nil, err
+	}
+
+	emit(ctx, t.EventForceAcquire, bucket, key, lockInfo, reason)
+	return lockInfo, previous, nil
+}
+
+// putStateLock writes the state lock file if it is still the one with the given
+// ETag, or if it does not exist when etag is empty.
+func putStateLock(ctx context.Context, cli t.S3Client, bucket, key, etag string, lockInfo t.LockInfo) error {
 	lockInfoRaw, err := json.Marshal(lockInfo)
 	if err != nil {
 		return err
 	}
 	log.Debugf("Raw lock info: %s\n", lockInfoRaw)
 
-	_, err = cli.PutObject(ctx, &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 		Body:   strings.NewReader(string(lockInfoRaw)),
-	})
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	_, err = cli.PutObject(ctx, input)
 	return err
 }
 
@@ -77,8 +233,7 @@ func CheckStateLock(ctx context.Context, cli t.S3Client, bucket, key string, ser
 		return true, t.LockInfo{}, nil
 	}
 
-	lockInfo := t.LockInfo{}
-	err = json.Unmarshal(lockInfoRaw, &lockInfo)
+	lockInfo, err := t.DecodeLockInfo(lockInfoRaw)
 	if err != nil {
 		return false, lockInfo, err
 	}
@@ -86,42 +241,261 @@ func CheckStateLock(ctx context.Context, cli t.S3Client, bucket, key string, ser
 	return true, lockInfo, nil
 }
 
-// ReleaseStateLock deletes the state lock file in an S3 bucket if it exists.
-func ReleaseStateLock(ctx context.Context, cli t.S3Client, bucket, key string) error {
-	same_sha, err := subproc.CompareSHAs(ctx, cli, bucket, key)
+// ReleaseStateLock deletes the state lock file in an S3 bucket if it is held with the given owner token.
+// The delete is conditional on the ETag of the lock that was checked, so a lock
+// replaced in the meantime is never removed.
+func ReleaseStateLock(ctx context.Context, cli t.S3Client, bucket, key, token string) error {
+	if token == "" {
+		return fmt.Errorf("unable to release lock: %w", ErrNoOwnerToken)
+	}
+
+	exist, remote, etag, err := fetchStateLock(ctx, cli, bucket, key)
 	if err != nil {
 		return fmt.Errorf("unable to release lock: %v", err)
 	}
+	if !exist {
+		return fmt.Errorf("unable to release lock: lock does not exist")
+	}
 
-	if !same_sha {
-		return fmt.Errorf("unable to release lock: local and remote SHAs do not match. If you are sure you want to release the lock, use the force-release command")
+	if remote.OwnerToken != token {
+		return fmt.Errorf("unable to release lock: %w (lock ID: %s, signer: %s). If you are sure you want to release the lock, use the force-release command", ErrNotOwner, remote.LockID, remote.Signer)
+	}
+	if err := checkSchema(remote); err != nil {
+		return fmt.Errorf("unable to release lock: %w", err)
 	}
 
-	// If the lock file exists, attempt to delete it
-	_, err = cli.DeleteObject(
-		ctx,
-		&s3.DeleteObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-		},
-	)
-	return err
+	if err := deleteStateLock(ctx, cli, bucket, key, etag); err != nil {
+		return fmt.Errorf("unable to release lock: %w", err)
+	}
+
+	emit(ctx, t.EventRelease, bucket, key, remote, "")
+	return nil
+}
+
+// RenewStateLock refreshes the heartbeat of a lock held with the owner token and extends its lease by ttl.
+// A zero ttl keeps the lease duration the lock was acquired with. The update is conditional on
+// the ETag of the lock that was checked, and ErrLockLost is returned if the lock is gone or was
+// taken over by another process.
+func RenewStateLock(ctx context.Context, cli t.S3Client, bucket, key, token string, ttl time.Duration) (t.LockInfo, error) {
+	exist, remote, etag, err := fetchStateLock(ctx, cli, bucket, key)
+	if err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w", err)
+	}
+	if !exist {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w: the lock does not exist", ErrLockLost)
+	}
+	if token == "" || remote.OwnerToken != token {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w: the lock is held by %s (signer: %s)", ErrLockLost, remote.LockID, remote.Signer)
+	}
+	if err := checkSchema(remote); err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w", err)
+	}
+
+	if ttl <= 0 {
+		ttl = remote.Lease()
+	}
+	now := time.Now()
+	renewed := remote.WithLease(now, ttl)
+	renewed.Heartbeat = now.UTC().Format(time.RFC3339)
+
+	lockInfoRaw, err := json.Marshal(renewed)
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+	log.Debugf("Raw lock info: %s\n", lockInfoRaw)
+
+	_, err = cli.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		Body:    strings.NewReader(string(lockInfoRaw)),
+		IfMatch: aws.String(etag),
+	})
+	if isPreconditionFailed(err) {
+		return t.LockInfo{}, fmt.Errorf("unable to renew lock: %w: the lock was modified by another process since it was read", ErrLockLost)
+	}
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+
+	emit(ctx, t.EventRenew, bucket, key, renewed, "")
+	return renewed, nil
 }
 
-// ForceReleaseLock deletes the state lock file or clears its content in an S3 bucket.
-func ForceReleaseLock(ctx context.Context, cli t.S3Client, bucket, key string) error {
-	_, _, err := CheckStateLock(ctx, cli, bucket, key, false)
+// VerifyStateLock checks that the state lock file is held with the owner token and
+// that its lease has not expired. The current lock is returned if it exists.
+func VerifyStateLock(ctx context.Context, cli t.S3Client, bucket, key, token string) (t.LockInfo, error) {
+	return VerifyLock(ctx, S3Locker{Client: cli}, bucket, key, token)
+}
+
+// ForceReleaseLock deletes the state lock file in an S3 bucket, whoever holds it.
+// Before the lock is deleted, a record of the removed lock, the actor and the
+// reason is written under DefaultForceReleasePrefix of the lock key. The lock is
+// not deleted if the record cannot be written.
+func ForceReleaseLock(ctx context.Context, cli t.S3Client, bucket, key, reason string) error {
+	exist, lockInfoRaw, etag, err := getStateLock(ctx, cli, bucket, key)
+	if err != nil || !exist {
+		return err
+	}
+
+	// A lock that cannot be decoded is still removed
+	holder, err := t.DecodeLockInfo(lockInfoRaw)
 	if err != nil {
+		log.Warnf("Failed to decode the lock being released: %v", err)
+	}
+
+	if err := recordForceRelease(ctx, cli, bucket, key, reason, holder); err != nil {
+		return fmt.Errorf("unable to record the force-release, the lock was not released: %w", err)
+	}
+
+	if err := deleteStateLock(ctx, cli, bucket, key, etag); err != nil {
 		return err
 	}
 
-	// If the lock file exists, attempt to delete it
-	_, err = cli.DeleteObject(
-		ctx,
-		&s3.DeleteObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-		},
-	)
+	emit(ctx, t.EventForceRelease, bucket, key, holder, reason)
+	return nil
+}
+
+// DefaultForceReleasePrefix returns the prefix the force-release records of the lock at key are stored under.
+func DefaultForceReleasePrefix(key string) string {
+	return key + ".force-releases/"
+}
+
+// newForceReleaseRecord describes the lock that is about to be force-released,
+// and returns the record as JSON with its name, unique and sorted by time.
+func newForceReleaseRecord(bucket, key, reason string, holder t.LockInfo) ([]byte, string, error) {
+	// The owner token is not needed to follow who held the lock
+	holder.OwnerToken = ""
+
+	now := time.Now().UTC()
+	record := t.ForceReleaseRecord{
+		Time:   now.Format(time.RFC3339Nano),
+		Bucket: bucket,
+		Key:    key,
+		Actor:  Actor(),
+		Reason: reason,
+		Lock:   &holder,
+	}
+
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return nil, "", err
+	}
+	return raw, fmt.Sprintf("%s_%s.json", now.Format(eventTimeFormat), uuid.New().String()), nil
+}
+
+// recordForceRelease writes a record of the lock that is about to be force-released.
+func recordForceRelease(ctx context.Context, cli t.S3Client, bucket, key, reason string, holder t.LockInfo) error {
+	raw, name, err := newForceReleaseRecord(bucket, key, reason, holder)
+	if err != nil {
+		return err
+	}
+
+	recordKey := DefaultForceReleasePrefix(key) + name
+	log.Debugf("Recording force-release to %s", recordKey)
+
+	_, err = cli.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(recordKey),
+		Body:        strings.NewReader(string(raw)),
+		IfNoneMatch: aws.String("*"),
+	})
+	return err
+}
+
+// fetchStateLock reads and decodes the state lock file together with its ETag.
+func fetchStateLock(ctx context.Context, cli t.S3Client, bucket, key string) (bool, t.LockInfo, string, error) {
+	exist, lockInfoRaw, etag, err := getStateLock(ctx, cli, bucket, key)
+	if err != nil || !exist {
+		return false, t.LockInfo{}, "", err
+	}
+
+	lockInfo, err := t.DecodeLockInfo(lockInfoRaw)
+	if err != nil {
+		return false, lockInfo, "", err
+	}
+
+	return true, lockInfo, etag, nil
+}
+
+// getStateLock reads the raw state lock file together with its ETag.
+func getStateLock(ctx context.Context, cli t.S3Client, bucket, key string) (bool, []byte, string, error) {
+	resp, err := cli.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if errors.As(err, &KeyNotFound) {
+			return false, nil, "", nil // Lock does not exist.
+		}
+		return false, nil, "", err
+	}
+	defer resp.Body.Close()
+
+	lockInfoRaw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, nil, "", err
+	}
+	log.Debugf("Raw lock info: %s\n", lockInfoRaw)
+
+	return true, lockInfoRaw, aws.ToString(resp.ETag), nil
+}
+
+// retractStateLock removes the lock that was just written with the owner token,
+// when it turns out it cannot be held. Failures are only logged.
+func retractStateLock(ctx context.Context, cli t.S3Client, bucket, key, token string) {
+	exist, remote, etag, err := fetchStateLock(ctx, cli, bucket, key)
+	if err == nil && exist && remote.OwnerToken == token {
+		err = deleteStateLock(ctx, cli, bucket, key, etag)
+	}
+	if err != nil {
+		log.Warnf("Failed to remove the lock that could not be acquired: %v", err)
+	}
+}
+
+// deleteStateLock removes the lock file only if it still has the given ETag.
+func deleteStateLock(ctx context.Context, cli t.S3Client, bucket, key, etag string) error {
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	}
+
+	_, err := cli.DeleteObject(ctx, input)
+	if isPreconditionFailed(err) {
+		return fmt.Errorf("%w: the lock was modified by another process since it was read", ErrPreconditionFailed)
+	}
 	return err
 }
+
+// checkSchema fails if the lock was written in a schema version this statectl
+// does not understand, so the lock is never changed with fields lost.
+func checkSchema(lockInfo t.LockInfo) error {
+	if lockInfo.Supported() {
+		return nil
+	}
+	return fmt.Errorf("%w: its schema version %d is newer than version %d of this statectl, please upgrade statectl or use the force-release command", ErrUnsupportedSchema, lockInfo.SchemaVersion, t.LockSchemaVersion)
+}
+
+// isPreconditionFailed reports whether a conditional S3 request was rejected
+// because the object did not match the `If-Match` / `If-None-Match` header.
+func isPreconditionFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() == http.StatusPreconditionFailed
+	}
+	return false
+}
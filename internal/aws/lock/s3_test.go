@@ -3,44 +3,149 @@ package lock_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"statectl/internal/aws/lock"
-	"statectl/internal/utils/subproc"
+	st "statectl/internal/utils/types"
 	"statectl/test"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/mock"
 )
 
+var errPreconditionFailed = &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold"}
+
 func TestAcquireStateLock(t *testing.T) {
 	// Set up
 	ctx := context.Background()
 	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
 
 	bucket := "test-bucket"
 	key := "test-key"
 	expectedSHA := "1234567890"
 	lockInfo, _ := test.CreateLockInfo(expectedSHA)
 
-	// AcquireStateLock: GetObject -> PutObject
+	// AcquireStateLock: conditional PutObject
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.IfNoneMatch) == "*"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	if _, err := lock.AcquireStateLock(ctx, mockS3, bucket, key, lockInfo); err != nil {
+		t.Errorf("error acquiring state lock: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+}
+
+func TestAcquireStateLockRace(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	bucket := "test-bucket"
+	key := "test-key"
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	_, winnerRaw := test.CreateLockInfo("0987654321")
+
+	// AcquireStateLock: conditional PutObject (lost the race) -> GetObject
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, errPreconditionFailed,
+	)
 	// Mock GetObject
 	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
-		&s3.GetObjectOutput{}, &types.NoSuchKey{},
+		&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader(winnerRaw)),
+			ETag: aws.String(`"winner"`),
+		}, nil,
 	)
+
+	// Call the function under test
+	_, err := lock.AcquireStateLock(ctx, mockS3, bucket, key, lockInfo)
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if !errors.Is(err, lock.ErrLockHeld) {
+		t.Errorf("expected ErrLockHeld, got: %v", err)
+	}
+}
+
+func TestAcquireStateLockReleasedDuringRace(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	bucket := "test-bucket"
+	key := "test-key"
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// AcquireStateLock: conditional PutObject (lost the race) -> GetObject (already released)
 	// Mock PutObject
 	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
-		&s3.PutObjectOutput{}, nil,
+		&s3.PutObjectOutput{}, errPreconditionFailed,
+	)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{}, &types.NoSuchKey{},
 	)
 
 	// Call the function under test
-	if err := lock.AcquireStateLock(ctx, mockS3, bucket, key, lockInfo); err != nil {
-		t.Errorf("error acquiring state lock: %v", err)
+	_, err := lock.AcquireStateLock(ctx, mockS3, bucket, key, lockInfo)
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if !errors.Is(err, lock.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed, got: %v", err)
 	}
+}
+
+func TestAcquireStateLockUnreadable(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	errAccessDenied := &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}
+
+	// AcquireStateLock: conditional PutObject (lock exists) -> GetObject (denied)
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, errPreconditionFailed,
+	)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{}, errAccessDenied,
+	)
+
+	// Call the function under test
+	_, err := lock.AcquireStateLock(ctx, mockS3, "test-bucket", "test-key", lockInfo)
 
 	// Assertions
 	mockS3.AssertExpectations(t)
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "AccessDenied" {
+		t.Errorf("expected the error reading the lock, got: %v", err)
+	}
 }
 
 func TestCheckStateLock(t *testing.T) {
@@ -84,27 +189,27 @@ func TestReleaseStateLock(t *testing.T) {
 
 	bucket := "test-bucket"
 	key := "test-key"
-	expectedSHA, err := subproc.FetchLocalSHA()
-	if err != nil {
-		t.Errorf("error fetching local git SHA: %v", err)
-	}
-	_, lockInfoRaw := test.CreateLockInfo(expectedSHA)
+	expectedSHA := "1234567890"
+	lockInfo, lockInfoRaw := test.CreateLockInfo(expectedSHA)
 
-	// CheckStateLock: GetObject
+	// ReleaseStateLock: GetObject -> conditional DeleteObject
 	// Mock GetObject
 	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
 		&s3.GetObjectOutput{
 			Body: io.NopCloser(bytes.NewReader(lockInfoRaw)),
+			ETag: aws.String(`"owned"`),
 		}, nil,
 	)
 
 	// Mock DeleteObject
-	mockS3.On("DeleteObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.DeleteObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+	mockS3.On("DeleteObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.DeleteObjectInput) bool {
+		return aws.ToString(input.IfMatch) == `"owned"`
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
 		&s3.DeleteObjectOutput{}, nil,
 	)
 
 	// Call the function under test
-	if err := lock.ReleaseStateLock(ctx, mockS3, bucket, key); err != nil {
+	if err := lock.ReleaseStateLock(ctx, mockS3, bucket, key, lockInfo.OwnerToken); err != nil {
 		t.Errorf("error releasing state lock: %v", err)
 	}
 
@@ -112,6 +217,40 @@ func TestReleaseStateLock(t *testing.T) {
 	mockS3.AssertExpectations(t)
 }
 
+func TestReleaseStateLockModified(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	bucket := "test-bucket"
+	key := "test-key"
+	expectedSHA := "1234567890"
+	lockInfo, lockInfoRaw := test.CreateLockInfo(expectedSHA)
+
+	// ReleaseStateLock: GetObject -> conditional DeleteObject (lock replaced in between)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader(lockInfoRaw)),
+			ETag: aws.String(`"owned"`),
+		}, nil,
+	)
+
+	// Mock DeleteObject
+	mockS3.On("DeleteObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.DeleteObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.DeleteObjectOutput{}, errPreconditionFailed,
+	)
+
+	// Call the function under test
+	err := lock.ReleaseStateLock(ctx, mockS3, bucket, key, lockInfo.OwnerToken)
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if !errors.Is(err, lock.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed, got: %v", err)
+	}
+}
+
 func TestReleaseStateLockForce(t *testing.T) {
 	// Set up
 	ctx := context.Background()
@@ -122,24 +261,390 @@ func TestReleaseStateLockForce(t *testing.T) {
 	expectedSHA := "1234567890"
 	_, lockInfoRaw := test.CreateLockInfo(expectedSHA)
 
-	// CheckStateLock: GetObject
+	// ForceReleaseLock: GetObject -> PutObject (record) -> conditional DeleteObject
 	// Mock GetObject
 	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
 		&s3.GetObjectOutput{
 			Body: io.NopCloser(bytes.NewReader(lockInfoRaw)),
+			ETag: aws.String(`"stale"`),
 		}, nil,
 	)
 
+	// Mock PutObject
+	var record st.ForceReleaseRecord
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		if !strings.HasPrefix(aws.ToString(input.Key), lock.DefaultForceReleasePrefix(key)) {
+			return false
+		}
+		return json.NewDecoder(input.Body).Decode(&record) == nil
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
 	// Mock DeleteObject
-	mockS3.On("DeleteObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.DeleteObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+	mockS3.On("DeleteObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.DeleteObjectInput) bool {
+		return aws.ToString(input.IfMatch) == `"stale"`
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
 		&s3.DeleteObjectOutput{}, nil,
 	)
 
 	// Call the function under test
-	if err := lock.ForceReleaseLock(ctx, mockS3, bucket, key); err != nil {
+	if err := lock.ForceReleaseLock(ctx, mockS3, bucket, key, "stuck pipeline"); err != nil {
 		t.Errorf("error releasing state lock: %v", err)
 	}
 
 	// Assertions
 	mockS3.AssertExpectations(t)
+	if record.Reason != "stuck pipeline" || record.Actor == "" || record.Lock == nil || record.Lock.LockID != expectedSHA {
+		t.Errorf("unexpected force-release record: %+v", record)
+	}
+}
+
+func TestReleaseStateLockForceRecordFailed(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	_, lockInfoRaw := test.CreateLockInfo("1234567890")
+
+	// ForceReleaseLock: GetObject -> PutObject (record fails), the lock is kept
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader(lockInfoRaw)),
+			ETag: aws.String(`"stale"`),
+		}, nil,
+	)
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, errors.New("access denied"),
+	)
+
+	// Call the function under test
+	if err := lock.ForceReleaseLock(ctx, mockS3, "test-bucket", "test-key", ""); err == nil {
+		t.Errorf("expected an error when the force-release cannot be recorded")
+	}
+
+	// Assertions
+	mockS3.AssertNotCalled(t, "DeleteObject")
+}
+
+func TestAcquireStateLockTakeoverExpired(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	bucket := "test-bucket"
+	key := "test-key"
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	expired, _ := test.CreateLockInfo("0987654321")
+	expired = expired.WithLease(time.Now().Add(-2*time.Hour), time.Hour)
+	expiredRaw, _ := json.Marshal(expired)
+
+	// AcquireStateLock: conditional PutObject -> GetObject (expired) -> PutObject If-Match
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.IfNoneMatch) == "*"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, errPreconditionFailed,
+	)
+	var takeover st.LockInfo
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.IfMatch) == `"expired"`
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Run(func(args mock.Arguments) {
+		body, _ := io.ReadAll(args.Get(1).(*s3.PutObjectInput).Body)
+		_ = json.Unmarshal(body, &takeover)
+	}).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader(expiredRaw)),
+			ETag: aws.String(`"expired"`),
+		}, nil,
+	)
+
+	// Call the function under test
+	if _, err := lock.AcquireStateLock(ctx, mockS3, bucket, key, lockInfo); err != nil {
+		t.Errorf("error taking over expired state lock: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if takeover.Takeover != st.TakeoverExpired || takeover.PreviousOwner == nil || takeover.PreviousOwner.LockID != expired.LockID {
+		t.Errorf("expected takeover to be recorded in the lock, got: %+v", takeover)
+	}
+}
+
+func TestRenewStateLock(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	bucket := "test-bucket"
+	key := "test-key"
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	lockInfo = lockInfo.WithLease(time.Now().Add(-30*time.Minute), time.Hour)
+	lockInfoRaw, _ := json.Marshal(lockInfo)
+
+	// RenewStateLock: GetObject -> PutObject If-Match
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader(lockInfoRaw)),
+			ETag: aws.String(`"owned"`),
+		}, nil,
+	)
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.IfMatch) == `"owned"`
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	renewed, err := lock.RenewStateLock(ctx, mockS3, bucket, key, lockInfo.OwnerToken, 0)
+	if err != nil {
+		t.Errorf("error renewing state lock: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if renewed.Heartbeat == "" {
+		t.Errorf("expected heartbeat to be set")
+	}
+	if remaining, _ := renewed.Remaining(time.Now()); remaining < 59*time.Minute {
+		t.Errorf("expected lease to be extended by the original TTL, got %s remaining", remaining)
+	}
+}
+
+func TestRenewStateLockNotOwned(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	bucket := "test-bucket"
+	key := "test-key"
+	_, lockInfoRaw := test.CreateLockInfo("0987654321")
+
+	// RenewStateLock: GetObject
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader(lockInfoRaw)),
+			ETag: aws.String(`"other"`),
+		}, nil,
+	)
+
+	// Call the function under test
+	_, err := lock.RenewStateLock(ctx, mockS3, bucket, key, "1234567890-token", time.Hour)
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if !errors.Is(err, lock.ErrLockLost) {
+		t.Errorf("expected ErrLockLost, got: %v", err)
+	}
+}
+
+func TestAcquireStateLockSameCommitOtherOwner(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	bucket := "test-bucket"
+	key := "test-key"
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	retry := lockInfo
+	retry.OwnerToken = "retry-token"
+
+	// AcquireStateLock: conditional PutObject (held) -> GetObject
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, errPreconditionFailed,
+	)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			_, lockInfoRaw := test.CreateLockInfo("1234567890")
+			return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(lockInfoRaw))}
+		}, nil,
+	)
+
+	// Call the function under test: a retry of the same commit must not own the lock
+	_, err := lock.AcquireStateLock(ctx, mockS3, bucket, key, retry)
+	if !errors.Is(err, lock.ErrLockHeld) {
+		t.Errorf("expected ErrLockHeld, got: %v", err)
+	}
+
+	// Call the function under test: the original acquisition already owns the lock
+	_, err = lock.AcquireStateLock(ctx, mockS3, bucket, key, lockInfo)
+	if !errors.Is(err, lock.ErrLockExists) {
+		t.Errorf("expected ErrLockExists, got: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+}
+
+func TestReleaseStateLockOtherOwner(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	bucket := "test-bucket"
+	key := "test-key"
+	_, lockInfoRaw := test.CreateLockInfo("1234567890")
+
+	// ReleaseStateLock: GetObject
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader(lockInfoRaw)),
+			ETag: aws.String(`"owned"`),
+		}, nil,
+	)
+
+	// Call the function under test: same commit, but another acquisition
+	err := lock.ReleaseStateLock(ctx, mockS3, bucket, key, "retry-token")
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	mockS3.AssertNotCalled(t, "DeleteObject", mock.Anything, mock.Anything, mock.Anything)
+	if !errors.Is(err, lock.ErrNotOwner) {
+		t.Errorf("expected ErrNotOwner, got: %v", err)
+	}
+}
+
+func TestForceAcquireStateLock(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	bucket := "test-bucket"
+	key := "test-key"
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	holder, holderRaw := test.CreateLockInfo("0987654321")
+
+	// ForceAcquireStateLock: GetObject -> PutObject matching the ETag of the held lock
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader(holderRaw)),
+			ETag: aws.String(`"held"`),
+		}, nil,
+	)
+	// Mock PutObject
+	var written st.LockInfo
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		if aws.ToString(input.IfMatch) != `"held"` {
+			return false
+		}
+		return json.NewDecoder(input.Body).Decode(&written) == nil
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	_, previous, err := lock.ForceAcquireStateLock(ctx, mockS3, bucket, key, lockInfo, "pipeline was cancelled")
+	if err != nil {
+		t.Fatalf("error force-acquiring state lock: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if previous == nil || previous.LockID != holder.LockID {
+		t.Errorf("expected the previous holder %s to be returned, got: %+v", holder.LockID, previous)
+	}
+	if written.PreviousOwner == nil || written.PreviousOwner.LockID != holder.LockID {
+		t.Errorf("expected the previous holder to be kept in the new lock, got: %+v", written.PreviousOwner)
+	}
+	if written.Takeover != st.TakeoverForced || written.Reason != "pipeline was cancelled" {
+		t.Errorf("expected a forced takeover with the reason, got takeover %q and reason %q", written.Takeover, written.Reason)
+	}
+}
+
+func TestForceAcquireStateLockUnlocked(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// ForceAcquireStateLock: GetObject (no lock) -> PutObject creating the lock
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{}, &types.NoSuchKey{},
+	)
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.IfNoneMatch) == "*"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	_, previous, err := lock.ForceAcquireStateLock(ctx, mockS3, "test-bucket", "test-key", lockInfo, "hotfix")
+	if err != nil {
+		t.Fatalf("error force-acquiring state lock: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if previous != nil {
+		t.Errorf("expected no previous holder, got: %+v", previous)
+	}
+}
+
+func TestForceAcquireStateLockRequiresReason(t *testing.T) {
+	mockS3 := new(test.MockS3Client)
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	_, _, err := lock.ForceAcquireStateLock(context.Background(), mockS3, "test-bucket", "test-key", lockInfo, " ")
+	if !errors.Is(err, lock.ErrNoReason) {
+		t.Errorf("expected ErrNoReason, got: %v", err)
+	}
+	mockS3.AssertNotCalled(t, "PutObject")
+}
+
+func TestVerifyStateLock(t *testing.T) {
+	lockInfo, lockInfoRaw := test.CreateLockInfo("1234567890")
+	expired, expiredRaw := test.CreateLockInfo("1234567890")
+	expired.ExpiresAt = "2021-01-01T01:00:00Z"
+	expiredRaw, _ = json.Marshal(expired)
+
+	tests := []struct {
+		name     string
+		raw      []byte
+		err      error
+		token    string
+		expected error
+	}{
+		{"held", lockInfoRaw, nil, lockInfo.OwnerToken, nil},
+		{"not locked", nil, &types.NoSuchKey{}, lockInfo.OwnerToken, lock.ErrNotLocked},
+		{"other owner", lockInfoRaw, nil, "other-token", lock.ErrNotOwner},
+		{"expired", expiredRaw, nil, lockInfo.OwnerToken, lock.ErrLockLost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockS3 := new(test.MockS3Client)
+			// Mock GetObject
+			mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+				&s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(tt.raw))}, tt.err,
+			)
+
+			_, err := lock.VerifyStateLock(context.Background(), mockS3, "test-bucket", "test-key", tt.token)
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, got: %v", tt.expected, err)
+			}
+		})
+	}
 }
@@ -0,0 +1,257 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	t "statectl/internal/utils/types"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var ErrSharedLockHeld = errors.New("lock is held in shared mode by readers of the state")
+
+// DefaultSharedPrefix returns the prefix the shared holders of the lock at key
+// are stored under, one object per holder.
+func DefaultSharedPrefix(key string) string {
+	return key + ".shared/"
+}
+
+// sharedLockKey returns the key of the shared lock held with the owner token.
+func sharedLockKey(key, token string) string {
+	return DefaultSharedPrefix(key) + token + ".json"
+}
+
+// AcquireSharedLock acquires the lock at key in shared mode. Any number of
+// readers can hold the lock together, but not while a writer holds the
+// exclusive lock with a live lease. Each reader writes its own object under
+// DefaultSharedPrefix and then checks again for a writer, while a writer checks
+// for readers after writing the exclusive lock, so the two can never both
+// believe they hold the lock.
+//
+// ErrLockExists is returned with the lock if it is already held with the same owner token.
+func AcquireSharedLock(ctx context.Context, cli t.S3Client, bucket, key string, lockInfo t.LockInfo) (t.LockInfo, error) {
+	if lockInfo.OwnerToken == "" {
+		return t.LockInfo{}, ErrNoOwnerToken
+	}
+	lockInfo.Mode = t.LockModeShared
+
+	if err := checkExclusiveLock(ctx, cli, bucket, key); err != nil {
+		return t.LockInfo{}, err
+	}
+
+	sharedKey := sharedLockKey(key, lockInfo.OwnerToken)
+	err := putStateLock(ctx, cli, bucket, sharedKey, "", lockInfo)
+	if isPreconditionFailed(err) {
+		exist, remote, _, err := fetchStateLock(ctx, cli, bucket, sharedKey)
+		if err != nil || !exist {
+			return t.LockInfo{}, fmt.Errorf("unable to acquire shared lock: %w: the lock changed while acquiring it, please retry", ErrPreconditionFailed)
+		}
+		return remote, ErrLockExists
+	}
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+
+	// A writer may have acquired the lock since it was checked
+	if err := checkExclusiveLock(ctx, cli, bucket, key); err != nil {
+		if derr := deleteStateLock(ctx, cli, bucket, sharedKey, ""); derr != nil {
+			log.Warnf("Failed to remove the shared lock %s after a writer acquired the lock: %v", sharedKey, derr)
+		}
+		return t.LockInfo{}, err
+	}
+
+	emit(ctx, t.EventAcquire, bucket, key, lockInfo, "")
+	return lockInfo, nil
+}
+
+// ReleaseSharedLock deletes the shared lock held with the given owner token.
+func ReleaseSharedLock(ctx context.Context, cli t.S3Client, bucket, key, token string) error {
+	if token == "" {
+		return fmt.Errorf("unable to release shared lock: %w", ErrNoOwnerToken)
+	}
+
+	sharedKey := sharedLockKey(key, token)
+	exist, remote, etag, err := fetchStateLock(ctx, cli, bucket, sharedKey)
+	if err != nil {
+		return fmt.Errorf("unable to release shared lock: %v", err)
+	}
+	if !exist {
+		return fmt.Errorf("unable to release shared lock: %w: no shared lock is held with this owner token", ErrNotLocked)
+	}
+	if err := checkSchema(remote); err != nil {
+		return fmt.Errorf("unable to release shared lock: %w", err)
+	}
+
+	if err := deleteStateLock(ctx, cli, bucket, sharedKey, etag); err != nil {
+		return fmt.Errorf("unable to release shared lock: %w", err)
+	}
+
+	emit(ctx, t.EventRelease, bucket, key, remote, "")
+	return nil
+}
+
+// RenewSharedLock extends the lease of the shared lock held with the owner token by ttl,
+// as RenewStateLock does for the exclusive lock.
+func RenewSharedLock(ctx context.Context, cli t.S3Client, bucket, key, token string, ttl time.Duration) (t.LockInfo, error) {
+	if token == "" {
+		return t.LockInfo{}, fmt.Errorf("unable to renew shared lock: %w", ErrNoOwnerToken)
+	}
+
+	sharedKey := sharedLockKey(key, token)
+	exist, remote, etag, err := fetchStateLock(ctx, cli, bucket, sharedKey)
+	if err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to renew shared lock: %w", err)
+	}
+	if !exist {
+		return t.LockInfo{}, fmt.Errorf("unable to renew shared lock: %w: the lock does not exist", ErrLockLost)
+	}
+	if err := checkSchema(remote); err != nil {
+		return t.LockInfo{}, fmt.Errorf("unable to renew shared lock: %w", err)
+	}
+
+	if ttl <= 0 {
+		ttl = remote.Lease()
+	}
+	now := time.Now()
+	renewed := remote.WithLease(now, ttl)
+	renewed.Heartbeat = now.UTC().Format(time.RFC3339)
+
+	err = putStateLock(ctx, cli, bucket, sharedKey, etag, renewed)
+	if isPreconditionFailed(err) {
+		return t.LockInfo{}, fmt.Errorf("unable to renew shared lock: %w: the lock was modified by another process since it was read", ErrLockLost)
+	}
+	if err != nil {
+		return t.LockInfo{}, err
+	}
+
+	emit(ctx, t.EventRenew, bucket, key, renewed, "")
+	return renewed, nil
+}
+
+// ListSharedLocks returns the shared holders of the lock at key, including the
+// ones whose lease expired. Their owner tokens are left out.
+func ListSharedLocks(ctx context.Context, cli t.S3Client, bucket, key string) ([]t.LockInfo, error) {
+	holders, err := listSharedLocks(ctx, cli, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make([]t.LockInfo, 0, len(holders))
+	for _, holder := range holders {
+		holder.lock.OwnerToken = ""
+		locks = append(locks, holder.lock)
+	}
+	return locks, nil
+}
+
+// ForceReleaseSharedLocks deletes every shared lock of the lock at key, whoever
+// holds it. A record is written for each of them before it is deleted, as with
+// ForceReleaseLock. It returns the number of shared locks that were released.
+func ForceReleaseSharedLocks(ctx context.Context, cli t.S3Client, bucket, key, reason string) (int, error) {
+	holders, err := listSharedLocks(ctx, cli, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, holder := range holders {
+		if err := recordForceRelease(ctx, cli, bucket, key, reason, holder.lock); err != nil {
+			return released, fmt.Errorf("unable to record the force-release, the shared lock %s was not released: %w", holder.key, err)
+		}
+		if err := deleteStateLock(ctx, cli, bucket, holder.key, holder.etag); err != nil {
+			return released, err
+		}
+		emit(ctx, t.EventForceRelease, bucket, key, holder.lock, reason)
+		released++
+	}
+	return released, nil
+}
+
+// checkExclusiveLock fails with ErrLockHeld if a writer holds the lock at key with a live lease.
+func checkExclusiveLock(ctx context.Context, cli t.S3Client, bucket, key string) error {
+	exist, writer, _, err := fetchStateLock(ctx, cli, bucket, key)
+	if err != nil {
+		return fmt.Errorf("unable to check the exclusive lock: %w", err)
+	}
+	if exist && !writer.Expired(time.Now()) {
+		return fmt.Errorf("unable to acquire shared lock: %w: the state is being written by %s (signer: %s)", ErrLockHeld, writer.LockID, writer.Signer)
+	}
+	return nil
+}
+
+// checkSharedLocks fails with ErrSharedLockHeld, which also matches ErrLockHeld,
+// if any reader holds the lock at key with a live lease. Expired shared locks
+// are removed on the way, as their holders are gone.
+func checkSharedLocks(ctx context.Context, cli t.S3Client, bucket, key string) error {
+	holders, err := listSharedLocks(ctx, cli, bucket, key)
+	if err != nil {
+		return fmt.Errorf("unable to check the shared locks: %w", err)
+	}
+
+	now := time.Now()
+	live := 0
+	for _, holder := range holders {
+		if !holder.lock.Expired(now) {
+			live++
+			continue
+		}
+		log.Debugf("Removing the expired shared lock %s", holder.key)
+		if err := deleteStateLock(ctx, cli, bucket, holder.key, holder.etag); err != nil {
+			log.Warnf("Failed to remove the expired shared lock %s: %v", holder.key, err)
+		}
+	}
+
+	if live > 0 {
+		return fmt.Errorf("unable to acquire lock: %w: %w: %d reader(s) hold the lock", ErrLockHeld, ErrSharedLockHeld, live)
+	}
+	return nil
+}
+
+// sharedHolder is a shared lock together with the key and ETag of its object.
+type sharedHolder struct {
+	key  string
+	etag string
+	lock t.LockInfo
+}
+
+// listSharedLocks reads every shared lock stored under the prefix of the lock at key.
+func listSharedLocks(ctx context.Context, cli t.S3Client, bucket, key string) ([]sharedHolder, error) {
+	holders := []sharedHolder{}
+	paginator := s3.NewListObjectsV2Paginator(cli, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(DefaultSharedPrefix(key)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			sharedKey := aws.ToString(object.Key)
+			if !strings.HasSuffix(sharedKey, ".json") {
+				continue
+			}
+
+			// The shared lock may have been released since it was listed
+			exist, raw, etag, err := getStateLock(ctx, cli, bucket, sharedKey)
+			if err != nil {
+				return nil, err
+			}
+			if !exist {
+				continue
+			}
+
+			// A shared lock that cannot be decoded is kept as one without expiry
+			lockInfo, err := t.DecodeLockInfo(raw)
+			if err != nil {
+				log.Warnf("Failed to decode the shared lock %s: %v", sharedKey, err)
+			}
+			holders = append(holders, sharedHolder{key: sharedKey, etag: etag, lock: lockInfo})
+		}
+	}
+	return holders, nil
+}
@@ -0,0 +1,253 @@
+package lock_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"statectl/internal/aws/lock"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAcquireSharedLock(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// AcquireSharedLock: GetObject (no writer) -> conditional PutObject -> GetObject (no writer)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		return aws.ToString(input.Key) == "test-key"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{}, &types.NoSuchKey{},
+	).Twice()
+	// Mock PutObject
+	var written st.LockInfo
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		if aws.ToString(input.Key) != "test-key.shared/1234567890-token.json" || aws.ToString(input.IfNoneMatch) != "*" {
+			return false
+		}
+		return json.NewDecoder(input.Body).Decode(&written) == nil
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	acquired, err := lock.AcquireSharedLock(ctx, mockS3, "test-bucket", "test-key", lockInfo)
+	if err != nil {
+		t.Fatalf("error acquiring shared lock: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if !acquired.Shared() || !written.Shared() {
+		t.Errorf("expected the lock to be written in shared mode, got %q", written.Mode)
+	}
+}
+
+func TestAcquireSharedLockWriterHeld(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	_, writerRaw := test.CreateLockInfo("0987654321")
+
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(writerRaw))}, nil,
+	)
+
+	// Call the function under test
+	_, err := lock.AcquireSharedLock(ctx, mockS3, "test-bucket", "test-key", lockInfo)
+
+	// Assertions
+	if !errors.Is(err, lock.ErrLockHeld) {
+		t.Errorf("expected ErrLockHeld, got: %v", err)
+	}
+	mockS3.AssertNotCalled(t, "PutObject")
+}
+
+func TestAcquireSharedLockWriterRace(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	_, writerRaw := test.CreateLockInfo("0987654321")
+
+	// AcquireSharedLock: GetObject (no writer) -> PutObject -> GetObject (writer) -> DeleteObject
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{}, &types.NoSuchKey{},
+	).Once()
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(writerRaw))}, nil,
+	).Once()
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+	// Mock DeleteObject
+	mockS3.On("DeleteObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.DeleteObjectInput) bool {
+		return aws.ToString(input.Key) == "test-key.shared/1234567890-token.json"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.DeleteObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	_, err := lock.AcquireSharedLock(ctx, mockS3, "test-bucket", "test-key", lockInfo)
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if !errors.Is(err, lock.ErrLockHeld) {
+		t.Errorf("expected ErrLockHeld, got: %v", err)
+	}
+}
+
+func TestAcquireStateLockSharedHeld(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+
+	reader, _ := test.CreateLockInfo("reader")
+	reader.Mode = st.LockModeShared
+	test.MockSharedLocks(mockS3, "test-key", reader)
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// AcquireStateLock: PutObject -> ListObjectsV2 (live reader) -> GetObject -> DeleteObject
+	// Mock PutObject
+	var written []byte
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		written, _ = io.ReadAll(input.Body)
+		return aws.ToString(input.Key) == "test-key"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(written)), ETag: aws.String(`"mine"`)}
+		}, nil,
+	)
+	// Mock DeleteObject
+	mockS3.On("DeleteObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.DeleteObjectInput) bool {
+		return aws.ToString(input.Key) == "test-key" && aws.ToString(input.IfMatch) == `"mine"`
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.DeleteObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	_, err := lock.AcquireStateLock(ctx, mockS3, "test-bucket", "test-key", lockInfo)
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+	if !errors.Is(err, lock.ErrSharedLockHeld) || !errors.Is(err, lock.ErrLockHeld) {
+		t.Errorf("expected ErrSharedLockHeld, got: %v", err)
+	}
+}
+
+func TestAcquireStateLockSharedExpired(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+
+	reader, _ := test.CreateLockInfo("reader")
+	reader.Mode = st.LockModeShared
+	reader = reader.WithLease(time.Now().Add(-2*time.Hour), time.Hour)
+	test.MockSharedLocks(mockS3, "test-key", reader)
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// AcquireStateLock: PutObject -> ListObjectsV2 (expired reader) -> DeleteObject (expired reader)
+	// Mock PutObject
+	mockS3.On("PutObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	)
+	// Mock DeleteObject
+	mockS3.On("DeleteObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.DeleteObjectInput) bool {
+		return aws.ToString(input.Key) == "test-key.shared/reader-token.json" && aws.ToString(input.IfMatch) == `"reader-token"`
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.DeleteObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	if _, err := lock.AcquireStateLock(ctx, mockS3, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Errorf("error acquiring state lock: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+}
+
+func TestReleaseSharedLock(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+
+	reader, _ := test.CreateLockInfo("1234567890")
+	reader.Mode = st.LockModeShared
+	test.MockSharedLocks(mockS3, "test-key", reader)
+
+	// Mock DeleteObject
+	mockS3.On("DeleteObject", mock.AnythingOfType("backgroundCtx"), mock.MatchedBy(func(input *s3.DeleteObjectInput) bool {
+		return aws.ToString(input.Key) == "test-key.shared/1234567890-token.json"
+	}), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.DeleteObjectOutput{}, nil,
+	)
+
+	// Call the function under test
+	if err := lock.ReleaseSharedLock(ctx, mockS3, "test-bucket", "test-key", reader.OwnerToken); err != nil {
+		t.Errorf("error releasing shared lock: %v", err)
+	}
+
+	// Assertions
+	mockS3.AssertExpectations(t)
+}
+
+func TestForceAcquireStateLockSharedHeld(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+
+	reader, _ := test.CreateLockInfo("reader")
+	if _, err := lock.AcquireSharedLock(ctx, fakeS3, "test-bucket", "test-key", reader.WithLease(time.Now(), time.Hour)); err != nil {
+		t.Fatalf("error acquiring shared lock: %v", err)
+	}
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// Call the function under test
+	_, _, err := lock.ForceAcquireStateLock(ctx, fakeS3, "test-bucket", "test-key", lockInfo, "reader is stuck")
+	if err != nil {
+		t.Fatalf("error force-acquiring state lock: %v", err)
+	}
+
+	// Assertions
+	readers, err := lock.ListSharedLocks(ctx, fakeS3, "test-bucket", "test-key")
+	if err != nil || len(readers) != 0 {
+		t.Errorf("expected the readers to be force-released, got %v (%v)", readers, err)
+	}
+	records := fakeS3.Keys(lock.DefaultForceReleasePrefix("test-key"))
+	if len(records) != 1 {
+		t.Fatalf("expected one force-release record, got %v", records)
+	}
+	raw, _ := fakeS3.Object(records[0])
+	var record st.ForceReleaseRecord
+	if err := json.Unmarshal(raw, &record); err != nil || record.Reason != "reader is stuck" || record.Lock == nil || record.Lock.LockID != "reader" {
+		t.Errorf("expected the reader and the reason in the record, got %s (%v)", raw, err)
+	}
+}
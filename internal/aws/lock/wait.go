@@ -0,0 +1,138 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	t "statectl/internal/utils/types"
+	"time"
+)
+
+// DefaultPollInterval is the initial delay between two attempts to acquire a held lock.
+const DefaultPollInterval = 5 * time.Second
+
+// DefaultMaxPollInterval caps the delay between two attempts to acquire a held lock.
+const DefaultMaxPollInterval = time.Minute
+
+// WaitOptions configures how WaitAcquireStateLock retries while the lock is held.
+type WaitOptions struct {
+	// Timeout is the maximum time to wait for the lock, zero waits until ctx is cancelled.
+	Timeout time.Duration
+	// PollInterval is the delay before the first retry, it doubles on every attempt.
+	PollInterval time.Duration
+	// MaxPollInterval caps the delay between two attempts.
+	MaxPollInterval time.Duration
+	// Shared acquires the lock in shared mode with AcquireSharedLock.
+	Shared bool
+	// Queue serves the waiters in the order they started to wait, instead of
+	// whichever retries first after the lock is released.
+	Queue bool
+	// TicketTTL is how long the ticket of a queued waiter lasts without being
+	// renewed. It must be well above MaxPollInterval, as the ticket is renewed
+	// once per attempt.
+	TicketTTL time.Duration
+}
+
+// WaitAcquireStateLock acquires the state lock, waiting for the current holder to release it.
+// Attempts are spaced with a jittered exponential backoff. The wait stops when ctx is
+// cancelled or the timeout is reached, and the context error is returned wrapped.
+// The acquired lock is returned as with AcquireStateLock. With opts.Queue, the
+// waiters acquire the lock in turn, see waitInQueue.
+func WaitAcquireStateLock(ctx context.Context, cli t.S3Client, bucket, key string, lockInfo t.LockInfo, opts WaitOptions) (t.LockInfo, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.MaxPollInterval < opts.PollInterval {
+		opts.MaxPollInterval = max(DefaultMaxPollInterval, opts.PollInterval)
+	}
+	if opts.TicketTTL <= 0 {
+		opts.TicketTTL = max(DefaultTicketTTL, 3*opts.MaxPollInterval)
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	if opts.Queue {
+		return waitInQueue(ctx, cli, bucket, key, lockInfo, opts)
+	}
+
+	ttl := lockInfo.Lease()
+	for attempt := 0; ; attempt++ {
+		acquired, err := acquireOnce(ctx, cli, bucket, key, lockInfo, ttl, opts.Shared)
+		if err == nil || errors.Is(err, ErrLockExists) {
+			return acquired, err
+		}
+		if !isLockBusy(err) {
+			return t.LockInfo{}, waitError(ctx, err)
+		}
+
+		delay := Backoff(attempt, opts.PollInterval, opts.MaxPollInterval)
+		logHolder(ctx, cli, bucket, key, delay)
+
+		select {
+		case <-ctx.Done():
+			return t.LockInfo{}, fmt.Errorf("unable to acquire lock: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// acquireOnce makes one attempt to acquire the lock, in shared mode if shared is set.
+func acquireOnce(ctx context.Context, cli t.S3Client, bucket, key string, lockInfo t.LockInfo, ttl time.Duration, shared bool) (t.LockInfo, error) {
+	// The lease starts when the lock is acquired, not when the wait started
+	now := time.Now()
+	lockInfo.TimeStamp = now.Format(time.RFC3339)
+	lockInfo = lockInfo.WithLease(now, ttl)
+
+	if shared {
+		return AcquireSharedLock(ctx, cli, bucket, key, lockInfo)
+	}
+	return AcquireStateLock(ctx, cli, bucket, key, lockInfo)
+}
+
+// isLockBusy reports whether an attempt failed because someone else holds the
+// lock or raced for it, so it is worth retrying.
+func isLockBusy(err error) bool {
+	return errors.Is(err, ErrLockHeld) || errors.Is(err, ErrPreconditionFailed)
+}
+
+// waitError returns the error that ended a wait, which is the context error if
+// the wait was cancelled or timed out.
+func waitError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("unable to acquire lock: %w", ctx.Err())
+	}
+	return err
+}
+
+// Backoff returns the delay before the given retry attempt: an exponential backoff
+// starting at base and capped at maxDelay, with half of it randomized to spread
+// out the pipelines waiting for the same lock.
+func Backoff(attempt int, base, maxDelay time.Duration) time.Duration {
+	delay := base
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// logHolder logs who currently holds the lock while waiting for it.
+func logHolder(ctx context.Context, cli t.S3Client, bucket, key string, delay time.Duration) {
+	exist, holder, err := CheckStateLock(ctx, cli, bucket, key, true)
+	if err != nil || !exist {
+		log.Infof("Lock is busy, retrying in %s", delay.Round(time.Millisecond))
+		return
+	}
+
+	since := holder.TimeStamp
+	if age, ok := holder.Age(time.Now()); ok {
+		since = fmt.Sprintf("%s (%s ago)", holder.TimeStamp, age.Round(time.Second))
+	}
+	log.Infof("Lock is held by %s (signer: %s) since %s, retrying in %s", holder.LockID, holder.Signer, since, delay.Round(time.Millisecond))
+}
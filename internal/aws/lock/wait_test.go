@@ -0,0 +1,104 @@
+package lock_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"statectl/internal/aws/lock"
+	"statectl/test"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWaitAcquireStateLock(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	_, holderRaw := test.CreateLockInfo("0987654321")
+
+	// WaitAcquireStateLock: PutObject (held) -> GetObject -> GetObject -> PutObject
+	// Mock PutObject
+	mockS3.On("PutObject", mock.Anything, mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, errPreconditionFailed,
+	).Once()
+	mockS3.On("PutObject", mock.Anything, mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, nil,
+	).Once()
+	// Mock GetObject
+	mockS3.On("GetObject", mock.Anything, mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(holderRaw))}
+		}, nil,
+	)
+
+	// Call the function under test
+	_, err := lock.WaitAcquireStateLock(ctx, mockS3, "test-bucket", "test-key", lockInfo, lock.WaitOptions{
+		Timeout:      5 * time.Second,
+		PollInterval: 10 * time.Millisecond,
+	})
+
+	// Assertions
+	if err != nil {
+		t.Errorf("error waiting for state lock: %v", err)
+	}
+	mockS3.AssertExpectations(t)
+}
+
+func TestWaitAcquireStateLockTimeout(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	mockS3 := new(test.MockS3Client)
+	test.MockFence(mockS3, "test-key", 0)
+	test.MockSharedLocks(mockS3, "test-key")
+
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	_, holderRaw := test.CreateLockInfo("0987654321")
+
+	// WaitAcquireStateLock: PutObject (held) -> GetObject, until the timeout
+	// Mock PutObject
+	mockS3.On("PutObject", mock.Anything, mock.AnythingOfType("*s3.PutObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.PutObjectOutput{}, errPreconditionFailed,
+	)
+	// Mock GetObject
+	mockS3.On("GetObject", mock.Anything, mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(holderRaw))}
+		}, nil,
+	)
+
+	// Call the function under test
+	_, err := lock.WaitAcquireStateLock(ctx, mockS3, "test-bucket", "test-key", lockInfo, lock.WaitOptions{
+		Timeout:      100 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+	})
+
+	// Assertions
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base := 100 * time.Millisecond
+	max := time.Second
+
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := lock.Backoff(attempt, base, max)
+
+		expected := base << attempt
+		if expected > max {
+			expected = max
+		}
+		if delay < expected/2 || delay > expected {
+			t.Errorf("attempt %d: expected delay in [%s, %s], got %s", attempt, expected/2, expected, delay)
+		}
+	}
+}
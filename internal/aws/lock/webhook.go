@@ -0,0 +1,254 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	t "statectl/internal/utils/types"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Payload formats of the webhook notifications
+const (
+	// WebhookFormatGeneric posts the lock event with a summary of it.
+	WebhookFormatGeneric = "generic"
+	// WebhookFormatSlack posts the summary as a Slack incoming webhook message.
+	WebhookFormatSlack = "slack"
+)
+
+// WebhookFormats lists the payload formats of the webhook notifications.
+var WebhookFormats = []string{WebhookFormatGeneric, WebhookFormatSlack}
+
+const (
+	// DefaultWebhookAttempts is the number of times a notification is sent before it is dropped.
+	DefaultWebhookAttempts = 3
+	// DefaultWebhookTimeout bounds every attempt to send a notification.
+	DefaultWebhookTimeout = 5 * time.Second
+	// DefaultWebhookWait bounds how long a command waits for its pending notifications before it exits.
+	DefaultWebhookWait = 10 * time.Second
+)
+
+// DefaultWebhookEvents are notified when no event types are configured: every
+// change of holder of the lock, but not the renewals of its lease.
+var DefaultWebhookEvents = []string{t.EventAcquire, t.EventRelease, t.EventForceRelease, t.EventTakeover, t.EventForceAcquire}
+
+// WebhookPayload is the body of the generic webhook notifications.
+type WebhookPayload struct {
+	Text  string      `json:"text"`
+	Event t.LockEvent `json:"event"`
+}
+
+// Webhook is a recorder that notifies an HTTP endpoint of the lock events, as
+// JSON POSTs. Notifications are sent in the background and retried, so a slow
+// or failing endpoint never blocks or fails the lock operation; call Wait
+// before the process exits to deliver the pending ones.
+type Webhook struct {
+	URL string
+	// Format of the payload, WebhookFormatGeneric if empty.
+	Format string
+	// Events are the event types notified, DefaultWebhookEvents if empty.
+	Events []string
+	// Attempts is the number of times a notification is sent, DefaultWebhookAttempts if zero.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled on every retry.
+	Backoff time.Duration
+	// Client sends the notifications, http.DefaultClient if nil.
+	Client *http.Client
+
+	pending sync.WaitGroup
+}
+
+// NewWebhook returns a webhook that posts the events to the URL in the given format.
+func NewWebhook(url, format string, events []string) (*Webhook, error) {
+	if format == "" {
+		format = WebhookFormatGeneric
+	}
+	if !slices.Contains(WebhookFormats, format) {
+		return nil, fmt.Errorf("unknown webhook format %q, must be one of: %v", format, WebhookFormats)
+	}
+	for _, eventType := range events {
+		if !slices.Contains(t.EventTypes, eventType) {
+			return nil, fmt.Errorf("unknown lock event type %q, must be one of: %v", eventType, t.EventTypes)
+		}
+	}
+
+	return &Webhook{
+		URL:      url,
+		Format:   format,
+		Events:   events,
+		Attempts: DefaultWebhookAttempts,
+		Backoff:  time.Second,
+		Client:   &http.Client{Timeout: DefaultWebhookTimeout},
+	}, nil
+}
+
+// ConfiguredWebhook returns the webhook set with the LOCK_WEBHOOK_URL,
+// LOCK_WEBHOOK_FORMAT and LOCK_WEBHOOK_EVENTS settings, or nil if no URL is set.
+// The events are a comma-separated list of event types.
+func ConfiguredWebhook() (*Webhook, error) {
+	url := viper.GetString("LOCK_WEBHOOK_URL")
+	if url == "" {
+		return nil, nil
+	}
+
+	var events []string
+	for _, eventType := range strings.Split(viper.GetString("LOCK_WEBHOOK_EVENTS"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			events = append(events, eventType)
+		}
+	}
+	return NewWebhook(url, viper.GetString("LOCK_WEBHOOK_FORMAT"), events)
+}
+
+// Record sends the notification of the event in the background, if its type is notified.
+func (w *Webhook) Record(ctx context.Context, event t.LockEvent) error {
+	events := w.Events
+	if len(events) == 0 {
+		events = DefaultWebhookEvents
+	}
+	if !slices.Contains(events, event.Type) {
+		return nil
+	}
+
+	body, err := w.payload(event)
+	if err != nil {
+		return err
+	}
+
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		if err := w.send(body); err != nil {
+			log.Warnf("Failed to notify the webhook of the %s event of the lock: %v", event.Type, err)
+		}
+	}()
+	return nil
+}
+
+// Wait waits until the pending notifications are sent, or dropped after their
+// last attempt, at most until ctx is done.
+func (w *Webhook) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for the webhook notifications: %w", ctx.Err())
+	}
+}
+
+// WaitTimeout waits for the pending notifications at most d, and logs a warning
+// if some of them were not sent. It does nothing on a nil webhook, so commands
+// can call it whether a webhook is configured or not.
+func (w *Webhook) WaitTimeout(d time.Duration) {
+	if w == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if err := w.Wait(ctx); err != nil {
+		log.Warnf("Some lock notifications were not sent: %v", err)
+	}
+}
+
+// payload renders the event in the format of the webhook.
+func (w *Webhook) payload(event t.LockEvent) ([]byte, error) {
+	if w.Format == WebhookFormatSlack {
+		return json.Marshal(map[string]string{"text": eventSummary(event)})
+	}
+	return json.Marshal(WebhookPayload{Text: eventSummary(event), Event: event})
+}
+
+// send posts the payload, retrying on network errors, throttling and server errors.
+func (w *Webhook) send(body []byte) error {
+	attempts := w.Attempts
+	if attempts <= 0 {
+		attempts = DefaultWebhookAttempts
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(Backoff(attempt-1, w.Backoff, 8*w.Backoff))
+		}
+
+		var retry bool
+		retry, err = w.post(body)
+		if err == nil || !retry {
+			return err
+		}
+		log.Debugf("Webhook notification failed (attempt %d of %d): %v", attempt+1, attempts, err)
+	}
+	return err
+}
+
+// post sends the payload once, and reports whether a failure is worth retrying.
+func (w *Webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook responded with %s", resp.Status)
+}
+
+// eventSummary describes the event in one line for the notification.
+func eventSummary(event t.LockEvent) string {
+	var action string
+	switch event.Type {
+	case t.EventAcquire:
+		action = "was acquired"
+	case t.EventRelease:
+		action = "was released"
+	case t.EventForceRelease:
+		action = "was force-released"
+	case t.EventTakeover:
+		action = "expired and was taken over"
+	case t.EventForceAcquire:
+		action = "was taken over by force"
+	case t.EventRenew:
+		action = "was renewed"
+	default:
+		action = event.Type
+	}
+
+	summary := fmt.Sprintf("Lock %s of %s %s by %s (lock ID: %s, signer: %s)", event.Key, event.Bucket, action, event.Actor, event.LockID, event.Signer)
+	if event.Lock != nil && event.Lock.PreviousOwner != nil {
+		previous := event.Lock.PreviousOwner
+		summary += fmt.Sprintf(", previously held by %s (signer: %s)", previous.LockID, previous.Signer)
+	}
+	if event.HeldFor != "" {
+		summary += ", held for " + event.HeldFor
+	}
+	if event.Reason != "" {
+		summary += ": " + event.Reason
+	}
+	return summary
+}
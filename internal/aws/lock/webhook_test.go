@@ -0,0 +1,209 @@
+package lock_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"statectl/internal/aws/lock"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer records the bodies posted to it, failing the first failures requests.
+type webhookServer struct {
+	mu       sync.Mutex
+	bodies   [][]byte
+	requests int
+	failures int
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.requests <= s.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	s.bodies = append(s.bodies, body)
+}
+
+func newTestWebhook(t *testing.T, url, format string, events ...string) *lock.Webhook {
+	t.Helper()
+	webhook, err := lock.NewWebhook(url, format, events)
+	if err != nil {
+		t.Fatalf("error creating webhook: %v", err)
+	}
+	webhook.Backoff = time.Millisecond
+	return webhook
+}
+
+func TestWebhookNotifiesForceRelease(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	server := &webhookServer{failures: 2}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	webhook := newTestWebhook(t, ts.URL, lock.WebhookFormatGeneric)
+	defer lock.AddRecorder(webhook)()
+
+	locker := lock.LocalLocker{Dir: t.TempDir()}
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+
+	// Call the function under test
+	if err := locker.ForceRelease(ctx, "test-bucket", "test-key", "stuck pipeline"); err != nil {
+		t.Fatalf("error force-releasing lock: %v", err)
+	}
+	if err := webhook.Wait(ctx); err != nil {
+		t.Fatalf("error waiting for the notifications: %v", err)
+	}
+
+	// Assertions
+	if len(server.bodies) != 2 {
+		t.Fatalf("expected 2 notifications after the retries, got %d in %d requests", len(server.bodies), server.requests)
+	}
+	payloads := map[string]lock.WebhookPayload{}
+	for _, body := range server.bodies {
+		payload := lock.WebhookPayload{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("error decoding notification %s: %v", body, err)
+		}
+		payloads[payload.Event.Type] = payload
+	}
+	forced, ok := payloads[st.EventForceRelease]
+	if !ok {
+		t.Fatalf("expected a force-release notification, got %v", payloads)
+	}
+	if forced.Event.Reason != "stuck pipeline" || forced.Event.LockID != lockInfo.LockID {
+		t.Errorf("expected the force-release of %s with its reason, got %+v", lockInfo.LockID, forced.Event)
+	}
+	if !strings.Contains(forced.Text, "force-released") || !strings.Contains(forced.Text, "stuck pipeline") {
+		t.Errorf("expected the summary to describe the force-release, got %q", forced.Text)
+	}
+	if forced.Event.Lock == nil || forced.Event.Lock.OwnerToken != "" {
+		t.Errorf("expected the lock without its owner token, got %+v", forced.Event.Lock)
+	}
+}
+
+func TestWebhookSlackFormatAndEvents(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	server := &webhookServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	webhook := newTestWebhook(t, ts.URL, lock.WebhookFormatSlack, st.EventTakeover)
+	defer lock.AddRecorder(webhook)()
+
+	locker := lock.LocalLocker{Dir: t.TempDir()}
+	expired, _ := test.CreateLockInfo("0987654321")
+	expired = expired.WithLease(time.Now().Add(-2*time.Hour), time.Hour)
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// Call the functions under test
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", expired); err != nil {
+		t.Fatalf("error acquiring lock: %v", err)
+	}
+	if _, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo); err != nil {
+		t.Fatalf("error taking over the lock: %v", err)
+	}
+	if err := webhook.Wait(ctx); err != nil {
+		t.Fatalf("error waiting for the notifications: %v", err)
+	}
+
+	// Assertions
+	if len(server.bodies) != 1 {
+		t.Fatalf("expected only the takeover to be notified, got %d notifications", len(server.bodies))
+	}
+	message := map[string]any{}
+	if err := json.Unmarshal(server.bodies[0], &message); err != nil {
+		t.Fatalf("error decoding notification: %v", err)
+	}
+	text, _ := message["text"].(string)
+	if len(message) != 1 || !strings.Contains(text, "expired") || !strings.Contains(text, "0987654321") {
+		t.Errorf("expected a Slack message about the takeover from 0987654321, got %s", server.bodies[0])
+	}
+}
+
+func TestWebhookNeverBlocksTheLock(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	webhook := newTestWebhook(t, ts.URL, "")
+	defer lock.AddRecorder(webhook)()
+
+	locker := lock.LocalLocker{Dir: t.TempDir()}
+	lockInfo, _ := test.CreateLockInfo("1234567890")
+
+	// Call the function under test
+	start := time.Now()
+	_, err := locker.Acquire(ctx, "test-bucket", "test-key", lockInfo)
+
+	// Assertions
+	if err != nil {
+		t.Fatalf("expected the lock to be acquired despite the webhook, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the lock not to wait for the webhook, took %s", elapsed)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := webhook.Wait(waitCtx); err == nil {
+		t.Errorf("expected Wait to give up while the webhook hangs")
+	}
+}
+
+func TestWebhookWaitTimeout(t *testing.T) {
+	// Set up
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	webhook := newTestWebhook(t, ts.URL, "")
+	event := st.LockEvent{Type: st.EventForceRelease, Bucket: "test-bucket", Key: "test-key"}
+	if err := webhook.Record(context.Background(), event); err != nil {
+		t.Fatalf("error recording the event: %v", err)
+	}
+
+	// Call the function under test
+	start := time.Now()
+	webhook.WaitTimeout(20 * time.Millisecond)
+	var unset *lock.Webhook
+	unset.WaitTimeout(time.Hour)
+
+	// Assertions
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected WaitTimeout to give up after its timeout, took %s", elapsed)
+	}
+}
+
+func TestNewWebhookValidates(t *testing.T) {
+	if _, err := lock.NewWebhook("http://localhost", "teams", nil); err == nil {
+		t.Errorf("expected an unknown format to be refused")
+	}
+	if _, err := lock.NewWebhook("http://localhost", "", []string{"stolen"}); err == nil {
+		t.Errorf("expected an unknown event type to be refused")
+	}
+}
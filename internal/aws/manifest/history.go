@@ -0,0 +1,166 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	t "statectl/internal/utils/types"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ErrRevisionNotFound is returned when no push of the manifest matches a revision.
+var ErrRevisionNotFound = errors.New("no push of the manifest matches the revision")
+
+// Revision selects an earlier push of the manifest. The first field set is used.
+type Revision struct {
+	// Snapshot is the ID of the snapshot of the push.
+	Snapshot string
+	// VersionID is the S3 version ID of the manifest object, or the ID of a snapshot.
+	VersionID string
+	// CommitSHA is the commit the manifest was pushed for, or a prefix of it.
+	CommitSHA string
+}
+
+// RevisionOfState returns the revision of the push recorded in the state file.
+func RevisionOfState(state t.State) Revision {
+	return Revision{Snapshot: state.Snapshot, VersionID: state.VersionID, CommitSHA: state.CommitSHA}
+}
+
+func (r Revision) String() string {
+	switch {
+	case r.Snapshot != "":
+		return "snapshot " + r.Snapshot
+	case r.VersionID != "":
+		return "version " + r.VersionID
+	default:
+		return "commit " + r.CommitSHA
+	}
+}
+
+// matches reports whether the snapshot was made by the push of the revision.
+func (r Revision) matches(snapshot t.Snapshot) bool {
+	switch {
+	case r.Snapshot != "":
+		return snapshot.ID == r.Snapshot
+	case r.VersionID != "":
+		return snapshot.ID == r.VersionID || snapshot.VersionID == r.VersionID
+	default:
+		return r.CommitSHA != "" && strings.HasPrefix(snapshot.CommitSHA, r.CommitSHA)
+	}
+}
+
+// ListSnapshots lists the snapshots of the manifest tree at root, oldest first.
+func ListSnapshots(ctx context.Context, cli t.S3Client, bucket, root string) ([]t.Snapshot, error) {
+	prefix := DefaultSnapshotPrefix(root)
+	paginator := s3.NewListObjectsV2Paginator(cli, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+
+	snapshots := []t.Snapshot{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			// Only the records of the snapshots sit next to their prefixes
+			name := strings.TrimPrefix(aws.ToString(object.Key), prefix)
+			if strings.Contains(name, "/") || !strings.HasSuffix(name, ".json") {
+				continue
+			}
+
+			snapshot, err := readSnapshot(ctx, cli, bucket, aws.ToString(object.Key))
+			if err != nil {
+				return nil, err
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+// readSnapshot reads the record of a snapshot.
+func readSnapshot(ctx context.Context, cli t.S3Client, bucket, key string) (t.Snapshot, error) {
+	resp, err := cli.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return t.Snapshot{}, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return t.Snapshot{}, err
+	}
+
+	snapshot := t.Snapshot{}
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("invalid snapshot record %s: %w", key, err)
+	}
+	return snapshot, nil
+}
+
+// FindSnapshot returns the latest snapshot of the manifest at keyPrefix made by
+// the push of the revision, looking at the single-file pushes of keyPrefix and
+// then at the tree it belongs to, like CurrentSnapshot. It returns false if no
+// snapshot matches.
+func FindSnapshot(ctx context.Context, cli t.S3Client, bucket, keyPrefix string, rev Revision) (bool, t.Snapshot, error) {
+	for _, root := range readRoots(keyPrefix) {
+		snapshots, err := ListSnapshots(ctx, cli, bucket, root)
+		if err != nil {
+			return false, t.Snapshot{}, fmt.Errorf("failed to list the snapshots of %s: %w", root, err)
+		}
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			if rev.matches(snapshots[i]) {
+				return true, snapshots[i], nil
+			}
+		}
+	}
+	return false, t.Snapshot{}, nil
+}
+
+// DownloadRevision downloads the manifest files under keyPrefix as they were
+// pushed at the revision, and returns the snapshot they were read from. A
+// version ID that matches no snapshot is read as a version of the object at
+// keyPrefix, pushed before snapshots were introduced; the empty snapshot is
+// then returned.
+func DownloadRevision(ctx context.Context, cli t.S3Client, bucket, keyPrefix string, rev Revision, localFolderPath string, concurrency int) (t.Snapshot, TransferSummary, error) {
+	exist, snapshot, err := FindSnapshot(ctx, cli, bucket, keyPrefix, rev)
+	if err != nil {
+		return t.Snapshot{}, TransferSummary{}, err
+	}
+	if exist {
+		log.Debugf("Downloading the snapshot %s of the %s", snapshot.ID, rev)
+		summary, err := downloadObjects(ctx, cli, bucket, snapshot.Prefix+keyPrefix, snapshot.Prefix, localFolderPath, concurrency)
+		return snapshot, summary, err
+	}
+
+	if rev.VersionID == "" {
+		return t.Snapshot{}, TransferSummary{}, fmt.Errorf("%w: %s", ErrRevisionNotFound, rev)
+	}
+
+	log.Debugf("No snapshot of the %s found, downloading the version of %s", rev, keyPrefix)
+	err = downloadObject(ctx, cli, &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(keyPrefix),
+		VersionId: aws.String(rev.VersionID),
+	}, filepath.Join(localFolderPath, filepath.FromSlash(keyPrefix)))
+	if errors.As(err, &KeyNotFound) || hasErrorCode(err, "NoSuchVersion") {
+		return t.Snapshot{}, TransferSummary{}, fmt.Errorf("%w: %s of %s", ErrRevisionNotFound, rev, keyPrefix)
+	}
+	if err != nil {
+		return t.Snapshot{}, TransferSummary{}, err
+	}
+	return t.Snapshot{}, TransferSummary{Transferred: 1}, nil
+}
@@ -0,0 +1,102 @@
+package manifest_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"statectl/internal/aws/manifest"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"testing"
+)
+
+func TestDownloadRevision(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	inTempDir(t, map[string]string{
+		"target/manifest.json":      `{"v":1}`,
+		"target/compiled/model.sql": "select 1",
+	})
+
+	first, _, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 0, st.BuildInfo{Commit: "1111111111111111"}, 4)
+	if err != nil {
+		t.Fatalf("error pushing the manifest: %v", err)
+	}
+	writeFile(t, "target/manifest.json", `{"v":2}`)
+	if _, _, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 0, st.BuildInfo{Commit: "2222222222222222"}, 4); err != nil {
+		t.Fatalf("error pushing the manifest again: %v", err)
+	}
+	if first.VersionID == "" {
+		t.Fatalf("expected the version of the manifest to be recorded, got %+v", first)
+	}
+
+	revisions := map[string]manifest.Revision{
+		"commit":   {CommitSHA: "11111111"},
+		"version":  {VersionID: first.VersionID},
+		"snapshot": {VersionID: first.ID},
+		"state":    manifest.RevisionOfState(st.State{VersionID: "unknown", Snapshot: first.ID}),
+	}
+	for name, rev := range revisions {
+		t.Run(name, func(t *testing.T) {
+			// Call the function under test
+			local := filepath.Join("pulled", name)
+			pulled, _, err := manifest.DownloadRevision(ctx, fakeS3, "test-bucket", "target/", rev, local, 4)
+
+			// Assertions
+			if err != nil {
+				t.Fatalf("error pulling the %s: %v", rev, err)
+			}
+			if pulled.ID != first.ID {
+				t.Errorf("expected the snapshot %s, got %s", first.ID, pulled.ID)
+			}
+			if content := readFile(t, filepath.Join(local, "target", "manifest.json")); content != `{"v":1}` {
+				t.Errorf("expected the manifest of the first push, got %s", content)
+			}
+			if content := readFile(t, filepath.Join(local, "target", "compiled", "model.sql")); content != "select 1" {
+				t.Errorf("expected the whole tree of the first push, got %s", content)
+			}
+		})
+	}
+}
+
+func TestDownloadRevisionWithoutSnapshot(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	inTempDir(t, nil)
+	putObject(t, fakeS3, "target/manifest.json", `{"v":"legacy"}`)
+	putObject(t, fakeS3, "target/manifest.json", `{"v":"latest"}`)
+	state := st.State{VersionID: "v1", CommitSHA: "1111111111111111", Key: "target/manifest.json"}
+
+	// Call the function under test
+	pulled, _, err := manifest.DownloadRevision(ctx, fakeS3, "test-bucket", state.Key, manifest.RevisionOfState(state), "pulled", 4)
+
+	// Assertions
+	if err != nil || pulled.ID != "" {
+		t.Fatalf("expected the legacy version to be pulled, got %+v (%v)", pulled, err)
+	}
+	if content := readFile(t, filepath.Join("pulled", "target", "manifest.json")); content != `{"v":"legacy"}` {
+		t.Errorf("expected the version of the state file, got %s", content)
+	}
+}
+
+func TestDownloadRevisionNotFound(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	inTempDir(t, map[string]string{"target/manifest.json": `{"v":1}`})
+	if _, _, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 0, st.BuildInfo{Commit: "1111111111111111"}, 4); err != nil {
+		t.Fatalf("error pushing the manifest: %v", err)
+	}
+
+	for _, rev := range []manifest.Revision{{CommitSHA: "3333333"}, {VersionID: "v999"}} {
+		// Call the function under test
+		_, _, err := manifest.DownloadRevision(ctx, fakeS3, "test-bucket", "target/manifest.json", rev, "pulled", 4)
+
+		// Assertions
+		if !errors.Is(err, manifest.ErrRevisionNotFound) {
+			t.Errorf("expected ErrRevisionNotFound for the %s, got: %v", rev, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultConcurrency is the number of files transferred at once when no
+// concurrency is set.
+const DefaultConcurrency = 8
+
+// outcome is the result of the work on one item of forEach.
+type outcome[R any] struct {
+	result R
+	err    error
+}
+
+// forEach runs fn on the items with at most concurrency workers at once, or
+// DefaultConcurrency if it is not positive. The results are handed to done in
+// the order of the items, from the calling goroutine, as soon as every earlier
+// item is finished, so the output of done does not depend on the scheduling.
+// The first error cancels the context of the running calls and skips the items
+// not started yet; the errors of the items are joined in their order.
+func forEach[T, R any](ctx context.Context, concurrency int, items []T, fn func(context.Context, T) (R, error), done func(T, R)) error {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make([]chan outcome[R], len(items))
+	for i := range outcomes {
+		outcomes[i] = make(chan outcome[R], 1)
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range items {
+			select {
+			case jobs <- i:
+			case <-workCtx.Done():
+				// Skip the items not started yet
+				for ; i < len(items); i++ {
+					outcomes[i] <- outcome[R]{err: workCtx.Err()}
+				}
+				return
+			}
+		}
+	}()
+
+	// The error that canceled the work, kept in case it reads as a cancellation
+	var first error
+	var once sync.Once
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(concurrency, len(items)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := workCtx.Err(); err != nil {
+					outcomes[i] <- outcome[R]{err: err}
+					continue
+				}
+				result, err := fn(workCtx, items[i])
+				if err != nil {
+					once.Do(func() { first = err })
+					cancel()
+				}
+				outcomes[i] <- outcome[R]{result: result, err: err}
+			}
+		}()
+	}
+	defer wg.Wait()
+
+	var errs []error
+	canceled := false
+	for i := range items {
+		o := <-outcomes[i]
+		switch {
+		case o.err == nil:
+			done(items[i], o.result)
+		case workCtx.Err() != nil && (errors.Is(o.err, context.Canceled) || errors.Is(o.err, context.DeadlineExceeded)):
+			// Only a consequence of the first error, or of ctx
+			canceled = true
+		default:
+			errs = append(errs, o.err)
+		}
+	}
+
+	if len(errs) == 0 && canceled {
+		wg.Wait()
+		if first != nil {
+			return first
+		}
+		return ctx.Err()
+	}
+	return errors.Join(errs...)
+}
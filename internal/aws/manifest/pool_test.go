@@ -0,0 +1,117 @@
+package manifest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"statectl/internal/aws/manifest"
+	"statectl/internal/logging"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// manyFiles returns the files of a tree with n compiled models.
+func manyFiles(n int) map[string]string {
+	files := map[string]string{"target/manifest.json": `{"v":1}`}
+	for i := 0; i < n; i++ {
+		files[fmt.Sprintf("target/compiled/model_%02d.sql", i)] = fmt.Sprintf("select %d", i)
+	}
+	return files
+}
+
+func TestConcurrentTransfersLogInOrder(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	inTempDir(t, manyFiles(30))
+
+	log := logging.GetLogger()
+	var output bytes.Buffer
+	level, out := log.GetLevel(), log.Out
+	log.SetLevel(logrus.DebugLevel)
+	log.SetOutput(&output)
+	defer func() {
+		log.SetLevel(level)
+		log.SetOutput(out)
+	}()
+
+	// Call the functions under test
+	snapshot, summary, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 0, st.BuildInfo{Commit: "1111111111111111"}, 8)
+	if err != nil {
+		t.Fatalf("error pushing the manifest: %v", err)
+	}
+	_, pulled, err := manifest.DownloadManifest(ctx, fakeS3, "test-bucket", "target/", "pulled", 8)
+	if err != nil {
+		t.Fatalf("error pulling the manifest: %v", err)
+	}
+
+	// Assertions
+	if snapshot.Files != 31 || summary.Transferred != 31 || pulled.Transferred != 31 || snapshot.VersionID == "" {
+		t.Errorf("expected the 31 files to be transferred, got %+v, %+v and %+v", snapshot, summary, pulled)
+	}
+	for _, action := range []string{"Uploaded", "Downloaded"} {
+		keys := regexp.MustCompile(action+` (\S+?)"?\n`).FindAllStringSubmatch(output.String(), -1)
+		logged := []string{}
+		for _, key := range keys {
+			logged = append(logged, key[1])
+		}
+		if len(logged) != 31 || !slices.IsSorted(logged) {
+			t.Errorf("expected the %s files to be logged in order, got %v", strings.ToLower(action), logged)
+		}
+	}
+}
+
+func TestConcurrentUploadStopsOnFirstError(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	inTempDir(t, manyFiles(30))
+	failing := &failingS3Client{FakeS3Client: fakeS3, fail: "target/compiled/model_00.sql"}
+
+	// Call the function under test
+	_, _, err := manifest.UploadManifest(ctx, failing, "test-bucket", "target/manifest.json", false, 0, st.BuildInfo{Commit: "1111111111111111"}, 2)
+
+	// Assertions
+	if !errors.Is(err, errUploadFailed) || !strings.Contains(err.Error(), "model_00.sql") {
+		t.Fatalf("expected the failed upload of model_00.sql, got: %v", err)
+	}
+	if strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("expected the canceled uploads not to be reported, got: %v", err)
+	}
+	if puts := failing.puts.Load(); puts > 4 {
+		t.Errorf("expected the remaining uploads to be canceled, got %d uploads of 31 files", puts)
+	}
+	if exist, _, _, _ := manifest.ReadPointer(ctx, fakeS3, "test-bucket", "target"); exist {
+		t.Errorf("expected the failed push not to become current")
+	}
+}
+
+var errUploadFailed = errors.New("upload failed")
+
+// failingS3Client fails the upload of one key, and counts the uploads.
+type failingS3Client struct {
+	*test.FakeS3Client
+	fail string
+	puts atomic.Int32
+}
+
+func (f *failingS3Client) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.puts.Add(1)
+	if strings.HasSuffix(aws.ToString(input.Key), f.fail) {
+		return nil, errUploadFailed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.FakeS3Client.PutObject(ctx, input, opts...)
+}
@@ -0,0 +1,238 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	t "statectl/internal/utils/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Changes of a file of the manifest tree made by a rollback
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// minCommitPrefix is the length of the shortest commit SHA prefix a rollback
+// target is read as; shorter numbers are a count of pushes to go back.
+const minCommitPrefix = 7
+
+// ErrNoSnapshot is returned when a rollback is asked for a manifest that was
+// never pushed as a snapshot.
+var ErrNoSnapshot = errors.New("the manifest was never pushed as a snapshot")
+
+// FileChange is a change of a file of the manifest tree made by a rollback.
+type FileChange struct {
+	Key    string
+	Change string
+}
+
+// RollbackPlan describes the restore of the manifest tree at Root from its
+// Current snapshot to the Target one.
+type RollbackPlan struct {
+	Root string
+	// Key of the manifest, which the version ID of the new snapshot is read from.
+	Key       string
+	Current   t.Snapshot
+	Target    t.Snapshot
+	Changes   []FileChange
+	Unchanged int
+
+	etag    string
+	objects []string
+}
+
+// PlanRollback plans the rollback of the manifest at keyPrefix to an earlier
+// push: the number of pushes to go back from the current snapshot, a snapshot
+// ID, the S3 version ID of the manifest, or a commit SHA (or a prefix of it).
+func PlanRollback(ctx context.Context, cli t.S3Client, bucket, keyPrefix, to string) (RollbackPlan, error) {
+	root, exist, current, etag, err := currentPointer(ctx, cli, bucket, keyPrefix)
+	if err != nil {
+		return RollbackPlan{}, fmt.Errorf("failed to read the current snapshot of the manifest: %w", err)
+	}
+	if !exist {
+		return RollbackPlan{}, fmt.Errorf("%w, it cannot be rolled back: %s", ErrNoSnapshot, keyPrefix)
+	}
+
+	target, err := rollbackTarget(ctx, cli, bucket, root, current, to)
+	if err != nil {
+		return RollbackPlan{}, err
+	}
+	if target.ID == current.ID {
+		return RollbackPlan{}, fmt.Errorf("the snapshot %s is already the current one", target.ID)
+	}
+
+	currentObjects, err := snapshotObjects(ctx, cli, bucket, current)
+	if err != nil {
+		return RollbackPlan{}, fmt.Errorf("failed to list the snapshot %s: %w", current.ID, err)
+	}
+	targetObjects, err := snapshotObjects(ctx, cli, bucket, target)
+	if err != nil {
+		return RollbackPlan{}, fmt.Errorf("failed to list the snapshot %s: %w", target.ID, err)
+	}
+
+	plan := RollbackPlan{Root: root, Key: keyPrefix, Current: current, Target: target, etag: etag}
+	for key, targetETag := range targetObjects {
+		plan.objects = append(plan.objects, key)
+		switch currentETag, ok := currentObjects[key]; {
+		case !ok:
+			plan.Changes = append(plan.Changes, FileChange{Key: key, Change: ChangeAdded})
+		case currentETag != targetETag:
+			plan.Changes = append(plan.Changes, FileChange{Key: key, Change: ChangeModified})
+		default:
+			plan.Unchanged++
+		}
+	}
+	for key := range currentObjects {
+		if _, ok := targetObjects[key]; !ok {
+			plan.Changes = append(plan.Changes, FileChange{Key: key, Change: ChangeRemoved})
+		}
+	}
+
+	slices.Sort(plan.objects)
+	slices.SortFunc(plan.Changes, func(a, b FileChange) int { return strings.Compare(a.Key, b.Key) })
+	return plan, nil
+}
+
+// rollbackTarget returns the snapshot of the tree at root that to selects.
+func rollbackTarget(ctx context.Context, cli t.S3Client, bucket, root string, current t.Snapshot, to string) (t.Snapshot, error) {
+	if n, err := strconv.Atoi(to); err == nil && len(to) < minCommitPrefix {
+		if n <= 0 {
+			return t.Snapshot{}, fmt.Errorf("invalid rollback target %q, the number of pushes to go back must be positive", to)
+		}
+
+		// Go back through the snapshots the pointer moved through
+		snapshot := current
+		for i := 0; i < n; i++ {
+			if snapshot.Previous == "" {
+				return t.Snapshot{}, fmt.Errorf("%w: only %d pushes before the snapshot %s", ErrRevisionNotFound, i, current.ID)
+			}
+			previous := snapshot.Previous
+			if snapshot, err = readSnapshot(ctx, cli, bucket, DefaultSnapshotPrefix(root)+previous+".json"); err != nil {
+				return t.Snapshot{}, fmt.Errorf("failed to read the snapshot %s: %w", previous, err)
+			}
+		}
+		return snapshot, nil
+	}
+
+	snapshots, err := ListSnapshots(ctx, cli, bucket, root)
+	if err != nil {
+		return t.Snapshot{}, fmt.Errorf("failed to list the snapshots of %s: %w", root, err)
+	}
+	for _, rev := range []Revision{{VersionID: to}, {CommitSHA: to}} {
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			if snapshots[i].ID != current.ID && rev.matches(snapshots[i]) {
+				return snapshots[i], nil
+			}
+		}
+	}
+	return t.Snapshot{}, fmt.Errorf("%w: no earlier snapshot, version or commit %s", ErrRevisionNotFound, to)
+}
+
+// snapshotObjects returns the ETags of the objects of the snapshot, by their
+// key without the snapshot prefix.
+func snapshotObjects(ctx context.Context, cli t.S3Client, bucket string, snapshot t.Snapshot) (map[string]string, error) {
+	paginator := s3.NewListObjectsV2Paginator(cli, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(snapshot.Prefix),
+	})
+
+	objects := map[string]string{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			objects[strings.TrimPrefix(aws.ToString(object.Key), snapshot.Prefix)] = aws.ToString(object.ETag)
+		}
+	}
+	return objects, nil
+}
+
+// Rollback restores the target snapshot of the plan as a new snapshot, copying
+// its objects on the server side, and makes it the current snapshot. The
+// snapshots in between are kept, so a rollback can itself be rolled back. It
+// fails with ErrPointerMoved if the manifest was pushed since it was planned.
+// The new snapshot records the build that runs the rollback, and the restored
+// one in Restores. At most concurrency objects are copied at once.
+func Rollback(ctx context.Context, cli t.S3Client, bucket string, plan RollbackPlan, fencingToken int64, build t.BuildInfo, concurrency int) (t.Snapshot, error) {
+	snapshot := NewSnapshot(plan.Root, plan.Target.CommitSHA, time.Now())
+	snapshot.FencingToken = fencingToken
+	snapshot.Previous = plan.Current.ID
+	snapshot.Restores = plan.Target.ID
+	if build != (t.BuildInfo{}) {
+		snapshot.Build = &build
+	}
+	log.Debugf("Restoring the snapshot %s to the snapshot %s", plan.Target.ID, snapshot.ID)
+
+	if err := checkNewSnapshot(ctx, cli, bucket, snapshot); err != nil {
+		return t.Snapshot{}, err
+	}
+
+	prefix := snapshot.Prefix
+	err := forEach(ctx, concurrency, plan.objects, func(ctx context.Context, key string) (string, error) {
+		metadata, err := rollbackMetadata(ctx, cli, bucket, plan.Target.Prefix+key, fencingToken)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		resp, err := cli.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(bucket),
+			Key:        aws.String(prefix + key),
+			CopySource: aws.String(url.PathEscape(bucket + "/" + plan.Target.Prefix + key)),
+			// The fencing token of the rollback replaces the one of the restored push
+			MetadataDirective: types.MetadataDirectiveReplace,
+			Metadata:          metadata,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to copy %s: %w", key, err)
+		}
+		return aws.ToString(resp.VersionId), nil
+	}, func(key, versionID string) {
+		log.Debugf("Copied %s", key)
+		if key == plan.Key {
+			snapshot.VersionID = versionID
+		}
+		snapshot.Files++
+	})
+	if err != nil {
+		return t.Snapshot{}, err
+	}
+
+	if err := commitSnapshot(ctx, cli, bucket, plan.Root, plan.etag, snapshot); err != nil {
+		return t.Snapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// rollbackMetadata returns the metadata of the object at key, with the fencing
+// token of the rollback in place of the one of the restored push.
+func rollbackMetadata(ctx context.Context, cli t.S3Client, bucket, key string, fencingToken int64) (map[string]string, error) {
+	resp, err := cli.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{}
+	for name, value := range resp.Metadata {
+		metadata[name] = value
+	}
+	delete(metadata, FencingTokenMetadata)
+	if fencingToken > 0 {
+		metadata[FencingTokenMetadata] = strconv.FormatInt(fencingToken, 10)
+	}
+	return metadata, nil
+}
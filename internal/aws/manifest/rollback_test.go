@@ -0,0 +1,157 @@
+package manifest_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"statectl/internal/aws/manifest"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"testing"
+	"time"
+)
+
+// pushTwice pushes a tree, then a second one that modifies, removes and adds a file.
+func pushTwice(t *testing.T, fakeS3 *test.FakeS3Client) (st.Snapshot, st.Snapshot) {
+	t.Helper()
+	ctx := context.Background()
+	inTempDir(t, map[string]string{
+		"target/manifest.json":  `{"v":1}`,
+		"target/compiled/a.sql": "select 1",
+	})
+
+	first, _, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 3, st.BuildInfo{Commit: "1111111111111111"}, 4)
+	if err != nil {
+		t.Fatalf("error pushing the manifest: %v", err)
+	}
+	writeFile(t, "target/manifest.json", `{"v":2}`)
+	writeFile(t, "target/compiled/b.sql", "select 2")
+	if err := os.Remove(filepath.Join("target", "compiled", "a.sql")); err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 4, st.BuildInfo{Commit: "2222222222222222"}, 4)
+	if err != nil {
+		t.Fatalf("error pushing the manifest again: %v", err)
+	}
+	// Snapshots of a commit are told apart by the millisecond they are made in
+	time.Sleep(2 * time.Millisecond)
+	return first, second
+}
+
+func TestRollback(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	first, second := pushTwice(t, fakeS3)
+
+	// Call the functions under test
+	plan, err := manifest.PlanRollback(ctx, fakeS3, "test-bucket", "target/manifest.json", "1")
+	if err != nil {
+		t.Fatalf("error planning the rollback: %v", err)
+	}
+	restored, err := manifest.Rollback(ctx, fakeS3, "test-bucket", plan, 5, st.BuildInfo{Commit: "3333333333333333", Actor: "release-bot"}, 4)
+	if err != nil {
+		t.Fatalf("error rolling back: %v", err)
+	}
+
+	// Assertions
+	wantChanges := []manifest.FileChange{
+		{Key: "target/compiled/a.sql", Change: manifest.ChangeAdded},
+		{Key: "target/compiled/b.sql", Change: manifest.ChangeRemoved},
+		{Key: "target/manifest.json", Change: manifest.ChangeModified},
+	}
+	if plan.Target.ID != first.ID || plan.Current.ID != second.ID || plan.Unchanged != 0 || !reflect.DeepEqual(plan.Changes, wantChanges) {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+	if restored.Restores != first.ID || restored.Previous != second.ID || restored.CommitSHA != first.CommitSHA || restored.Files != 2 || restored.VersionID == "" {
+		t.Errorf("unexpected restored snapshot %+v", restored)
+	}
+	if restored.Build == nil || restored.Build.Actor != "release-bot" || restored.Build.Commit != "3333333333333333" {
+		t.Errorf("expected the build of the rollback to be recorded, got %+v", restored.Build)
+	}
+
+	pulled, _, err := manifest.DownloadManifest(ctx, fakeS3, "test-bucket", "target/", "pulled", 4)
+	if err != nil || pulled.ID != restored.ID {
+		t.Fatalf("expected the restored snapshot to be current, got %+v (%v)", pulled, err)
+	}
+	if content := readFile(t, filepath.Join("pulled", "target", "manifest.json")); content != `{"v":1}` {
+		t.Errorf("expected the manifest of the first push, got %s", content)
+	}
+	if _, err := os.Stat(filepath.Join("pulled", "target", "compiled", "b.sql")); !os.IsNotExist(err) {
+		t.Errorf("expected the file added by the second push to be gone, got: %v", err)
+	}
+	if _, ok := fakeS3.Object(second.Prefix + "target/manifest.json"); !ok {
+		t.Errorf("expected the rolled back snapshot to be kept")
+	}
+
+	// The rollback itself can be rolled back
+	plan, err = manifest.PlanRollback(ctx, fakeS3, "test-bucket", "target/manifest.json", "1")
+	if err != nil || plan.Target.ID != second.ID {
+		t.Errorf("expected to go back to the snapshot %s, got %+v (%v)", second.ID, plan.Target, err)
+	}
+}
+
+func TestPlanRollbackTargets(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	first, _ := pushTwice(t, fakeS3)
+
+	for _, to := range []string{"11111111", first.ID, first.VersionID} {
+		// Call the function under test
+		plan, err := manifest.PlanRollback(ctx, fakeS3, "test-bucket", "target/manifest.json", to)
+
+		// Assertions
+		if err != nil || plan.Target.ID != first.ID {
+			t.Errorf("expected --to %s to select the snapshot %s, got %+v (%v)", to, first.ID, plan.Target, err)
+		}
+	}
+
+	for _, to := range []string{"2", "2222222", "0"} {
+		if _, err := manifest.PlanRollback(ctx, fakeS3, "test-bucket", "target/manifest.json", to); err == nil {
+			t.Errorf("expected --to %s to be refused", to)
+		}
+	}
+}
+
+func TestRollbackWithoutSnapshot(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	putObject(t, fakeS3, "target/manifest.json", `{"v":"legacy"}`)
+
+	// Call the function under test
+	_, err := manifest.PlanRollback(ctx, fakeS3, "test-bucket", "target/manifest.json", "1")
+
+	// Assertions
+	if !errors.Is(err, manifest.ErrNoSnapshot) {
+		t.Errorf("expected ErrNoSnapshot, got: %v", err)
+	}
+}
+
+func TestRollbackPointerMoved(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	pushTwice(t, fakeS3)
+
+	plan, err := manifest.PlanRollback(ctx, fakeS3, "test-bucket", "target/manifest.json", "1")
+	if err != nil {
+		t.Fatalf("error planning the rollback: %v", err)
+	}
+	// Another push lands between the plan and the rollback
+	writeFile(t, "target/manifest.json", `{"v":3}`)
+	if _, _, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 5, st.BuildInfo{Commit: "3333333333333333"}, 4); err != nil {
+		t.Fatalf("error pushing the manifest: %v", err)
+	}
+
+	// Call the function under test
+	_, err = manifest.Rollback(ctx, fakeS3, "test-bucket", plan, 6, st.BuildInfo{}, 4)
+
+	// Assertions
+	if !errors.Is(err, manifest.ErrPointerMoved) {
+		t.Errorf("expected ErrPointerMoved, got: %v", err)
+	}
+}
@@ -5,35 +5,51 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"statectl/internal/logging"
 	"statectl/internal/utils/fs"
 	t "statectl/internal/utils/types"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+var log = logging.GetLogger()
+
 // ListManifests lists all manifest files within a specified folder in an S3 bucket.
-func ListManifests(ctx context.Context, cli *s3.Client, bucket, prefix string) (map[string]interface{}, error) {
+// The files of the current snapshot are listed if the manifest was pushed as snapshots.
+func ListManifests(ctx context.Context, cli t.S3Client, bucket, prefix string) (map[string]interface{}, error) {
 	const fileIndicator = "<file>"
 
 	prefix = fs.GetTopLevelDir(prefix)
 
+	exist, snapshot, err := CurrentSnapshot(ctx, cli, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
 	resp, err := cli.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
+		Prefix: aws.String(snapshot.Prefix + prefix),
 	})
 	if err != nil {
 		return nil, err
 	}
+	if exist {
+		log.Debugf("Listing the snapshot %s", snapshot.ID)
+	}
 
 	temp := make(map[string]interface{})
 
 	for _, item := range resp.Contents {
-		key := *item.Key
+		key := strings.TrimPrefix(*item.Key, snapshot.Prefix)
 		if key == prefix {
 			// Skip the prefix itself
 			continue
@@ -66,73 +82,154 @@ func ListManifests(ctx context.Context, cli *s3.Client, bucket, prefix string) (
 	return tree, nil
 }
 
-// DownloadManifest downloads a specific manifest file from an S3 bucket.
-func DownloadManifest(ctx context.Context, cli *s3.Client, bucket, keyPrefix, localFolderPath string) error {
+// DownloadManifest downloads the manifest files under keyPrefix from an S3 bucket.
+// The files are read from the current snapshot of the manifest, which is
+// returned, or from keyPrefix itself if the manifest was never pushed as a snapshot.
+// Local files that already have the content of their object are not downloaded,
+// and at most concurrency files are downloaded at once.
+func DownloadManifest(ctx context.Context, cli t.S3Client, bucket, keyPrefix, localFolderPath string, concurrency int) (t.Snapshot, TransferSummary, error) {
+	exist, snapshot, err := CurrentSnapshot(ctx, cli, bucket, keyPrefix)
+	if err != nil {
+		return t.Snapshot{}, TransferSummary{}, fmt.Errorf("failed to read the current snapshot of the manifest: %w", err)
+	}
+	if !exist {
+		log.Debug("No snapshot of the manifest found, downloading the latest objects")
+	}
+
+	summary, err := downloadObjects(ctx, cli, bucket, snapshot.Prefix+keyPrefix, snapshot.Prefix, localFolderPath, concurrency)
+	return snapshot, summary, err
+}
+
+// downloadObjects downloads the objects under prefix to localFolderPath, at their
+// key without the snapshot prefix, skipping the files that are unchanged. The
+// local files under the prefix that are not objects of it are counted as stale.
+func downloadObjects(ctx context.Context, cli t.S3Client, bucket, prefix, snapshotPrefix, localFolderPath string, concurrency int) (TransferSummary, error) {
 	paginator := s3.NewListObjectsV2Paginator(cli, &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
-		Prefix: aws.String(keyPrefix),
+		Prefix: aws.String(prefix),
 	})
 
+	objects := []types.Object{}
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return err
+			return TransferSummary{}, err
 		}
+		objects = append(objects, page.Contents...)
+	}
 
-		for _, object := range page.Contents {
-			// Strip the keyPrefix from the S3 object key
-			relativePath := strings.TrimPrefix(*object.Key, keyPrefix)
+	localPath := func(key string) string {
+		return filepath.Join(localFolderPath, filepath.FromSlash(strings.TrimPrefix(key, snapshotPrefix)))
+	}
+	pulled := map[string]bool{}
+	for _, object := range objects {
+		pulled[localPath(aws.ToString(object.Key))] = true
+	}
 
-			// Ensure the relative path does not start with a slash
-			relativePath = strings.TrimLeft(relativePath, "/")
+	summary := TransferSummary{}
+	err := forEach(ctx, concurrency, objects, func(ctx context.Context, object types.Object) (bool, error) {
+		return downloadIfChanged(ctx, cli, bucket, aws.ToString(object.Key), aws.ToString(object.ETag), localPath(aws.ToString(object.Key)))
+	}, func(object types.Object, skipped bool) {
+		if skipped {
+			log.Debugf("Skipped %s, the local file is unchanged", aws.ToString(object.Key))
+			summary.Skipped++
+		} else {
+			log.Debugf("Downloaded %s", aws.ToString(object.Key))
+			summary.Transferred++
+		}
+	})
+	if err != nil {
+		return summary, err
+	}
 
-			// Join the localFolderPath with the relativePath
-			outputPath := filepath.Join(localFolderPath, keyPrefix, relativePath)
+	summary.Stale, err = countStaleFiles(localPath(prefix), pulled)
+	return summary, err
+}
 
-			// Create any directories as needed
-			if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-				return err
-			}
+// countStaleFiles counts the files under the local directory root that were not
+// pulled. The files that are never pushed are not counted.
+func countStaleFiles(root string, pulled map[string]bool) (int, error) {
+	info, err := os.Stat(root)
+	if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	stale := 0
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || ignoreFile(path) || pulled[path] {
+			return nil
 		}
+		log.Debugf("Kept %s, it is not in the pulled manifest", path)
+		stale++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to look for stale files under %s: %w", root, err)
+	}
+	return stale, nil
+}
 
-		for _, object := range page.Contents {
-			outputPath := filepath.Join(localFolderPath, *object.Key)
+// downloadIfChanged downloads the object at key, listed with etag, to outputPath
+// unless the file there already has its content. It reports whether the
+// download was skipped.
+func downloadIfChanged(ctx context.Context, cli t.S3Client, bucket, key, etag, outputPath string) (bool, error) {
+	hash, err := hashFile(outputPath)
+	if err == nil {
+		unchanged, err := hash.matches(ctx, cli, bucket, key, etag)
+		if err != nil {
+			return false, fmt.Errorf("failed to compare %s: %w", key, err)
+		}
+		if unchanged {
+			return true, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
 
-			// Create any directories as needed
-			if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-				return err
-			}
+	err = downloadObject(ctx, cli, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, outputPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	return false, nil
+}
 
-			// Create the local file
-			file, err := os.Create(outputPath)
-			if err != nil {
-				return err
-			}
+// downloadObject downloads the object of the input to outputPath.
+func downloadObject(ctx context.Context, cli t.S3Client, input *s3.GetObjectInput, outputPath string) error {
+	// Get the object from S3
+	output, err := cli.GetObject(ctx, input)
+	if err != nil {
+		return err
+	}
+	defer output.Body.Close()
 
-			// Get the object from S3
-			output, err := cli.GetObject(ctx, &s3.GetObjectInput{
-				Bucket: aws.String(bucket),
-				Key:    object.Key,
-			})
-			if err != nil {
-				file.Close() // Close the file if we can't download
-				return err
-			}
+	// Create any directories as needed
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
 
-			// Write to the local file
-			if _, err := io.Copy(file, output.Body); err != nil {
-				file.Close() // Close the file if we can't copy
-				return err
-			}
+	// Create the local file
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
 
-			// Close the file
-			if err := file.Close(); err != nil {
-				return err
-			}
-		}
+	// Write to the local file
+	if _, err := io.Copy(file, output.Body); err != nil {
+		file.Close() // Close the file if we can't copy
+		return err
 	}
 
-	return nil
+	// Close the file
+	return file.Close()
 }
 
 func ignoreFile(filename string) bool {
@@ -152,9 +249,96 @@ func ignoreFile(filename string) bool {
 	return false
 }
 
-// UploadManifest uploads a manifest file to an S3 bucket.
-func UploadManifest(ctx context.Context, cli *s3.Client, bucket, localFolderPath string, singleFile bool) error {
+// FencingTokenMetadata is the metadata key the fencing token of the push is stored under.
+const FencingTokenMetadata = "fencing-token"
+
+// UploadManifest uploads the manifest files to a new snapshot in an S3 bucket,
+// and makes it the current snapshot once every file is uploaded. The objects of
+// a snapshot are never overwritten. The files that are unchanged since the
+// current snapshot are copied from it on the server side instead of uploaded,
+// and at most concurrency files are transferred at once.
+// The fencing token of the lock the manifest is pushed with, if any, and the
+// checksum of the content are recorded in the metadata of every object.
+func UploadManifest(ctx context.Context, cli t.S3Client, bucket, localFolderPath string, singleFile bool, fencingToken int64, build t.BuildInfo, concurrency int) (t.Snapshot, TransferSummary, error) {
+	commitSHA, err := CommitSHA(build)
+	if err != nil {
+		return t.Snapshot{}, TransferSummary{}, err
+	}
+
+	manifestKey := strings.Trim(filepath.ToSlash(localFolderPath), "/")
+	root := Root(localFolderPath, singleFile)
+	exist, current, etag, err := ReadPointer(ctx, cli, bucket, root)
+	if err != nil {
+		return t.Snapshot{}, TransferSummary{}, fmt.Errorf("failed to read the current snapshot of the manifest: %w", err)
+	}
+
+	snapshot := NewSnapshot(root, commitSHA, time.Now())
+	snapshot.FencingToken = fencingToken
+	if build != (t.BuildInfo{}) {
+		snapshot.Build = &build
+	}
+	if err := checkNewSnapshot(ctx, cli, bucket, snapshot); err != nil {
+		return t.Snapshot{}, TransferSummary{}, err
+	}
+	log.Debugf("Pushing the manifest to the snapshot %s", snapshot.ID)
+
+	// The files of the current snapshot, which unchanged files are copied from
+	previous := map[string]string{}
+	if exist {
+		snapshot.Previous = current.ID
+		if previous, err = snapshotObjects(ctx, cli, bucket, current); err != nil {
+			return t.Snapshot{}, TransferSummary{}, fmt.Errorf("failed to list the snapshot %s: %w", current.ID, err)
+		}
+	}
+
+	files, err := localFiles(localFolderPath, singleFile)
+	if err != nil {
+		return t.Snapshot{}, TransferSummary{}, err
+	}
+
+	summary := TransferSummary{Deleted: len(previous)}
+	for _, file := range files {
+		if _, ok := previous[file.key]; ok {
+			summary.Deleted--
+		}
+	}
+
+	prefix := snapshot.Prefix
+	err = forEach(ctx, concurrency, files, func(ctx context.Context, file localFile) (uploaded, error) {
+		return uploadFile(ctx, cli, bucket, prefix, current, previous, file, fencingToken)
+	}, func(file localFile, result uploaded) {
+		if result.skipped {
+			log.Debugf("Copied %s, unchanged since the snapshot %s", file.key, current.ID)
+			summary.Skipped++
+		} else {
+			log.Debugf("Uploaded %s", file.key)
+			summary.Transferred++
+		}
+		if file.key == manifestKey {
+			snapshot.VersionID = result.versionID
+		}
+		snapshot.Files++
+	})
+	if err != nil {
+		return t.Snapshot{}, summary, err
+	}
+
+	if err := commitSnapshot(ctx, cli, bucket, root, etag, snapshot); err != nil {
+		return t.Snapshot{}, summary, err
+	}
+	return snapshot, summary, nil
+}
+
+// localFile is a file of the manifest tree, with the key it is pushed to.
+type localFile struct {
+	path string
+	key  string
+}
 
+// localFiles lists the files of the manifest tree of localFolderPath, in
+// lexical order: every file under its top-level directory, or the file itself
+// for a single-file push.
+func localFiles(localFolderPath string, singleFile bool) ([]localFile, error) {
 	// Get the top-level directory from the localFolderPath
 	if !singleFile {
 		localFolderPath = fs.GetTopLevelDir(localFolderPath)
@@ -163,13 +347,14 @@ func UploadManifest(ctx context.Context, cli *s3.Client, bucket, localFolderPath
 	// Trim the localFolderPath to ensure it ends with a separator
 	// and remove it from the path to get the correct key structure
 	if isDir, err := fs.IsDir(localFolderPath); err != nil {
-		return err
+		return nil, err
 	} else if isDir {
 		localFolderPath = strings.TrimRight(localFolderPath, string(filepath.Separator)) + string(filepath.Separator)
 	} else {
 		localFolderPath = strings.TrimRight(localFolderPath, string(filepath.Separator))
 	}
 
+	files := []localFile{}
 	err := filepath.Walk(localFolderPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -186,49 +371,124 @@ func UploadManifest(ctx context.Context, cli *s3.Client, bucket, localFolderPath
 		// Replace OS-specific path separators with '/'
 		key = strings.ReplaceAll(key, string(filepath.Separator), "/")
 
-		// Open the file
-		file, err := os.Open(path)
+		files = append(files, localFile{path: path, key: key})
+		return nil
+	})
+	return files, err
+}
+
+// uploaded is the outcome of the push of a file.
+type uploaded struct {
+	// versionID is the version ID of the object of the file in the new snapshot.
+	versionID string
+	// skipped is set if the file was unchanged, and copied instead of uploaded.
+	skipped bool
+}
+
+// uploadFile pushes the file to the snapshot at prefix: it is copied from the
+// current snapshot if it is listed in previous with its ETag and is unchanged,
+// and uploaded otherwise.
+func uploadFile(ctx context.Context, cli t.S3Client, bucket, prefix string, current t.Snapshot, previous map[string]string, file localFile, fencingToken int64) (uploaded, error) {
+	hash, err := hashFile(file.path)
+	if err != nil {
+		return uploaded{}, err
+	}
+
+	metadata := map[string]string{ChecksumMetadata: hash.sha256}
+	if fencingToken > 0 {
+		metadata[FencingTokenMetadata] = strconv.FormatInt(fencingToken, 10)
+	}
+
+	if previousETag, ok := previous[file.key]; ok {
+		unchanged, err := hash.matches(ctx, cli, bucket, current.Prefix+file.key, previousETag)
 		if err != nil {
-			return err
+			return uploaded{}, fmt.Errorf("failed to compare %s: %w", file.key, err)
+		}
+		if unchanged {
+			resp, err := cli.CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:            aws.String(bucket),
+				Key:               aws.String(prefix + file.key),
+				CopySource:        aws.String(url.PathEscape(bucket + "/" + current.Prefix + file.key)),
+				MetadataDirective: types.MetadataDirectiveReplace,
+				Metadata:          metadata,
+			})
+			if err != nil {
+				return uploaded{}, fmt.Errorf("failed to copy %s: %w", file.key, err)
+			}
+			return uploaded{versionID: aws.ToString(resp.VersionId), skipped: true}, nil
 		}
-		defer file.Close()
+	}
 
-		// Upload the file to S3
-		_, err = cli.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-			Body:   file,
-		})
-		return err
+	// Open the file
+	body, err := os.Open(file.path)
+	if err != nil {
+		return uploaded{}, err
+	}
+	defer body.Close()
+
+	// Upload the file to the snapshot, which is never overwritten
+	resp, err := cli.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(prefix + file.key),
+		Body:        body,
+		IfNoneMatch: aws.String("*"),
+		Metadata:    metadata,
 	})
+	if err != nil {
+		return uploaded{}, fmt.Errorf("failed to upload %s: %w", file.key, err)
+	}
+	return uploaded{versionID: aws.ToString(resp.VersionId)}, nil
+}
+
+// CommitSHA returns the commit SHA of the build, or the local git commit SHA.
+func CommitSHA(build t.BuildInfo) (string, error) {
+	if build.Commit != "" {
+		return build.Commit, nil
+	}
 
-	return err
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get git commit SHA: %w", err)
+	}
+	return strings.Trim(string(output), "\n"), nil
 }
 
-func CreateStateJSON(ctx context.Context, cli *s3.Client, bucket, key, filePath string) error {
+// CreateStateJSON writes the state of the manifest uploaded to the snapshot to
+// filePath, using the commit SHA of the build or the local git commit SHA.
+func CreateStateJSON(ctx context.Context, cli t.S3Client, bucket, key, filePath string, build t.BuildInfo, snapshot t.Snapshot) error {
 	// Get the version ID from S3
 	resp, err := cli.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+		Key:    aws.String(snapshot.Prefix + key),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to get object head: %w", err)
 	}
 
-	// Get the current commit SHA from Git
-	cmd := exec.Command("git", "rev-parse", "HEAD")
-	output, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to get git commit SHA: %w", err)
+	// Get the current commit SHA from the snapshot, the build, or Git
+	commitSHA := snapshot.CommitSHA
+	if commitSHA == "" {
+		if commitSHA, err = CommitSHA(build); err != nil {
+			return err
+		}
 	}
-	commitSHA := strings.Trim(string(output), "\n")
 
 	// Create the state structure
 	state := t.State{
-		VersionID: *resp.VersionId,
+		VersionID: aws.ToString(resp.VersionId),
 		CommitSHA: commitSHA,
 		Bucket:    bucket,
 		Key:       key,
+		Snapshot:  snapshot.ID,
+	}
+	if build != (t.BuildInfo{}) {
+		state.Build = &build
+	}
+	if token, ok := resp.Metadata[FencingTokenMetadata]; ok {
+		if state.FencingToken, err = strconv.ParseInt(token, 10, 64); err != nil {
+			return fmt.Errorf("invalid fencing token %q in the manifest metadata: %w", token, err)
+		}
 	}
 
 	// Marshal into JSON
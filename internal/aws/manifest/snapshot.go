@@ -0,0 +1,203 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"statectl/internal/utils/fs"
+	t "statectl/internal/utils/types"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// snapshotTimeFormat sorts lexicographically in chronological order, so the
+// snapshots are listed oldest first.
+const snapshotTimeFormat = "20060102T150405.000Z"
+
+// ErrPointerMoved is returned when another push moved the current pointer
+// while the snapshot was uploaded.
+var ErrPointerMoved = errors.New("the current snapshot of the manifest was changed by another push")
+
+var KeyNotFound *types.NoSuchKey
+
+// DefaultSnapshotPrefix returns the prefix the snapshots of the manifest tree at
+// root are stored under, one sub-prefix per snapshot.
+func DefaultSnapshotPrefix(root string) string {
+	return root + ".snapshots/"
+}
+
+// DefaultPointerKey returns the key of the pointer to the current snapshot of the
+// manifest tree at root.
+func DefaultPointerKey(root string) string {
+	return root + ".current"
+}
+
+// Root returns the root of the manifest tree pushed for manifestPath: its
+// top-level directory, or the file itself for a single-file push.
+func Root(manifestPath string, singleFile bool) string {
+	if singleFile {
+		return strings.Trim(filepath.ToSlash(manifestPath), "/")
+	}
+	return fs.GetTopLevelDir(manifestPath)
+}
+
+// NewSnapshot describes a new snapshot of the manifest tree at root for the
+// commit. The ID of the snapshot starts with its creation time, so snapshots
+// sort in the order they were pushed.
+func NewSnapshot(root, commitSHA string, now time.Time) t.Snapshot {
+	short := commitSHA
+	if len(short) > 12 {
+		short = short[:12]
+	}
+	if short == "" {
+		short = "unknown"
+	}
+
+	id := now.UTC().Format(snapshotTimeFormat) + "-" + short
+	return t.Snapshot{
+		ID:        id,
+		Prefix:    DefaultSnapshotPrefix(root) + id + "/",
+		CommitSHA: commitSHA,
+		CreatedAt: now.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// ReadPointer reads the current pointer of the manifest tree at root, together
+// with its ETag to move it conditionally.
+func ReadPointer(ctx context.Context, cli t.S3Client, bucket, root string) (bool, t.Snapshot, string, error) {
+	resp, err := cli.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(DefaultPointerKey(root)),
+	})
+	if err != nil {
+		if errors.As(err, &KeyNotFound) {
+			return false, t.Snapshot{}, "", nil // No snapshot was pushed yet.
+		}
+		return false, t.Snapshot{}, "", err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, t.Snapshot{}, "", err
+	}
+
+	snapshot := t.Snapshot{}
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return false, snapshot, "", fmt.Errorf("invalid pointer %s: %w", DefaultPointerKey(root), err)
+	}
+	return true, snapshot, aws.ToString(resp.ETag), nil
+}
+
+// CurrentSnapshot returns the current snapshot the manifest at keyPrefix is read
+// from: the one of the single-file pushes of keyPrefix, or else the one of the
+// tree keyPrefix belongs to. It returns false if neither was pushed as a snapshot.
+func CurrentSnapshot(ctx context.Context, cli t.S3Client, bucket, keyPrefix string) (bool, t.Snapshot, error) {
+	_, exist, snapshot, _, err := currentPointer(ctx, cli, bucket, keyPrefix)
+	return exist, snapshot, err
+}
+
+// readRoots returns the roots the manifest at keyPrefix may be read from: the
+// single-file pushes of keyPrefix first, then the tree it belongs to.
+func readRoots(keyPrefix string) []string {
+	roots := []string{Root(keyPrefix, true)}
+	if top := Root(keyPrefix, false); top != roots[0] {
+		roots = append(roots, top)
+	}
+	return roots
+}
+
+// currentPointer returns the root the manifest at keyPrefix is read from, with
+// its current pointer and the ETag of the pointer, like CurrentSnapshot.
+func currentPointer(ctx context.Context, cli t.S3Client, bucket, keyPrefix string) (string, bool, t.Snapshot, string, error) {
+	for _, root := range readRoots(keyPrefix) {
+		exist, snapshot, etag, err := ReadPointer(ctx, cli, bucket, root)
+		if err != nil || exist {
+			return root, exist, snapshot, etag, err
+		}
+	}
+	return "", false, t.Snapshot{}, "", nil
+}
+
+// checkNewSnapshot makes sure no snapshot was recorded with the ID of the new
+// one. Server-side copies cannot be made conditional, so they would overwrite
+// the objects of a snapshot of the same commit made in the same millisecond.
+func checkNewSnapshot(ctx context.Context, cli t.S3Client, bucket string, snapshot t.Snapshot) error {
+	_, err := cli.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(strings.TrimSuffix(snapshot.Prefix, "/") + ".json"),
+	})
+	if err == nil {
+		return fmt.Errorf("the snapshot %s already exists, retry in a moment", snapshot.ID)
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("failed to check the snapshot %s: %w", snapshot.ID, err)
+	}
+	return nil
+}
+
+// commitSnapshot records the uploaded snapshot and moves the current pointer of
+// the tree at root to it, unless the pointer was moved since it was read with
+// etag, or created if etag is empty. The pointer is a single object, so readers
+// see either the previous snapshot or the new one, never a partial tree.
+func commitSnapshot(ctx context.Context, cli t.S3Client, bucket, root, etag string, snapshot t.Snapshot) error {
+	raw, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The record of the snapshot next to its objects is kept after the pointer moves on
+	if _, err := cli.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(strings.TrimSuffix(snapshot.Prefix, "/") + ".json"),
+		Body:        strings.NewReader(string(raw)),
+		IfNoneMatch: aws.String("*"),
+	}); err != nil {
+		return fmt.Errorf("failed to record the snapshot %s: %w", snapshot.ID, err)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(DefaultPointerKey(root)),
+		Body:   strings.NewReader(string(raw)),
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
+	_, err = cli.PutObject(ctx, input)
+	if isPreconditionFailed(err) {
+		return fmt.Errorf("%w, the snapshot %s was uploaded but is not current", ErrPointerMoved, snapshot.ID)
+	}
+	return err
+}
+
+// isPreconditionFailed reports whether a conditional write was refused.
+func isPreconditionFailed(err error) bool {
+	return hasErrorCode(err, "PreconditionFailed", "ConditionalRequestConflict")
+}
+
+// hasErrorCode reports whether err is an S3 error with one of the codes.
+func hasErrorCode(err error, codes ...string) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return slices.Contains(codes, apiErr.ErrorCode())
+}
+
+// isNotFound reports whether the object of a request does not exist.
+func isNotFound(err error) bool {
+	var notFound *types.NotFound
+	return errors.As(err, &notFound) || errors.As(err, &KeyNotFound)
+}
@@ -0,0 +1,199 @@
+package manifest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"statectl/internal/aws/manifest"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// inTempDir runs the test in a new directory with the given files, as the
+// manifest is pushed from paths relative to the working directory.
+func inTempDir(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for path, content := range files {
+		writeFile(t, filepath.Join(dir, path), content)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", path, err)
+	}
+	return string(content)
+}
+
+func TestUploadManifestSnapshots(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	inTempDir(t, map[string]string{
+		"target/manifest.json":      `{"v":1}`,
+		"target/compiled/model.sql": "select 1",
+	})
+
+	// Call the functions under test
+	first, _, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 7, st.BuildInfo{Commit: "1111111111111111"}, 4)
+	if err != nil {
+		t.Fatalf("error pushing the manifest: %v", err)
+	}
+	writeFile(t, "target/manifest.json", `{"v":2}`)
+	second, _, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 8, st.BuildInfo{Commit: "2222222222222222"}, 4)
+	if err != nil {
+		t.Fatalf("error pushing the manifest again: %v", err)
+	}
+
+	// Assertions
+	if first.Files != 2 || !strings.HasPrefix(first.Prefix, "target.snapshots/") || !strings.HasSuffix(first.ID, "-111111111111") {
+		t.Errorf("unexpected first snapshot %+v", first)
+	}
+	if second.Previous != first.ID || second.FencingToken != 8 {
+		t.Errorf("expected the second snapshot to follow %s, got %+v", first.ID, second)
+	}
+	if body, _ := fakeS3.Object(first.Prefix + "target/manifest.json"); string(body) != `{"v":1}` {
+		t.Errorf("expected the first snapshot to be kept, got %s", body)
+	}
+
+	exist, current, _, err := manifest.ReadPointer(ctx, fakeS3, "test-bucket", "target")
+	if err != nil || !exist || current.ID != second.ID {
+		t.Errorf("expected the pointer to move to %s, got %+v (%v)", second.ID, current, err)
+	}
+	if keys := fakeS3.Keys("target/"); len(keys) != 0 {
+		t.Errorf("expected no object outside the snapshots, got %v", keys)
+	}
+}
+
+func TestDownloadManifestFollowsPointer(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	inTempDir(t, map[string]string{"target/manifest.json": `{"v":1}`})
+
+	snapshot, _, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 0, st.BuildInfo{Commit: "1111111111111111"}, 4)
+	if err != nil {
+		t.Fatalf("error pushing the manifest: %v", err)
+	}
+	// An upload that never finished is not visible to readers
+	putObject(t, fakeS3, "target.snapshots/99999999T999999.999Z-partial/target/manifest.json", `{"v":"partial"}`)
+
+	// Call the function under test
+	pulled, _, err := manifest.DownloadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", "pulled", 4)
+
+	// Assertions
+	if err != nil {
+		t.Fatalf("error pulling the manifest: %v", err)
+	}
+	if pulled.ID != snapshot.ID {
+		t.Errorf("expected the snapshot %s to be pulled, got %s", snapshot.ID, pulled.ID)
+	}
+	if content := readFile(t, filepath.Join("pulled", "target", "manifest.json")); content != `{"v":1}` {
+		t.Errorf("expected the current manifest, got %s", content)
+	}
+}
+
+func TestDownloadManifestWithoutSnapshot(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	inTempDir(t, nil)
+	putObject(t, fakeS3, "target/manifest.json", `{"v":"legacy"}`)
+
+	// Call the function under test
+	pulled, _, err := manifest.DownloadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", "pulled", 4)
+
+	// Assertions
+	if err != nil || pulled.ID != "" {
+		t.Fatalf("expected the legacy manifest to be pulled, got %+v (%v)", pulled, err)
+	}
+	if content := readFile(t, filepath.Join("pulled", "target", "manifest.json")); content != `{"v":"legacy"}` {
+		t.Errorf("expected the legacy manifest, got %s", content)
+	}
+}
+
+func TestUploadManifestPointerMoved(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	inTempDir(t, map[string]string{"target/manifest.json": `{"v":1}`})
+
+	// Another push moves the pointer while this one uploads its files
+	racing := &racingS3Client{FakeS3Client: fakeS3, pointer: manifest.DefaultPointerKey("target")}
+
+	// Call the function under test
+	_, _, err := manifest.UploadManifest(ctx, racing, "test-bucket", "target/manifest.json", false, 0, st.BuildInfo{Commit: "1111111111111111"}, 4)
+
+	// Assertions
+	if !errors.Is(err, manifest.ErrPointerMoved) {
+		t.Fatalf("expected ErrPointerMoved, got: %v", err)
+	}
+	body, _ := fakeS3.Object(manifest.DefaultPointerKey("target"))
+	current := st.Snapshot{}
+	if err := json.Unmarshal(body, &current); err != nil || current.ID != "other" {
+		t.Errorf("expected the pointer of the other push to be kept, got %s", body)
+	}
+}
+
+// racingS3Client moves the pointer on the first upload of a snapshot file.
+type racingS3Client struct {
+	*test.FakeS3Client
+	pointer string
+	race    sync.Once
+}
+
+func (r *racingS3Client) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var err error
+	r.race.Do(func() {
+		_, err = r.FakeS3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("test-bucket"),
+			Key:    aws.String(r.pointer),
+			Body:   strings.NewReader(`{"id":"other"}`),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.FakeS3Client.PutObject(ctx, input, opts...)
+}
+
+func putObject(t *testing.T, fakeS3 *test.FakeS3Client, key, body string) {
+	t.Helper()
+	if _, err := fakeS3.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(body),
+	}); err != nil {
+		t.Fatalf("error writing %s: %v", key, err)
+	}
+}
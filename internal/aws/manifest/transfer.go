@@ -0,0 +1,76 @@
+package manifest
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	t "statectl/internal/utils/types"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ChecksumMetadata is the metadata key the SHA-256 digest of the content of a
+// pushed file is stored under.
+const ChecksumMetadata = "content-sha256"
+
+// TransferSummary counts the files of a push or a pull by what was done with them.
+type TransferSummary struct {
+	// Transferred files were uploaded or downloaded.
+	Transferred int
+	// Skipped files were unchanged: a push copies them on the server side from
+	// the current snapshot, and a pull keeps the local file.
+	Skipped int
+	// Deleted files of the current snapshot are not in the pushed one.
+	Deleted int
+	// Stale local files are not in the pulled snapshot. A pull never deletes
+	// them, since they may be local work that was not pushed yet.
+	Stale int
+}
+
+// contentHash holds the digests of the content of a file, hex-encoded.
+type contentHash struct {
+	md5    string
+	sha256 string
+}
+
+// hashFile returns the digests of the content of the file at path.
+func hashFile(path string) (contentHash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return contentHash{}, err
+	}
+	defer file.Close()
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), file); err != nil {
+		return contentHash{}, err
+	}
+	return contentHash{md5: hex.EncodeToString(md5Hash.Sum(nil)), sha256: hex.EncodeToString(sha256Hash.Sum(nil))}, nil
+}
+
+// matches reports whether the object at key, listed with etag, has the content.
+// The ETag is the MD5 digest of the content of most objects, but not of the
+// ones uploaded in parts or encrypted with KMS, so when it differs the checksum
+// stored in the metadata of the object is compared instead.
+func (h contentHash) matches(ctx context.Context, cli t.S3Client, bucket, key, etag string) (bool, error) {
+	if strings.Trim(etag, `"`) == h.md5 {
+		return true, nil
+	}
+
+	resp, err := cli.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return resp.Metadata[ChecksumMetadata] == h.sha256, nil
+}
@@ -0,0 +1,151 @@
+package manifest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"statectl/internal/aws/manifest"
+	st "statectl/internal/utils/types"
+	"statectl/test"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestUploadManifestSkipsUnchangedFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		multipart bool
+	}{
+		{name: "etag"},
+		{name: "checksum", multipart: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Set up
+			ctx := context.Background()
+			fakeS3 := test.NewFakeS3Client()
+			pushTwice(t, fakeS3)
+			writeFile(t, "target/compiled/c.sql", "select 3")
+
+			var cli st.S3Client = fakeS3
+			if tt.multipart {
+				cli = &multipartS3Client{FakeS3Client: fakeS3}
+			}
+
+			// Call the function under test
+			snapshot, summary, err := manifest.UploadManifest(ctx, cli, "test-bucket", "target/manifest.json", false, 0, st.BuildInfo{Commit: "3333333333333333"}, 4)
+
+			// Assertions
+			if err != nil {
+				t.Fatalf("error pushing the manifest: %v", err)
+			}
+			if summary != (manifest.TransferSummary{Transferred: 1, Skipped: 2}) || snapshot.Files != 3 {
+				t.Errorf("expected c.sql to be uploaded and the others skipped, got %+v for %d files", summary, snapshot.Files)
+			}
+			if body, _ := fakeS3.Object(snapshot.Prefix + "target/compiled/b.sql"); string(body) != "select 2" {
+				t.Errorf("expected the skipped file to be copied to the snapshot, got %q", body)
+			}
+			head, err := fakeS3.HeadObject(ctx, &s3.HeadObjectInput{Key: aws.String(snapshot.Prefix + "target/manifest.json")})
+			if err != nil || head.Metadata[manifest.ChecksumMetadata] == "" {
+				t.Errorf("expected the checksum of the copied file in its metadata, got %v (%v)", head, err)
+			}
+			if snapshot.VersionID == "" {
+				t.Errorf("expected the version of the copied manifest to be recorded")
+			}
+		})
+	}
+}
+
+func TestUploadManifestDeletesRemovedFiles(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	_, second := pushTwice(t, fakeS3)
+	if err := os.Remove(filepath.Join("target", "compiled", "b.sql")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Call the function under test
+	snapshot, summary, err := manifest.UploadManifest(ctx, fakeS3, "test-bucket", "target/manifest.json", false, 0, st.BuildInfo{Commit: "3333333333333333"}, 4)
+
+	// Assertions
+	if err != nil || summary != (manifest.TransferSummary{Skipped: 1, Deleted: 1}) {
+		t.Fatalf("expected b.sql to be deleted, got %+v (%v)", summary, err)
+	}
+	if _, ok := fakeS3.Object(snapshot.Prefix + "target/compiled/b.sql"); ok {
+		t.Errorf("expected b.sql not to be carried over to the snapshot")
+	}
+	if _, ok := fakeS3.Object(second.Prefix + "target/compiled/b.sql"); !ok {
+		t.Errorf("expected b.sql to be kept in the previous snapshot")
+	}
+}
+
+func TestDownloadManifestSkipsUnchangedFiles(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	pushTwice(t, fakeS3)
+	if _, _, err := manifest.DownloadManifest(ctx, fakeS3, "test-bucket", "target/", "pulled", 4); err != nil {
+		t.Fatalf("error pulling the manifest: %v", err)
+	}
+	writeFile(t, filepath.Join("pulled", "target", "manifest.json"), `{"v":"edited"}`)
+
+	// Call the function under test
+	_, summary, err := manifest.DownloadManifest(ctx, &multipartS3Client{FakeS3Client: fakeS3}, "test-bucket", "target/", "pulled", 4)
+
+	// Assertions
+	if err != nil {
+		t.Fatalf("error pulling the manifest again: %v", err)
+	}
+	if summary != (manifest.TransferSummary{Transferred: 1, Skipped: 1}) {
+		t.Errorf("expected only the edited manifest to be downloaded, got %+v", summary)
+	}
+	if content := readFile(t, filepath.Join("pulled", "target", "manifest.json")); content != `{"v":2}` {
+		t.Errorf("expected the edited manifest to be restored, got %s", content)
+	}
+}
+
+// multipartS3Client lists the objects with the ETags of multipart uploads, which
+// are not the MD5 digest of their content.
+type multipartS3Client struct {
+	*test.FakeS3Client
+}
+
+func (m *multipartS3Client) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	resp, err := m.FakeS3Client.ListObjectsV2(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Contents {
+		resp.Contents[i].ETag = aws.String(`"0123456789abcdef0123456789abcdef-2"`)
+	}
+	return resp, nil
+}
+
+func TestDownloadManifestKeepsStaleFiles(t *testing.T) {
+	// Set up
+	ctx := context.Background()
+	fakeS3 := test.NewFakeS3Client()
+	pushTwice(t, fakeS3)
+	if _, _, err := manifest.DownloadManifest(ctx, fakeS3, "test-bucket", "target/", "pulled", 4); err != nil {
+		t.Fatalf("error pulling the manifest: %v", err)
+	}
+	writeFile(t, filepath.Join("pulled", "target", "compiled", "removed.sql"), "select 1")
+
+	// Call the function under test
+	_, summary, err := manifest.DownloadManifest(ctx, fakeS3, "test-bucket", "target/", "pulled", 4)
+
+	// Assertions
+	if err != nil {
+		t.Fatalf("error pulling the manifest again: %v", err)
+	}
+	if summary != (manifest.TransferSummary{Skipped: 2, Stale: 1}) {
+		t.Errorf("expected the file missing from the snapshot to be reported as stale, got %+v", summary)
+	}
+	if content := readFile(t, filepath.Join("pulled", "target", "compiled", "removed.sql")); content != "select 1" {
+		t.Errorf("expected the stale file to be kept, got %s", content)
+	}
+}
@@ -3,7 +3,9 @@ package utils
 import (
 	"context"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go/aws/session"
 )
@@ -26,3 +28,18 @@ func GetS3Client() *s3.Client {
 
 	return s3.NewFromConfig(cfg)
 }
+
+// GetDynamoDBClient returns a DynamoDB client, sending the requests to endpoint
+// if it is set, e.g. to use DynamoDB Local.
+func GetDynamoDBClient(endpoint string) *dynamodb.Client {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("configuration error, " + err.Error())
+	}
+
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+}
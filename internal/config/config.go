@@ -18,6 +18,10 @@ var Version string
 var log = logging.GetLogger()
 
 func Initialize() {
+	// Defaults of the settings that are on unless disabled, set first so they
+	// hold even when the config cannot be read
+	viper.SetDefault("MANIFEST_REQUIRE_LOCK", true)
+
 	// 1. From the current path (last priority, where the binary is executed)
 	viper.AddConfigPath(".")
 	viper.SetConfigName("config") // no need to include file extension
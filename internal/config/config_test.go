@@ -17,3 +17,15 @@ func TestInitializeConfig(t *testing.T) {
 	}
 	viper.Reset()
 }
+
+func TestInitializeConfigWithoutHome(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv("HOME", "")
+
+	config.Initialize()
+
+	if !viper.GetBool("MANIFEST_REQUIRE_LOCK") {
+		t.Errorf("expected the manifest lock to be required without a home directory")
+	}
+}
@@ -0,0 +1,158 @@
+package ci
+
+import (
+	"os"
+	"statectl/internal/logging"
+	t "statectl/internal/utils/types"
+	"strings"
+)
+
+var log = logging.GetLogger()
+
+// Names of the supported CI providers
+const (
+	GitHub    = "github"
+	GitLab    = "gitlab"
+	Jenkins   = "jenkins"
+	CircleCI  = "circleci"
+	Buildkite = "buildkite"
+	Azure     = "azure"
+)
+
+// Provider reads the build info of a CI provider from its environment variables.
+type Provider struct {
+	Name string
+	// Detect reports whether statectl runs on this provider.
+	Detect func(getenv func(string) string) bool
+	// Build reads the build info from the provider's variables.
+	Build func(getenv func(string) string) t.BuildInfo
+}
+
+// Providers lists the supported CI providers, in detection order.
+var Providers = []Provider{
+	{
+		Name:   GitHub,
+		Detect: func(getenv func(string) string) bool { return getenv("GITHUB_ACTIONS") == "true" },
+		Build: func(getenv func(string) string) t.BuildInfo {
+			// GITHUB_HEAD_REF is only set on pull requests, where GITHUB_REF_NAME is the merge ref
+			branch := getenv("GITHUB_HEAD_REF")
+			if branch == "" {
+				branch = getenv("GITHUB_REF_NAME")
+			}
+
+			jobURL := ""
+			if server, repo, run := getenv("GITHUB_SERVER_URL"), getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID"); server != "" && repo != "" && run != "" {
+				jobURL = server + "/" + repo + "/actions/runs/" + run
+			}
+
+			return t.BuildInfo{
+				Commit:     getenv("GITHUB_SHA"),
+				Branch:     branch,
+				PipelineID: getenv("GITHUB_RUN_ID"),
+				JobURL:     jobURL,
+				Actor:      getenv("GITHUB_ACTOR"),
+			}
+		},
+	},
+	{
+		Name:   Jenkins,
+		Detect: func(getenv func(string) string) bool { return getenv("JENKINS_URL") != "" },
+		Build: func(getenv func(string) string) t.BuildInfo {
+			// BRANCH_NAME is set by multibranch pipelines, GIT_BRANCH by the git plugin
+			branch := getenv("BRANCH_NAME")
+			if branch == "" {
+				branch = strings.TrimPrefix(getenv("GIT_BRANCH"), "origin/")
+			}
+
+			return t.BuildInfo{
+				Commit:     getenv("GIT_COMMIT"),
+				Branch:     branch,
+				PipelineID: getenv("BUILD_NUMBER"),
+				JobURL:     getenv("BUILD_URL"),
+				Actor:      getenv("BUILD_USER_ID"),
+			}
+		},
+	},
+	{
+		Name:   CircleCI,
+		Detect: func(getenv func(string) string) bool { return getenv("CIRCLECI") == "true" },
+		Build: func(getenv func(string) string) t.BuildInfo {
+			return t.BuildInfo{
+				Commit:     getenv("CIRCLE_SHA1"),
+				Branch:     getenv("CIRCLE_BRANCH"),
+				PipelineID: getenv("CIRCLE_WORKFLOW_ID"),
+				JobURL:     getenv("CIRCLE_BUILD_URL"),
+				Actor:      getenv("CIRCLE_USERNAME"),
+			}
+		},
+	},
+	{
+		Name:   Buildkite,
+		Detect: func(getenv func(string) string) bool { return getenv("BUILDKITE") == "true" },
+		Build: func(getenv func(string) string) t.BuildInfo {
+			return t.BuildInfo{
+				Commit:     getenv("BUILDKITE_COMMIT"),
+				Branch:     getenv("BUILDKITE_BRANCH"),
+				PipelineID: getenv("BUILDKITE_BUILD_NUMBER"),
+				JobURL:     getenv("BUILDKITE_BUILD_URL"),
+				Actor:      getenv("BUILDKITE_BUILD_CREATOR"),
+			}
+		},
+	},
+	{
+		Name:   Azure,
+		Detect: func(getenv func(string) string) bool { return strings.EqualFold(getenv("TF_BUILD"), "true") },
+		Build: func(getenv func(string) string) t.BuildInfo {
+			jobURL := ""
+			if collection, project, build := getenv("SYSTEM_COLLECTIONURI"), getenv("SYSTEM_TEAMPROJECT"), getenv("BUILD_BUILDID"); collection != "" && project != "" && build != "" {
+				jobURL = strings.TrimRight(collection, "/") + "/" + project + "/_build/results?buildId=" + build
+			}
+
+			return t.BuildInfo{
+				Commit:     getenv("BUILD_SOURCEVERSION"),
+				Branch:     getenv("BUILD_SOURCEBRANCHNAME"),
+				PipelineID: getenv("BUILD_BUILDID"),
+				JobURL:     jobURL,
+				Actor:      getenv("BUILD_REQUESTEDFOR"),
+			}
+		},
+	},
+	{
+		// GitLab is detected last, as CI_COMMIT_SHA alone was always enough to
+		// identify a GitLab pipeline
+		Name: GitLab,
+		Detect: func(getenv func(string) string) bool {
+			return getenv("GITLAB_CI") == "true" || getenv("CI_COMMIT_SHA") != ""
+		},
+		Build: func(getenv func(string) string) t.BuildInfo {
+			return t.BuildInfo{
+				Commit:     getenv("CI_COMMIT_SHA"),
+				Branch:     getenv("CI_COMMIT_REF_NAME"),
+				PipelineID: getenv("CI_PIPELINE_IID"),
+				JobURL:     getenv("CI_JOB_URL"),
+				Actor:      getenv("GITLAB_USER_LOGIN"),
+			}
+		},
+	},
+}
+
+// Detect returns the build info of the CI provider statectl runs on. Outside of
+// a supported CI provider, the build info is empty.
+func Detect() t.BuildInfo {
+	return DetectFrom(os.Getenv)
+}
+
+// DetectFrom returns the build info of the first provider detected from getenv.
+func DetectFrom(getenv func(string) string) t.BuildInfo {
+	for _, p := range Providers {
+		if !p.Detect(getenv) {
+			continue
+		}
+		build := p.Build(getenv)
+		build.Provider = p.Name
+		log.Debugf("Detected CI provider %s: %+v", p.Name, build)
+		return build
+	}
+	log.Debug("No CI provider detected")
+	return t.BuildInfo{}
+}
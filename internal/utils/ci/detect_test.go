@@ -0,0 +1,111 @@
+package ci_test
+
+import (
+	"statectl/internal/utils/ci"
+	st "statectl/internal/utils/types"
+	"testing"
+)
+
+func TestDetectFrom(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected st.BuildInfo
+	}{
+		{
+			name: "github",
+			env: map[string]string{
+				"GITHUB_ACTIONS":    "true",
+				"GITHUB_SHA":        "abc123",
+				"GITHUB_REF_NAME":   "main",
+				"GITHUB_RUN_ID":     "42",
+				"GITHUB_SERVER_URL": "https://github.com",
+				"GITHUB_REPOSITORY": "org/repo",
+				"GITHUB_ACTOR":      "alice",
+			},
+			expected: st.BuildInfo{Provider: ci.GitHub, Commit: "abc123", Branch: "main", PipelineID: "42", JobURL: "https://github.com/org/repo/actions/runs/42", Actor: "alice"},
+		},
+		{
+			name: "jenkins",
+			env: map[string]string{
+				"JENKINS_URL":  "https://jenkins.example.com/",
+				"GIT_COMMIT":   "abc123",
+				"GIT_BRANCH":   "origin/main",
+				"BUILD_NUMBER": "7",
+				"BUILD_URL":    "https://jenkins.example.com/job/dbt/7/",
+			},
+			expected: st.BuildInfo{Provider: ci.Jenkins, Commit: "abc123", Branch: "main", PipelineID: "7", JobURL: "https://jenkins.example.com/job/dbt/7/"},
+		},
+		{
+			name: "circleci",
+			env: map[string]string{
+				"CIRCLECI":           "true",
+				"CIRCLE_SHA1":        "abc123",
+				"CIRCLE_BRANCH":      "main",
+				"CIRCLE_WORKFLOW_ID": "wf-1",
+				"CIRCLE_BUILD_URL":   "https://circleci.com/gh/org/repo/1",
+				"CIRCLE_USERNAME":    "alice",
+			},
+			expected: st.BuildInfo{Provider: ci.CircleCI, Commit: "abc123", Branch: "main", PipelineID: "wf-1", JobURL: "https://circleci.com/gh/org/repo/1", Actor: "alice"},
+		},
+		{
+			name: "buildkite",
+			env: map[string]string{
+				"BUILDKITE":               "true",
+				"BUILDKITE_COMMIT":        "abc123",
+				"BUILDKITE_BRANCH":        "main",
+				"BUILDKITE_BUILD_NUMBER":  "3",
+				"BUILDKITE_BUILD_URL":     "https://buildkite.com/org/dbt/builds/3",
+				"BUILDKITE_BUILD_CREATOR": "Alice",
+			},
+			expected: st.BuildInfo{Provider: ci.Buildkite, Commit: "abc123", Branch: "main", PipelineID: "3", JobURL: "https://buildkite.com/org/dbt/builds/3", Actor: "Alice"},
+		},
+		{
+			name: "azure",
+			env: map[string]string{
+				"TF_BUILD":               "True",
+				"BUILD_SOURCEVERSION":    "abc123",
+				"BUILD_SOURCEBRANCHNAME": "main",
+				"BUILD_BUILDID":          "99",
+				"SYSTEM_COLLECTIONURI":   "https://dev.azure.com/org/",
+				"SYSTEM_TEAMPROJECT":     "data",
+				"BUILD_REQUESTEDFOR":     "Alice",
+			},
+			expected: st.BuildInfo{Provider: ci.Azure, Commit: "abc123", Branch: "main", PipelineID: "99", JobURL: "https://dev.azure.com/org/data/_build/results?buildId=99", Actor: "Alice"},
+		},
+		{
+			name: "gitlab",
+			env: map[string]string{
+				"CI_COMMIT_SHA":      "abc123",
+				"CI_COMMIT_REF_NAME": "main",
+				"CI_PIPELINE_IID":    "5",
+				"CI_JOB_URL":         "https://gitlab.com/org/repo/-/jobs/1",
+				"GITLAB_USER_LOGIN":  "alice",
+			},
+			expected: st.BuildInfo{Provider: ci.GitLab, Commit: "abc123", Branch: "main", PipelineID: "5", JobURL: "https://gitlab.com/org/repo/-/jobs/1", Actor: "alice"},
+		},
+		{
+			name:     "none",
+			env:      map[string]string{},
+			expected: st.BuildInfo{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			build := ci.DetectFrom(func(name string) string { return tt.env[name] })
+			if build != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, build)
+			}
+		})
+	}
+}
+
+func TestMergeOverride(t *testing.T) {
+	build := st.BuildInfo{Provider: ci.GitHub, Commit: "abc123", PipelineID: "42"}
+
+	merged := build.Merge(st.BuildInfo{Commit: "def456", Actor: "bob"})
+	if merged.Commit != "def456" || merged.Actor != "bob" || merged.PipelineID != "42" || merged.Provider != ci.GitHub {
+		t.Errorf("unexpected merged build info: %+v", merged)
+	}
+}
@@ -0,0 +1,21 @@
+package ci
+
+import (
+	t "statectl/internal/utils/types"
+
+	"github.com/spf13/pflag"
+)
+
+// AddFlags adds the flags overriding the detected build info to a command.
+func AddFlags(flags *pflag.FlagSet, override *t.BuildInfo) {
+	flags.StringVar(&override.Commit, "commit", "", "commit SHA of the build (overrides the CI provider's variables)")
+	flags.StringVar(&override.Branch, "branch", "", "branch of the build (overrides the CI provider's variables)")
+	flags.StringVar(&override.PipelineID, "pipeline-id", "", "pipeline ID of the build (overrides the CI provider's variables)")
+	flags.StringVar(&override.JobURL, "job-url", "", "URL of the CI job (overrides the CI provider's variables)")
+	flags.StringVar(&override.Actor, "actor", "", "user who triggered the build (overrides the CI provider's variables)")
+}
+
+// Resolve returns the detected build info with the non-empty fields of override applied.
+func Resolve(override t.BuildInfo) t.BuildInfo {
+	return Detect().Merge(override)
+}
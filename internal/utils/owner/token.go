@@ -0,0 +1,142 @@
+package owner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"statectl/internal/logging"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+// DefaultDir is where the owner tokens are stored when LOCK_TOKEN_DIR is not set.
+const DefaultDir = ".statectl"
+
+// TokenEnv is the environment variable that overrides the stored owner token,
+// e.g. to release a lock from another job than the one that acquired it.
+const TokenEnv = "STATECTL_LOCK_TOKEN"
+
+// FencingTokenEnv is the environment variable that overrides the stored fencing
+// token, e.g. to push the manifest from another job than the one that acquired the lock.
+const FencingTokenEnv = "STATECTL_FENCING_TOKEN"
+
+var log = logging.GetLogger()
+var ErrNoToken = errors.New("no owner token found for this lock")
+
+// Lease is what this workspace keeps about a lock it acquired.
+type Lease struct {
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	Token        string `json:"token"`
+	FencingToken int64  `json:"fencing_token,omitempty"`
+	AcquiredAt   string `json:"acquired_at"`
+}
+
+// NewToken returns a new owner token, unique to one acquisition of a lock.
+func NewToken() string {
+	return uuid.New().String()
+}
+
+// Path returns the file the lease of the lock at bucket/key is stored in.
+func Path(bucket, key string) string {
+	dir := viper.GetString("LOCK_TOKEN_DIR")
+	if dir == "" {
+		dir = DefaultDir
+	}
+	return filepath.Join(dir, "locks", bucket, filepath.FromSlash(key)+".json")
+}
+
+// Save stores the lease of an acquired lock, so it can be released or renewed later
+// and the state can be written with its fencing token.
+func Save(bucket, key, token string, fencingToken int64) (string, error) {
+	lease := Lease{
+		Bucket:       bucket,
+		Key:          key,
+		Token:        token,
+		FencingToken: fencingToken,
+		AcquiredAt:   time.Now().Format(time.RFC3339),
+	}
+
+	raw, err := json.MarshalIndent(lease, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := Path(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		return "", fmt.Errorf("failed to save owner token: %w", err)
+	}
+	log.Debugf("Owner token saved to %s", path)
+	return path, nil
+}
+
+// Token returns the owner token of the lock at bucket/key. The STATECTL_LOCK_TOKEN
+// environment variable takes precedence over the stored lease.
+func Token(bucket, key string) (string, error) {
+	if token := viper.GetString(TokenEnv); token != "" {
+		return token, nil
+	}
+
+	lease, err := load(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if lease.Token == "" {
+		return "", ErrNoToken
+	}
+	return lease.Token, nil
+}
+
+// FencingToken returns the fencing token of the lock at bucket/key. The
+// STATECTL_FENCING_TOKEN environment variable takes precedence over the stored lease.
+func FencingToken(bucket, key string) (int64, error) {
+	if viper.IsSet(FencingTokenEnv) {
+		token := viper.GetInt64(FencingTokenEnv)
+		if token <= 0 {
+			return 0, fmt.Errorf("invalid %s: %q", FencingTokenEnv, viper.GetString(FencingTokenEnv))
+		}
+		return token, nil
+	}
+
+	lease, err := load(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	if lease.FencingToken == 0 {
+		return 0, ErrNoToken
+	}
+	return lease.FencingToken, nil
+}
+
+// load reads the stored lease of the lock at bucket/key.
+func load(bucket, key string) (Lease, error) {
+	raw, err := os.ReadFile(Path(bucket, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return Lease{}, ErrNoToken
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+
+	lease := Lease{}
+	if err := json.Unmarshal(raw, &lease); err != nil {
+		return Lease{}, fmt.Errorf("failed to read owner token: %w", err)
+	}
+	return lease, nil
+}
+
+// Remove deletes the stored lease of the lock at bucket/key, if any.
+func Remove(bucket, key string) error {
+	err := os.Remove(Path(bucket, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
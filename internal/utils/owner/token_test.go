@@ -0,0 +1,63 @@
+package owner_test
+
+import (
+	"errors"
+	"statectl/internal/utils/owner"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSaveAndLoadToken(t *testing.T) {
+	viper.Set("LOCK_TOKEN_DIR", t.TempDir())
+	defer viper.Reset()
+
+	token := owner.NewToken()
+	if _, err := owner.Save("test-bucket", "locks/state.lock", token, 7); err != nil {
+		t.Fatalf("error saving owner token: %v", err)
+	}
+
+	loaded, err := owner.Token("test-bucket", "locks/state.lock")
+	if err != nil {
+		t.Fatalf("error loading owner token: %v", err)
+	}
+	if loaded != token {
+		t.Errorf("expected token %s, got %s", token, loaded)
+	}
+
+	fencingToken, err := owner.FencingToken("test-bucket", "locks/state.lock")
+	if err != nil {
+		t.Fatalf("error loading fencing token: %v", err)
+	}
+	if fencingToken != 7 {
+		t.Errorf("expected fencing token 7, got %d", fencingToken)
+	}
+
+	if err := owner.Remove("test-bucket", "locks/state.lock"); err != nil {
+		t.Fatalf("error removing owner token: %v", err)
+	}
+	if _, err := owner.Token("test-bucket", "locks/state.lock"); !errors.Is(err, owner.ErrNoToken) {
+		t.Errorf("expected ErrNoToken after removal, got: %v", err)
+	}
+}
+
+func TestTokenFromEnv(t *testing.T) {
+	viper.Set("LOCK_TOKEN_DIR", t.TempDir())
+	t.Setenv(owner.TokenEnv, "from-env")
+	viper.AutomaticEnv()
+	defer viper.Reset()
+
+	token, err := owner.Token("test-bucket", "locks/state.lock")
+	if err != nil {
+		t.Fatalf("error loading owner token: %v", err)
+	}
+	if token != "from-env" {
+		t.Errorf("expected token from environment, got %s", token)
+	}
+}
+
+func TestTokensAreUnique(t *testing.T) {
+	if owner.NewToken() == owner.NewToken() {
+		t.Errorf("expected two acquisitions to get different owner tokens")
+	}
+}
@@ -3,14 +3,13 @@ package subproc
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"os/exec"
 
 	"statectl/internal/logging"
+	"statectl/internal/utils/ci"
 	t "statectl/internal/utils/types"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -18,10 +17,6 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 )
 
-type LockInfo struct {
-	LockID string `json:"lock_id"`
-}
-
 var KeyNotFound *types.NoSuchKey
 var log = logging.GetLogger()
 
@@ -57,8 +52,7 @@ func FetchRemoteSHA(ctx context.Context, cli t.S3Client, bucket, key string) (st
 		return "", err
 	}
 
-	lockInfo := LockInfo{}
-	err = json.Unmarshal(lockInfoRaw, &lockInfo)
+	lockInfo, err := t.DecodeLockInfo(lockInfoRaw)
 	if err != nil {
 		return "", err
 	}
@@ -67,22 +61,23 @@ func FetchRemoteSHA(ctx context.Context, cli t.S3Client, bucket, key string) (st
 	return lockInfo.LockID, nil
 }
 
+// FetchCurrentSHA returns the commit SHA of the running pipeline, falling back
+// to the local git commit SHA when no CI commit SHA is available.
+func FetchCurrentSHA() (string, error) {
+	if commitSHA := ci.Detect().Commit; commitSHA != "" {
+		return commitSHA, nil
+	}
+	return FetchLocalSHA()
+}
+
 // CompareSHAs compares the local and remote git commit SHAs and returns them.
 func CompareSHAs(ctx context.Context, cli t.S3Client, bucket, key string) (bool, error) {
-	var localSHA, remoteSHA string
-	var err error
-
-	CI_COMMIT_SHA := os.Getenv("CI_COMMIT_SHA")
-	if CI_COMMIT_SHA == "" {
-		localSHA, err = FetchLocalSHA()
-		if err != nil {
-			return false, err
-		}
-	} else {
-		localSHA = CI_COMMIT_SHA
+	localSHA, err := FetchCurrentSHA()
+	if err != nil {
+		return false, err
 	}
 
-	remoteSHA, err = FetchRemoteSHA(ctx, cli, bucket, key)
+	remoteSHA, err := FetchRemoteSHA(ctx, cli, bucket, key)
 	if err != nil {
 		return false, err
 	}
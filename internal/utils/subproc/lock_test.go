@@ -45,3 +45,23 @@ func TestFetchRemoteSHA(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, expectedSHA, result)
 }
+
+func TestFetchRemoteSHAUnversionedLock(t *testing.T) {
+	ctx := context.Background()
+	lockInfoRaw := []byte(`{"lock_id":"1234567890","timestamp":"2021-01-01T00:00:00Z","signer":"test","comments":{"commit":"ok","trigger":"ok","extra":""}}`)
+
+	// Mock setup
+	mockS3 := new(test.MockS3Client)
+	mockS3.On("GetObject", mock.AnythingOfType("backgroundCtx"), mock.AnythingOfType("*s3.GetObjectInput"), mock.AnythingOfType("[]func(*s3.Options)")).Return(
+		&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader(lockInfoRaw)),
+		}, nil,
+	)
+
+	// Test function call
+	result, err := subproc.FetchRemoteSHA(ctx, mockS3, "bucket-name", "file-path")
+
+	// Assertions
+	require.NoError(t, err)
+	assert.Equal(t, "1234567890", result)
+}
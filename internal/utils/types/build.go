@@ -0,0 +1,34 @@
+package types
+
+// BuildInfo describes the CI build that runs statectl.
+type BuildInfo struct {
+	Provider   string `json:"provider,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+	PipelineID string `json:"pipeline_id,omitempty"`
+	JobURL     string `json:"job_url,omitempty"`
+	Actor      string `json:"actor,omitempty"`
+}
+
+// Merge returns the build info with the non-empty fields of override applied.
+func (b BuildInfo) Merge(override BuildInfo) BuildInfo {
+	if override.Provider != "" {
+		b.Provider = override.Provider
+	}
+	if override.Commit != "" {
+		b.Commit = override.Commit
+	}
+	if override.Branch != "" {
+		b.Branch = override.Branch
+	}
+	if override.PipelineID != "" {
+		b.PipelineID = override.PipelineID
+	}
+	if override.JobURL != "" {
+		b.JobURL = override.JobURL
+	}
+	if override.Actor != "" {
+		b.Actor = override.Actor
+	}
+	return b
+}
@@ -0,0 +1,38 @@
+package types
+
+// Types of the lock events recorded in the audit trail
+const (
+	EventAcquire      = "acquire"
+	EventRelease      = "release"
+	EventForceRelease = "force-release"
+	EventTakeover     = "takeover"
+	EventForceAcquire = "force-acquire"
+	EventRenew        = "renew"
+)
+
+// EventTypes lists every type of lock event.
+var EventTypes = []string{EventAcquire, EventRelease, EventForceRelease, EventTakeover, EventForceAcquire, EventRenew}
+
+type LockEvent struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Time    string    `json:"time"`
+	Bucket  string    `json:"bucket"`
+	Key     string    `json:"key"`
+	Actor   string    `json:"actor"`
+	LockID  string    `json:"lock_id"`
+	Signer  string    `json:"signer"`
+	HeldFor string    `json:"held_for,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Lock    *LockInfo `json:"lock,omitempty"`
+}
+
+// ForceReleaseRecord is written next to a lock before it is force-released.
+type ForceReleaseRecord struct {
+	Time   string    `json:"time"`
+	Bucket string    `json:"bucket"`
+	Key    string    `json:"key"`
+	Actor  string    `json:"actor"`
+	Reason string    `json:"reason"`
+	Lock   *LockInfo `json:"lock"`
+}
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Comments struct {
 	Commit  string `json:"commit"`
 	Trigger string `json:"trigger"`
@@ -7,8 +12,170 @@ type Comments struct {
 }
 
 type LockInfo struct {
-	LockID    string   `json:"lock_id"`
-	TimeStamp string   `json:"timestamp"`
-	Signer    string   `json:"signer"`
-	Comments  Comments `json:"comments"`
+	SchemaVersion int        `json:"schema_version,omitempty"`
+	LockID        string     `json:"lock_id"`
+	TimeStamp     string     `json:"timestamp"`
+	Signer        string     `json:"signer"`
+	Comments      Comments   `json:"comments"`
+	OwnerToken    string     `json:"owner_token,omitempty"`
+	Mode          string     `json:"mode,omitempty"`
+	FencingToken  int64      `json:"fencing_token,omitempty"`
+	Build         *BuildInfo `json:"build,omitempty"`
+	TTL           string     `json:"ttl,omitempty"`
+	ExpiresAt     string     `json:"expires_at,omitempty"`
+	Heartbeat     string     `json:"heartbeat,omitempty"`
+	Takeover      string     `json:"takeover,omitempty"`
+	Reason        string     `json:"reason,omitempty"`
+	PreviousOwner *LockInfo  `json:"previous_owner,omitempty"`
+}
+
+// LockSchemaVersion is the version of the lock documents written by this
+// statectl. Locks written before the schema was versioned have version 0.
+const LockSchemaVersion = 1
+
+// lockUpgrades upgrade a lock document from the schema version of their index
+// to the next one.
+var lockUpgrades = []func(l *LockInfo){
+	// 0 to 1: the mode was implicit, unversioned locks are exclusive
+	func(l *LockInfo) {
+		if l.Mode == "" {
+			l.Mode = LockModeExclusive
+		}
+	},
+}
+
+// DecodeLockInfo decodes a lock document of any schema version, and upgrades the
+// documents of older versions to LockSchemaVersion. Documents of a newer version
+// are decoded as far as they are understood and keep their version, so callers
+// check Supported before they change the lock.
+func DecodeLockInfo(raw []byte) (LockInfo, error) {
+	lockInfo := LockInfo{}
+	if err := json.Unmarshal(raw, &lockInfo); err != nil {
+		return lockInfo, err
+	}
+	lockInfo.upgrade()
+	return lockInfo, nil
+}
+
+// upgrade brings the lock and its previous owner to LockSchemaVersion.
+func (l *LockInfo) upgrade() {
+	if l.PreviousOwner != nil {
+		previous := *l.PreviousOwner
+		previous.upgrade()
+		l.PreviousOwner = &previous
+	}
+	if l.SchemaVersion < 0 {
+		l.SchemaVersion = 0
+	}
+	for l.SchemaVersion < LockSchemaVersion {
+		lockUpgrades[l.SchemaVersion](l)
+		l.SchemaVersion++
+	}
+}
+
+// Supported reports whether this statectl understands the schema version of the
+// lock, and can change it without losing fields it does not know.
+func (l LockInfo) Supported() bool {
+	return l.SchemaVersion <= LockSchemaVersion
+}
+
+// Fence holds the fencing tokens of a lock. Every acquisition gets a new token,
+// and writes to the state are refused with a token older than the one the lock
+// was last acquired with, or than the committed one.
+type Fence struct {
+	Token     int64  `json:"token"`
+	Acquired  int64  `json:"acquired,omitempty"`
+	Committed int64  `json:"committed"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Kinds of takeover of a lock from its previous owner
+const (
+	// TakeoverExpired marks a lock that was reclaimed after its lease expired.
+	TakeoverExpired = "expired"
+	// TakeoverForced marks a lock that was replaced with force-acquire.
+	TakeoverForced = "forced"
+)
+
+// Modes of a lock, a lock without a mode is exclusive
+const (
+	// LockModeExclusive is held by a single writer of the state.
+	LockModeExclusive = "exclusive"
+	// LockModeShared is held together by the readers of the state.
+	LockModeShared = "shared"
+)
+
+// Shared reports whether the lock is held in shared mode.
+func (l LockInfo) Shared() bool {
+	return l.Mode == LockModeShared
+}
+
+// Age returns how long ago the lock was acquired.
+func (l LockInfo) Age(now time.Time) (time.Duration, bool) {
+	acquiredAt, err := time.Parse(time.RFC3339, l.TimeStamp)
+	if err != nil {
+		return 0, false
+	}
+	return now.Sub(acquiredAt), true
+}
+
+// Expiry returns the time the lease ends. Locks without a lease never expire.
+func (l LockInfo) Expiry() (time.Time, bool) {
+	if l.ExpiresAt == "" {
+		return time.Time{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, l.ExpiresAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// Expired reports whether the lease of the lock has ended at the given time.
+func (l LockInfo) Expired(now time.Time) bool {
+	expiresAt, ok := l.Expiry()
+	return ok && !now.Before(expiresAt)
+}
+
+// Remaining returns the time left on the lease, or false if the lock never expires.
+func (l LockInfo) Remaining(now time.Time) (time.Duration, bool) {
+	expiresAt, ok := l.Expiry()
+	if !ok {
+		return 0, false
+	}
+	if remaining := expiresAt.Sub(now); remaining > 0 {
+		return remaining, true
+	}
+	return 0, true
+}
+
+// Lease returns the lease duration of the lock, or zero if the lock never expires.
+func (l LockInfo) Lease() time.Duration {
+	if l.TTL == "" {
+		return 0
+	}
+	ttl, err := time.ParseDuration(l.TTL)
+	if err != nil {
+		return 0
+	}
+	return ttl
+}
+
+// WithLease sets the lease of the lock to ttl starting at now. A zero ttl removes the lease.
+func (l LockInfo) WithLease(now time.Time, ttl time.Duration) LockInfo {
+	if ttl <= 0 {
+		l.TTL = ""
+		l.ExpiresAt = ""
+		return l
+	}
+	l.TTL = ttl.String()
+	l.ExpiresAt = now.Add(ttl).UTC().Format(time.RFC3339)
+	return l
+}
+
+// NamedLock is a lock that was found through its name in the key template.
+type NamedLock struct {
+	Name string   `json:"name"`
+	Key  string   `json:"key"`
+	Lock LockInfo `json:"lock"`
 }
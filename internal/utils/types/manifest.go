@@ -1,8 +1,28 @@
 package types
 
 type State struct {
-	VersionID string `json:"version_id"`
-	CommitSHA string `json:"commit_sha"`
-	Bucket    string `json:"bucket"`
-	Key       string `json:"key"`
+	VersionID    string     `json:"version_id"`
+	CommitSHA    string     `json:"commit_sha"`
+	Bucket       string     `json:"bucket"`
+	Key          string     `json:"key"`
+	FencingToken int64      `json:"fencing_token,omitempty"`
+	Snapshot     string     `json:"snapshot,omitempty"`
+	Build        *BuildInfo `json:"build,omitempty"`
+}
+
+// Snapshot describes an immutable snapshot of the manifest tree made by a push.
+// The "current" pointer of the manifest holds the snapshot that pull reads.
+// VersionID is the S3 version ID of the manifest object of the snapshot, if the
+// bucket is versioned. A snapshot made by a rollback Restores an earlier one.
+type Snapshot struct {
+	ID           string     `json:"id"`
+	Prefix       string     `json:"prefix"`
+	CommitSHA    string     `json:"commit_sha"`
+	VersionID    string     `json:"version_id,omitempty"`
+	CreatedAt    string     `json:"created_at"`
+	Files        int        `json:"files"`
+	FencingToken int64      `json:"fencing_token,omitempty"`
+	Previous     string     `json:"previous,omitempty"`
+	Restores     string     `json:"restores,omitempty"`
+	Build        *BuildInfo `json:"build,omitempty"`
 }
@@ -0,0 +1,22 @@
+package types
+
+import "time"
+
+// Ticket is the place of a waiter in the queue of a lock. Tickets are served in
+// increasing order of their number, and a ticket whose lease expired is skipped
+// as abandoned.
+type Ticket struct {
+	Number     int64  `json:"number"`
+	LockID     string `json:"lock_id"`
+	Signer     string `json:"signer"`
+	OwnerToken string `json:"owner_token,omitempty"`
+	Mode       string `json:"mode,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+// Expired reports whether the waiter stopped renewing the ticket before the given time.
+func (t Ticket) Expired(now time.Time) bool {
+	expiresAt, err := time.Parse(time.RFC3339Nano, t.ExpiresAt)
+	return err == nil && !now.Before(expiresAt)
+}
@@ -0,0 +1,292 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// FakeDynamoDBClient is an in-memory DynamoDB table with the partition key
+// LockID. It understands the expressions used by the lock: conditions made of
+// "attribute_not_exists(#name)" and comparisons such as "#name = :value" or
+// "#name <= :value", joined with AND, OR and parentheses, and the updates
+// "ADD #name :value" of a number and "SET #name = :value".
+type FakeDynamoDBClient struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+}
+
+// NewFakeDynamoDBClient returns an empty in-memory table.
+func NewFakeDynamoDBClient() *FakeDynamoDBClient {
+	return &FakeDynamoDBClient{items: map[string]map[string]types.AttributeValue{}}
+}
+
+// Item returns the item with the given LockID.
+func (f *FakeDynamoDBClient) Item(lockID string) (map[string]types.AttributeValue, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[lockID]
+	return item, ok
+}
+
+// LockIDs returns the sorted LockIDs of the items that start with prefix.
+func (f *FakeDynamoDBClient) LockIDs(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := []string{}
+	for id := range f.items {
+		if strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *FakeDynamoDBClient) GetItem(ctx context.Context, input *dynamodb.GetItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lockID, err := partitionKey(input.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.GetItemOutput{Item: f.items[lockID]}, nil
+}
+
+func (f *FakeDynamoDBClient) PutItem(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lockID, err := partitionKey(input.Item)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.check(lockID, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	f.items[lockID] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *FakeDynamoDBClient) DeleteItem(ctx context.Context, input *dynamodb.DeleteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lockID, err := partitionKey(input.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.check(lockID, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	delete(f.items, lockID)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *FakeDynamoDBClient) UpdateItem(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lockID, err := partitionKey(input.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.check(lockID, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+
+	item, ok := f.items[lockID]
+	if !ok {
+		item = map[string]types.AttributeValue{"LockID": &types.AttributeValueMemberS{Value: lockID}}
+	}
+
+	fields := strings.Fields(aws.ToString(input.UpdateExpression))
+	var name string
+	var updated types.AttributeValue
+	switch {
+	case len(fields) == 3 && fields[0] == "ADD":
+		name = input.ExpressionAttributeNames[fields[1]]
+		delta, err := numberOf(input.ExpressionAttributeValues[fields[2]])
+		if err != nil {
+			return nil, err
+		}
+		value := int64(0)
+		if current, ok := item[name]; ok {
+			if value, err = numberOf(current); err != nil {
+				return nil, err
+			}
+		}
+		updated = &types.AttributeValueMemberN{Value: strconv.FormatInt(value+delta, 10)}
+	case len(fields) == 4 && fields[0] == "SET" && fields[2] == "=":
+		name = input.ExpressionAttributeNames[fields[1]]
+		updated = input.ExpressionAttributeValues[fields[3]]
+	default:
+		return nil, fmt.Errorf("unsupported update expression %q", aws.ToString(input.UpdateExpression))
+	}
+	item[name] = updated
+	f.items[lockID] = item
+
+	return &dynamodb.UpdateItemOutput{Attributes: map[string]types.AttributeValue{name: updated}}, nil
+}
+
+// check evaluates the condition expression against the stored item.
+func (f *FakeDynamoDBClient) check(lockID string, condition *string, names map[string]string, values map[string]types.AttributeValue) error {
+	if condition == nil {
+		return nil
+	}
+
+	expr := aws.ToString(condition)
+	e := &conditionEval{
+		tokens: strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expr)),
+		item:   f.items[lockID],
+		names:  names,
+		values: values,
+	}
+	ok, err := e.or()
+	if err == nil && e.pos != len(e.tokens) {
+		err = fmt.Errorf("unexpected %q", e.tokens[e.pos])
+	}
+	if err != nil {
+		return fmt.Errorf("unsupported condition expression %q: %w", expr, err)
+	}
+
+	if !ok {
+		return &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	return nil
+}
+
+// conditionEval evaluates a condition expression, token by token.
+type conditionEval struct {
+	tokens []string
+	pos    int
+	item   map[string]types.AttributeValue
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func (e *conditionEval) next() string {
+	if e.pos >= len(e.tokens) {
+		return ""
+	}
+	e.pos++
+	return e.tokens[e.pos-1]
+}
+
+func (e *conditionEval) peek() string {
+	if e.pos >= len(e.tokens) {
+		return ""
+	}
+	return e.tokens[e.pos]
+}
+
+func (e *conditionEval) or() (bool, error) {
+	ok, err := e.and()
+	for err == nil && e.peek() == "OR" {
+		e.next()
+		var right bool
+		right, err = e.and()
+		ok = ok || right
+	}
+	return ok, err
+}
+
+func (e *conditionEval) and() (bool, error) {
+	ok, err := e.operand()
+	for err == nil && e.peek() == "AND" {
+		e.next()
+		var right bool
+		right, err = e.operand()
+		ok = ok && right
+	}
+	return ok, err
+}
+
+func (e *conditionEval) operand() (bool, error) {
+	switch token := e.next(); token {
+	case "(":
+		ok, err := e.or()
+		if err == nil && e.next() != ")" {
+			err = fmt.Errorf("missing )")
+		}
+		return ok, err
+	case "attribute_exists", "attribute_not_exists":
+		opening, name, closing := e.next(), e.next(), e.next()
+		if opening != "(" || closing != ")" {
+			return false, fmt.Errorf("invalid %s", token)
+		}
+		_, has := e.item[e.names[name]]
+		return has == (token == "attribute_exists"), nil
+	default:
+		op, value := e.next(), e.next()
+		stored, has := e.item[e.names[token]]
+		if !has {
+			return false, nil
+		}
+		return compare(stored, op, e.values[value])
+	}
+}
+
+// compare applies the comparison operator to two values of the same type.
+func compare(a types.AttributeValue, op string, b types.AttributeValue) (bool, error) {
+	if op == "=" || op == "<>" {
+		return equal(a, b) == (op == "="), nil
+	}
+
+	x, err := numberOf(a)
+	if err != nil {
+		return false, err
+	}
+	y, err := numberOf(b)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	case ">=":
+		return x >= y, nil
+	}
+	return false, fmt.Errorf("unsupported operator %q", op)
+}
+
+func partitionKey(key map[string]types.AttributeValue) (string, error) {
+	attr, ok := key["LockID"].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", fmt.Errorf("missing string partition key LockID")
+	}
+	return attr.Value, nil
+}
+
+func numberOf(attr types.AttributeValue) (int64, error) {
+	n, ok := attr.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("attribute is not a number")
+	}
+	return strconv.ParseInt(n.Value, 10, 64)
+}
+
+func equal(a, b types.AttributeValue) bool {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		b, ok := b.(*types.AttributeValueMemberS)
+		return ok && a.Value == b.Value
+	case *types.AttributeValueMemberN:
+		b, ok := b.(*types.AttributeValueMemberN)
+		return ok && a.Value == b.Value
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// FakeS3Client is an in-memory S3 bucket that honours the If-Match and
+// If-None-Match conditions of PutObject and DeleteObject. It is meant for tests
+// that follow several processes through a sequence of requests, where mocking
+// every call in order would hide the behaviour under test. The bucket is
+// versioned: every write gets a version ID, and earlier versions can be read.
+// Like S3, the ETag of an object is the MD5 digest of its body.
+type FakeS3Client struct {
+	mu       sync.Mutex
+	objects  map[string]fakeObject
+	versions map[string][]fakeObject
+	version  int
+}
+
+type fakeObject struct {
+	body      []byte
+	etag      string
+	versionID string
+	metadata  map[string]string
+}
+
+var errFakePreconditionFailed = &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold"}
+
+// NewFakeS3Client returns an empty in-memory bucket.
+func NewFakeS3Client() *FakeS3Client {
+	return &FakeS3Client{objects: map[string]fakeObject{}, versions: map[string][]fakeObject{}}
+}
+
+// Keys returns the keys of the stored objects under prefix, in lexical order.
+func (f *FakeS3Client) Keys(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keys(prefix)
+}
+
+// Object returns the body of the object at key.
+func (f *FakeS3Client) Object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[key]
+	return object.body, ok
+}
+
+func (f *FakeS3Client) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := aws.ToString(input.Key)
+	if err := f.checkConditions(key, input.IfMatch, input.IfNoneMatch); err != nil {
+		return nil, err
+	}
+
+	object := f.store(key, body, input.Metadata)
+	return &s3.PutObjectOutput{ETag: aws.String(object.etag), VersionId: aws.String(object.versionID)}, nil
+}
+
+// CopyObject copies the object at CopySource, given as the URL-encoded
+// "bucket/key" with an optional "?versionId=" suffix, to Key.
+func (f *FakeS3Client) CopyObject(ctx context.Context, input *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	source, versionID, _ := strings.Cut(aws.ToString(input.CopySource), "?versionId=")
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		return nil, err
+	}
+	_, sourceKey, _ := strings.Cut(source, "/")
+
+	var sourceVersion *string
+	if versionID != "" {
+		sourceVersion = aws.String(versionID)
+	}
+	object, ok := f.object(sourceKey, sourceVersion)
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+
+	metadata := object.metadata
+	if input.MetadataDirective == types.MetadataDirectiveReplace {
+		metadata = input.Metadata
+	}
+	copied := f.store(aws.ToString(input.Key), object.body, metadata)
+	return &s3.CopyObjectOutput{
+		CopyObjectResult:    &types.CopyObjectResult{ETag: aws.String(copied.etag)},
+		CopySourceVersionId: aws.String(object.versionID),
+		VersionId:           aws.String(copied.versionID),
+	}, nil
+}
+
+// store writes a new version of the object at key.
+func (f *FakeS3Client) store(key string, body []byte, metadata map[string]string) fakeObject {
+	f.version++
+	object := fakeObject{
+		body:      body,
+		etag:      fmt.Sprintf(`"%x"`, md5.Sum(body)),
+		versionID: fmt.Sprintf("v%d", f.version),
+		metadata:  metadata,
+	}
+	f.objects[key] = object
+	f.versions[key] = append(f.versions[key], object)
+	return object
+}
+
+func (f *FakeS3Client) GetObject(ctx context.Context, input *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	object, ok := f.object(aws.ToString(input.Key), input.VersionId)
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{
+		Body:      io.NopCloser(bytes.NewReader(object.body)),
+		ETag:      aws.String(object.etag),
+		VersionId: aws.String(object.versionID),
+		Metadata:  object.metadata,
+	}, nil
+}
+
+func (f *FakeS3Client) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, opts ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := aws.ToString(input.Key)
+	if input.IfMatch != nil {
+		if err := f.checkConditions(key, input.IfMatch, nil); err != nil {
+			return nil, err
+		}
+	}
+	delete(f.objects, key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *FakeS3Client) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	object, ok := f.object(aws.ToString(input.Key), input.VersionId)
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{
+		ETag:          aws.String(object.etag),
+		VersionId:     aws.String(object.versionID),
+		ContentLength: aws.Int64(int64(len(object.body))),
+		Metadata:      object.metadata,
+	}, nil
+}
+
+func (f *FakeS3Client) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	contents := []types.Object{}
+	for _, key := range f.keys(aws.ToString(input.Prefix)) {
+		object := f.objects[key]
+		contents = append(contents, types.Object{
+			Key:  aws.String(key),
+			ETag: aws.String(object.etag),
+			Size: aws.Int64(int64(len(object.body))),
+		})
+	}
+	return &s3.ListObjectsV2Output{Contents: contents, KeyCount: aws.Int32(int32(len(contents)))}, nil
+}
+
+// object returns the current object at key, or its version versionID if set.
+func (f *FakeS3Client) object(key string, versionID *string) (fakeObject, bool) {
+	if versionID == nil {
+		object, ok := f.objects[key]
+		return object, ok
+	}
+	for _, object := range f.versions[key] {
+		if object.versionID == aws.ToString(versionID) {
+			return object, true
+		}
+	}
+	return fakeObject{}, false
+}
+
+func (f *FakeS3Client) keys(prefix string) []string {
+	keys := []string{}
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *FakeS3Client) checkConditions(key string, ifMatch, ifNoneMatch *string) error {
+	object, exists := f.objects[key]
+	if ifNoneMatch != nil && exists {
+		return errFakePreconditionFailed
+	}
+	if ifMatch != nil && (!exists || object.etag != aws.ToString(ifMatch)) {
+		return errFakePreconditionFailed
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"statectl/internal/utils/types"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockFence mocks the fence object of the lock at key, holding the given last
+// fencing token. It has to be set up before the other mocks of the client, so
+// the requests for the fence do not match them.
+func MockFence(m *MockS3Client, key string, token int64) {
+	fenceKey := key + ".fence"
+
+	m.On("GetObject", mock.Anything, mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		return aws.ToString(input.Key) == fenceKey
+	}), mock.Anything).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			raw, _ := json.Marshal(types.Fence{Token: token})
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(bytes.NewReader(raw)),
+				ETag: aws.String(`"fence"`),
+			}
+		}, nil,
+	).Maybe()
+	m.On("PutObject", mock.Anything, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.Key) == fenceKey
+	}), mock.Anything).Return(
+		&s3.PutObjectOutput{}, nil,
+	).Maybe()
+}
@@ -7,9 +7,12 @@ import (
 
 func CreateLockInfo(expectedSHA string) (types.LockInfo, []byte) {
 	lockInfo := types.LockInfo{
-		LockID:    expectedSHA,
-		TimeStamp: "2021-01-01T00:00:00Z",
-		Signer:    "test",
+		SchemaVersion: types.LockSchemaVersion,
+		LockID:        expectedSHA,
+		TimeStamp:     "2021-01-01T00:00:00Z",
+		Signer:        "test",
+		OwnerToken:    expectedSHA + "-token",
+		Mode:          types.LockModeExclusive,
 		Comments: types.Comments{
 			Commit:  "ok",
 			Trigger: "ok",
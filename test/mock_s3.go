@@ -18,6 +18,10 @@ func (m *MockS3Client) PutObject(ctx context.Context, input *s3.PutObjectInput,
 
 func (m *MockS3Client) GetObject(ctx context.Context, input *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
 	args := m.Called(ctx, input, opts)
+	// Allow a function as return value, so repeated calls can get a fresh body
+	if fn, ok := args.Get(0).(func(*s3.GetObjectInput) *s3.GetObjectOutput); ok {
+		return fn(input), args.Error(1)
+	}
 	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
 }
 
@@ -25,3 +29,18 @@ func (m *MockS3Client) DeleteObject(ctx context.Context, input *s3.DeleteObjectI
 	args := m.Called(ctx, input, opts)
 	return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
 }
+
+func (m *MockS3Client) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	args := m.Called(ctx, input, opts)
+	return args.Get(0).(*s3.HeadObjectOutput), args.Error(1)
+}
+
+func (m *MockS3Client) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	args := m.Called(ctx, input, opts)
+	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
+}
+
+func (m *MockS3Client) CopyObject(ctx context.Context, input *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	args := m.Called(ctx, input, opts)
+	return args.Get(0).(*s3.CopyObjectOutput), args.Error(1)
+}
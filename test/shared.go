@@ -0,0 +1,47 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"statectl/internal/utils/types"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockSharedLocks mocks the shared holders of the lock at key, stored by their
+// owner token. Like MockFence, it has to be set up before the other mocks of
+// the client.
+func MockSharedLocks(m *MockS3Client, key string, holders ...types.LockInfo) {
+	prefix := key + ".shared/"
+
+	objects := []s3types.Object{}
+	stored := map[string]types.LockInfo{}
+	for _, holder := range holders {
+		sharedKey := prefix + holder.OwnerToken + ".json"
+		objects = append(objects, s3types.Object{Key: aws.String(sharedKey)})
+		stored[sharedKey] = holder
+	}
+
+	m.On("ListObjectsV2", mock.Anything, mock.MatchedBy(func(input *s3.ListObjectsV2Input) bool {
+		return aws.ToString(input.Prefix) == prefix
+	}), mock.Anything).Return(
+		&s3.ListObjectsV2Output{Contents: objects}, nil,
+	).Maybe()
+	m.On("GetObject", mock.Anything, mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		_, ok := stored[aws.ToString(input.Key)]
+		return ok
+	}), mock.Anything).Return(
+		func(input *s3.GetObjectInput) *s3.GetObjectOutput {
+			raw, _ := json.Marshal(stored[aws.ToString(input.Key)])
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(bytes.NewReader(raw)),
+				ETag: aws.String(`"` + strings.TrimSuffix(strings.TrimPrefix(aws.ToString(input.Key), prefix), ".json") + `"`),
+			}
+		}, nil,
+	).Maybe()
+}